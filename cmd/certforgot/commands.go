@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/figglewatts/certforgot/internal/app"
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/renew"
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+func runCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "run",
+		Short: "Renew every certificate that is due, for use from cron or CI",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return renewTargets(cmd, opts, nil, false)
+		},
+	}
+}
+
+func renewCommand(opts *options) *cobra.Command {
+	var force bool
+	command := &cobra.Command{
+		Use:   "renew [cert name...]",
+		Short: "Renew the named certificates, or all if none are named",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return renewTargets(cmd, opts, args, force)
+		},
+	}
+	command.Flags().BoolVarP(
+		&force, "force", "f", false,
+		"renew even if not yet due or the current certificate can't be read",
+	)
+	return command
+}
+
+func renewTargets(
+	cmd *cobra.Command, opts *options, names []string, force bool,
+) (err error) {
+	ctx := cmd.Context()
+	conf, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	targets, err := targetsFrom(conf, names)
+	if err != nil {
+		return exitError{ExitUsage, err}
+	}
+
+	stateSource, err := stateSourceFrom(ctx, conf.State)
+	if err != nil {
+		return exitError{ExitFailure, fmt.Errorf("creating state source: %v", err)}
+	}
+
+	// only one instance renews at a time, so accounts and orders don't race
+	ctx, release, err := lockState(ctx, conf.State, stateSource)
+	if err != nil {
+		return exitError{ExitFailure, fmt.Errorf("locking state: %v", err)}
+	}
+	defer func() {
+		if releaseErr := release(); releaseErr != nil && err == nil {
+			err = exitError{
+				ExitFailure, fmt.Errorf("releasing state lock: %v", releaseErr),
+			}
+		}
+	}()
+
+	// the account is only bootstrapped once a certificate is being renewed
+	issuer, err := renew.NewLazyIssuer(
+		func(ctx context.Context) (renew.Issuer, error) {
+			client, err := acmeClientFrom(ctx, conf, stateSource)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		},
+	)
+	if err != nil {
+		return exitError{ExitFailure, err}
+	}
+
+	engine, err := renew.NewEngine(issuer, stateSource, conf.GlobalPolicy)
+	if err != nil {
+		return exitError{ExitFailure, err}
+	}
+
+	report := engine.Renew(ctx, targets, force)
+	printReport(cmd, report)
+	if report.Failed() {
+		return exitError{ExitFailure, fmt.Errorf("renewal failed")}
+	}
+	return nil
+}
+
+func checkCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check [cert name...]",
+		Short: "Report whether certificates are due for renewal",
+		Long: fmt.Sprintf(
+			"Report whether certificates are due for renewal, exiting with "+
+				"%d if any are due and %d if any could not be checked.",
+			ExitRenewalDue, ExitFailure,
+		),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			targets, err := targetsFrom(conf, args)
+			if err != nil {
+				return exitError{ExitUsage, err}
+			}
+
+			engine, err := renew.NewEngine(nil, nil, conf.GlobalPolicy)
+			if err != nil {
+				return exitError{ExitFailure, err}
+			}
+
+			report := engine.Check(cmd.Context(), targets)
+			printReport(cmd, report)
+			if report.Failed() {
+				return exitError{ExitFailure, fmt.Errorf("check failed")}
+			}
+			if report.Due() {
+				return exitError{
+					ExitRenewalDue, fmt.Errorf("renewal due"),
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func historyCommand(opts *options) *cobra.Command {
+	var since string
+	var limit int
+	command := &cobra.Command{
+		Use:   "history [cert name...]",
+		Short: "Show the certificates issued, or that failed to issue",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			conf, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			query := state.HistoryQuery{Names: args, Limit: limit}
+			if since != "" {
+				duration, err := app.ParseDuration(since)
+				if err != nil {
+					return exitError{
+						ExitUsage, fmt.Errorf("bad --since: %v", err),
+					}
+				}
+				query.Since = time.Now().Add(-duration)
+			}
+
+			stateSource, err := stateSourceFrom(ctx, conf.State)
+			if err != nil {
+				return exitError{ExitFailure, err}
+			}
+
+			history, err := stateSource.History(ctx, query)
+			if err != nil {
+				return exitError{ExitFailure, err}
+			}
+			if len(history) == 0 {
+				cmd.Println("no issuances recorded")
+			}
+			for _, issuance := range history {
+				cmd.Println(issuance)
+			}
+			return nil
+		},
+	}
+	command.Flags().StringVar(
+		&since, "since", "", "only show issuances this long ago or later, e.g. 7d",
+	)
+	command.Flags().IntVarP(
+		&limit, "limit", "n", 0, "only show the most recent issuances",
+	)
+	return command
+}
+
+func stateCommand(opts *options) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect the account state",
+	}
+	command.AddCommand(
+		&cobra.Command{
+			Use:   "init",
+			Short: "Create the ACME account, or update its contact email",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) (err error) {
+				ctx := cmd.Context()
+				conf, err := opts.loadConfig()
+				if err != nil {
+					return err
+				}
+
+				stateSource, err := stateSourceFrom(ctx, conf.State)
+				if err != nil {
+					return exitError{ExitFailure, err}
+				}
+
+				ctx, release, err := lockState(ctx, conf.State, stateSource)
+				if err != nil {
+					return exitError{
+						ExitFailure, fmt.Errorf("locking state: %v", err),
+					}
+				}
+				defer func() {
+					if releaseErr := release(); releaseErr != nil && err == nil {
+						err = exitError{
+							ExitFailure,
+							fmt.Errorf("releasing state lock: %v", releaseErr),
+						}
+					}
+				}()
+
+				client, err := acmeClientFrom(ctx, conf, stateSource)
+				if err != nil {
+					return exitError{ExitFailure, err}
+				}
+				cmd.Printf("account: %s\n", client.AccountUrl())
+				return nil
+			},
+		},
+		migrateCommand(opts),
+		&cobra.Command{
+			Use:   "show",
+			Short: "Show the email and key thumbprint of each account",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				ctx := cmd.Context()
+				conf, err := opts.loadConfig()
+				if err != nil {
+					return err
+				}
+
+				stateSource, err := stateSourceFrom(ctx, conf.State)
+				if err != nil {
+					return exitError{ExitFailure, err}
+				}
+
+				keys, err := stateSource.List(ctx)
+				if err != nil {
+					return exitError{ExitFailure, err}
+				}
+				if len(keys) == 0 {
+					return exitError{ExitFailure, fmt.Errorf("no state found")}
+				}
+
+				configured := accountKeyFrom(conf.Acme)
+				for i, key := range keys {
+					s, _, err := stateSource.Get(ctx, key)
+					if err != nil {
+						return exitError{ExitFailure, err}
+					}
+
+					thumbprint, err := acme.Thumbprint(s.UserPrivateKey.Key)
+					if err != nil {
+						return exitError{ExitFailure, err}
+					}
+
+					if i > 0 {
+						cmd.Println()
+					}
+					cmd.Printf("account: %s\n", key)
+					if key == configured {
+						cmd.Printf("configured: true\n")
+					}
+					cmd.Printf("email: %s\n", s.UserEmail.Address.Address)
+					cmd.Printf("key type: %s\n", s.UserPrivateKey.KeyType())
+					cmd.Printf("key thumbprint: %s\n", thumbprint)
+				}
+				return nil
+			},
+		},
+	)
+	return command
+}
+
+func migrateCommand(opts *options) *cobra.Command {
+	var fromBackend, toBackend string
+	command := &cobra.Command{
+		Use:   "migrate --from <backend> --to <backend>",
+		Short: "Copy the accounts and history from one state backend to another",
+		Long: "Copy the accounts and history from one state backend to another, " +
+			"checking each account reads back the same. A backend is given " +
+			"as a state block like the config file's, either inline as YAML, " +
+			"e.g. '{local: {directory: ./state}}', or as a file with a state " +
+			"block, so a config file can be given too. Either defaults to " +
+			"the config file.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			ctx := cmd.Context()
+			if fromBackend == "" && toBackend == "" {
+				return exitError{
+					ExitUsage, fmt.Errorf("--from or --to must be given"),
+				}
+			}
+
+			from, err := stateSourceAt(ctx, opts, fromBackend)
+			if err != nil {
+				return exitError{ExitFailure, fmt.Errorf("from: %v", err)}
+			}
+			to, err := stateSourceAt(ctx, opts, toBackend)
+			if err != nil {
+				return exitError{ExitFailure, fmt.Errorf("to: %v", err)}
+			}
+
+			// lock both so nothing renews out of the source or into the
+			// destination mid migration
+			for _, side := range []struct {
+				name    string
+				backend configuredSource
+			}{{"from", from}, {"to", to}} {
+				var release func() error
+				ctx, release, err = lockState(
+					ctx, side.backend.conf, side.backend.source,
+				)
+				if err != nil {
+					return exitError{
+						ExitFailure,
+						fmt.Errorf("%s: locking state: %v", side.name, err),
+					}
+				}
+				name := side.name
+				defer func() {
+					if releaseErr := release(); releaseErr != nil && err == nil {
+						err = exitError{
+							ExitFailure, fmt.Errorf(
+								"%s: releasing state lock: %v", name, releaseErr,
+							),
+						}
+					}
+				}()
+			}
+
+			result, err := state.Migrate(ctx, from.source, to.source)
+			for _, key := range result.Accounts {
+				cmd.Printf("migrated account: %s\n", key)
+			}
+			if err != nil {
+				return exitError{ExitFailure, err}
+			}
+			cmd.Printf(
+				"migrated %d accounts and %d issuances\n",
+				len(result.Accounts), result.Issuances,
+			)
+			return nil
+		},
+	}
+	command.Flags().StringVar(
+		&fromBackend, "from", "",
+		"state backend to copy from, as inline YAML or a file",
+	)
+	command.Flags().StringVar(
+		&toBackend, "to", "",
+		"state backend to copy to, as inline YAML or a file",
+	)
+	return command
+}
+
+func configCommand(opts *options) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the config file",
+	}
+	command.AddCommand(
+		&cobra.Command{
+			Use:   "validate",
+			Short: "Check that the config file is valid",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				conf, err := opts.loadConfig()
+				if err != nil {
+					return err
+				}
+
+				if _, err := targetsFrom(conf, nil); err != nil {
+					return exitError{ExitUsage, err}
+				}
+
+				cmd.Printf(
+					"%s is valid: %d certs, %d validators\n", opts.configPath,
+					len(conf.Certs), len(conf.Validators),
+				)
+				return nil
+			},
+		},
+	)
+	return command
+}
+
+func printReport(cmd *cobra.Command, report renew.Report) {
+	for _, outcome := range report {
+		cmd.Println(outcome)
+	}
+}
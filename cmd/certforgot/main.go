@@ -1,39 +1,91 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/go-playground/validator"
+	"github.com/figglewatts/certforgot/internal/app"
+	"github.com/spf13/cobra"
 )
 
-type Test struct {
-	Email  string `validate:"required,email"`
-	Server string `validate:"required,url"`
+const (
+	ExitOK         = 0
+	ExitFailure    = 1
+	ExitUsage      = 2
+	ExitRenewalDue = 3
+
+	DefaultConfigPath = "certforgot.yaml"
+)
+
+// exitError carries the process exit code for an error out of a command.
+type exitError struct {
+	code int
+	err  error
 }
 
-var validate *validator.Validate
+func (e exitError) Error() string {
+	return e.err.Error()
+}
 
-func main() {
-	validate = validator.New()
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+type options struct {
+	configPath string
+}
 
-	valid := Test{
-		Email:  "sam@test.org",
-		Server: "https://www.test.org/",
+func (opts *options) loadConfig() (*app.Config, error) {
+	conf, err := app.Load(opts.configPath)
+	if err != nil {
+		return nil, exitError{ExitUsage, err}
 	}
-	invalid := Test{
-		Email:  "adasdasd",
-		Server: "asddasd",
+	return conf, nil
+}
+
+func rootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:           "certforgot",
+		Short:         "Auto renew certificates when you've forgotten about them",
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
+	root.PersistentFlags().StringVarP(
+		&opts.configPath, "config", "c", DefaultConfigPath,
+		"path to the config file",
+	)
 
-	err := validate.Struct(valid)
-	if err != nil {
-		fmt.Printf("%v\n", err)
-	} else {
-		fmt.Println("Valid!")
+	root.AddCommand(
+		runCommand(opts),
+		checkCommand(opts),
+		renewCommand(opts),
+		historyCommand(opts),
+		stateCommand(opts),
+		configCommand(opts),
+	)
+	return root
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM,
+	)
+	defer stop()
+
+	err := rootCommand().ExecuteContext(ctx)
+	if err == nil {
+		os.Exit(ExitOK)
 	}
 
-	err = validate.Struct(invalid)
-	if err != nil {
-		fmt.Printf("%v\n", err)
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.code)
 	}
+	os.Exit(ExitUsage)
 }
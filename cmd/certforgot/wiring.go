@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/figglewatts/certforgot/internal/app"
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/figglewatts/certforgot/pkg/challenge"
+	"github.com/figglewatts/certforgot/pkg/factory"
+	"github.com/figglewatts/certforgot/pkg/kube"
+	"github.com/figglewatts/certforgot/pkg/renew"
+	"github.com/figglewatts/certforgot/pkg/s3"
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/figglewatts/certforgot/pkg/vault"
+)
+
+func stateSourceFrom(
+	ctx context.Context, conf app.StateConfig,
+) (state.Source, error) {
+	cipher, err := cipherFrom(conf.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("state encryption: %v", err)
+	}
+
+	switch {
+	case conf.Local != nil:
+		return state.NewLocalSource(conf.Local.Directory, cipher)
+	case conf.Sql != nil:
+		db, err := sql.Open(conf.Sql.Driver, conf.Sql.ConnectionString)
+		if err != nil {
+			return nil, fmt.Errorf("opening database: %v", err)
+		}
+		return state.NewSqlSource(ctx, conf.Sql.Driver, db)
+	case conf.AzureBlob != nil:
+		containerUrl := conf.AzureBlob.Url
+		client, err := azure.NewBlobClient(&containerUrl, state.FileName)
+		if err != nil {
+			return nil, err
+		}
+		lockContainerUrl := conf.AzureBlob.Url
+		lockClient, err := azure.NewBlobClient(
+			&lockContainerUrl, state.LockFileName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return state.NewAzureBlobSource(client, lockClient, cipher)
+	case conf.S3 != nil:
+		key := conf.S3.Key
+		if key == "" {
+			key = state.FileName
+		}
+		client, err := s3.NewObjectClient(
+			s3.Config{
+				Endpoint:  conf.S3.Endpoint,
+				Region:    conf.S3.Region,
+				Bucket:    conf.S3.Bucket,
+				Key:       key,
+				PathStyle: conf.S3.PathStyle,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		lockClient, err := s3.NewObjectClient(
+			s3.Config{
+				Endpoint:  conf.S3.Endpoint,
+				Region:    conf.S3.Region,
+				Bucket:    conf.S3.Bucket,
+				Key:       key + ".lock",
+				PathStyle: conf.S3.PathStyle,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return state.NewS3Source(client, lockClient, cipher)
+	case conf.AzureKeyVault != nil:
+		client, err := azure.NewKeyVaultClient(&conf.AzureKeyVault.Url)
+		if err != nil {
+			return nil, err
+		}
+		return state.NewAzureKeyVaultSource(
+			client, &state.AzureKeyVaultSourceConfig{
+				EmailSecretName:   conf.AzureKeyVault.EmailSecretName,
+				KeyName:           conf.AzureKeyVault.KeyName,
+				IndexSecretName:   conf.AzureKeyVault.IndexSecretName,
+				HistorySecretName: conf.AzureKeyVault.HistorySecretName,
+			},
+		)
+	case conf.Vault != nil:
+		auth, err := vaultAuthFrom(*conf.Vault)
+		if err != nil {
+			return nil, fmt.Errorf("vault auth: %v", err)
+		}
+		client, err := vault.NewKvClient(
+			ctx, conf.Vault.Address, conf.Vault.Mount, auth,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return state.NewVaultKvSource(client, conf.Vault.Path)
+	case conf.Kubernetes != nil:
+		clientset, namespace, err := kube.NewClientset(conf.Kubernetes.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Kubernetes.Namespace != "" {
+			namespace = conf.Kubernetes.Namespace
+		}
+		return state.NewKubernetesSecretSource(
+			clientset.CoreV1().Secrets(namespace),
+			clientset.CoordinationV1().Leases(namespace),
+			conf.Kubernetes.SecretName, cipher,
+		)
+	}
+	return nil, errors.New("no state backend configured")
+}
+
+func cipherFrom(conf *app.StateEncryptionConfig) (state.Cipher, error) {
+	switch {
+	case conf == nil:
+		return nil, nil
+	case conf.PassphraseEnv != "" || conf.PassphraseFile != "":
+		passphrase, err := secretFrom(conf.PassphraseEnv, conf.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("passphrase: %v", err)
+		}
+		return state.NewPassphraseCipher(passphrase)
+	}
+	identities, err := ioutil.ReadFile(conf.AgeIdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading age identity: %v", err)
+	}
+	return state.NewAgeCipher(string(identities), conf.AgeRecipients...)
+}
+
+// secretFrom reads a secret from the environment variable env if it's set,
+// or else from file, so it's not kept in the config.
+func secretFrom(env string, file string) (string, error) {
+	if env != "" {
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("%s isn't set", env)
+		}
+		return secret, nil
+	}
+
+	secret, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", file, err)
+	}
+	// editors add a trailing newline to the file
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+func vaultAuthFrom(conf app.VaultStateConfig) (vault.Auth, error) {
+	switch {
+	case conf.AppRole != nil:
+		secretId, err := secretFrom(
+			conf.AppRole.SecretIdEnv, conf.AppRole.SecretIdFile,
+		)
+		if err != nil {
+			return vault.Auth{}, fmt.Errorf("approle secret id: %v", err)
+		}
+		return vault.Auth{
+			AppRoleMount:    conf.AppRole.Mount,
+			AppRoleRoleId:   conf.AppRole.RoleId,
+			AppRoleSecretId: secretId,
+		}, nil
+	case conf.TokenFile != "":
+		token, err := secretFrom("", conf.TokenFile)
+		if err != nil {
+			return vault.Auth{}, fmt.Errorf("token: %v", err)
+		}
+		return vault.Auth{Token: token}, nil
+	}
+	return vault.Auth{}, nil
+}
+
+type configuredSource struct {
+	conf   app.StateConfig
+	source state.Source
+}
+
+// stateSourceAt creates the state source given by backend, which is either
+// a state block as inline YAML or the path of a file with a state block. It's
+// the config file's if backend is empty.
+func stateSourceAt(
+	ctx context.Context, opts *options, backend string,
+) (configuredSource, error) {
+	var conf app.StateConfig
+	switch {
+	case backend == "":
+		loaded, err := opts.loadConfig()
+		if err != nil {
+			return configuredSource{}, err
+		}
+		conf = loaded.State
+	case strings.HasPrefix(strings.TrimSpace(backend), "{"):
+		parsed, err := app.ParseState(backend)
+		if err != nil {
+			return configuredSource{}, err
+		}
+		conf = *parsed
+	default:
+		loaded, err := app.LoadState(backend)
+		if err != nil {
+			return configuredSource{}, err
+		}
+		conf = *loaded
+	}
+
+	source, err := stateSourceFrom(ctx, conf)
+	if err != nil {
+		return configuredSource{}, err
+	}
+	return configuredSource{conf, source}, nil
+}
+
+// lockState holds the state's lock, if its backend has one, until release is
+// called. The returned context is cancelled if the lock is lost.
+func lockState(
+	ctx context.Context, conf app.StateConfig, stateSource state.Source,
+) (context.Context, func() error, error) {
+	locker, ok := stateSource.(state.Locker)
+	if !ok {
+		fmt.Fprintln(
+			os.Stderr,
+			"warning: the state backend can't be locked, so instances sharing it may race",
+		)
+		return ctx, func() error { return nil }, nil
+	}
+	return state.Hold(ctx, locker, conf.LockTtl)
+}
+
+// acmeClientFrom creates an ACME client for the account held in the state,
+// creating the account on first run.
+func acmeClientFrom(
+	ctx context.Context, conf *app.Config, stateSource state.Source,
+) (*acme.Client, error) {
+	return acme.Bootstrap(
+		ctx, &conf.Acme.Server, &conf.Acme.Email, stateSource,
+		accountKeyFrom(conf.Acme), nil,
+	)
+}
+
+func accountKeyFrom(conf app.AcmeConfig) state.AccountKey {
+	if conf.AccountPerEmail {
+		return state.NewAccountKey(&conf.Server, &conf.Email)
+	}
+	return state.NewAccountKey(&conf.Server, nil)
+}
+
+func targetsFrom(conf *app.Config, names []string) ([]renew.Target, error) {
+	var certs []app.Certificate
+	if len(names) == 0 {
+		certs = conf.Certs
+	}
+	for _, name := range names {
+		certificate, ok := conf.Cert(name)
+		if !ok {
+			return nil, fmt.Errorf("no cert named '%s' in config", name)
+		}
+		certs = append(certs, certificate)
+	}
+
+	solvers := solverCache{
+		http01:    map[int]*challenge.Http01Solver{},
+		tlsAlpn01: map[int]*challenge.TlsAlpn01Solver{},
+		dns01:     map[string]*challenge.Dns01Solver{},
+	}
+	var targets []renew.Target
+	for _, certificate := range certs {
+		sourceOptions, err := factoryOptionsFrom(certificate.Source.Password)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"cert '%s' source: %v", certificate.Metadata.Name, err,
+			)
+		}
+		source, err := factory.Sources.New(
+			certificate.Source.Type, certificate.Source.Location, sourceOptions,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cert '%s': %v", certificate.Metadata.Name, err)
+		}
+
+		installerOptions, err := factoryOptionsFrom(
+			certificate.Installer.Password,
+		)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"cert '%s' installer: %v", certificate.Metadata.Name, err,
+			)
+		}
+		certInstaller, err := factory.Installers.New(
+			certificate.Installer.Type, certificate.Installer.Location,
+			installerOptions,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cert '%s': %v", certificate.Metadata.Name, err)
+		}
+
+		validator, _ := conf.Validator(certificate.Validator)
+		solver, err := solvers.solverFrom(validator)
+		if err != nil {
+			return nil, fmt.Errorf("cert '%s': %v", certificate.Metadata.Name, err)
+		}
+
+		targets = append(
+			targets, renew.Target{
+				Certificate: certificate,
+				Source:      source,
+				Installer:   certInstaller,
+				Solver:      solver,
+			},
+		)
+	}
+	return targets, nil
+}
+
+func factoryOptionsFrom(
+	password *app.CertificatePasswordConfig,
+) (factory.Options, error) {
+	if password == nil {
+		return factory.Options{}, nil
+	}
+	secret, err := secretFrom(password.Env, password.File)
+	if err != nil {
+		return factory.Options{}, fmt.Errorf("password: %v", err)
+	}
+	return factory.Options{Password: secret}, nil
+}
+
+// solverCache shares solvers between certs so that validators listening on
+// the same port use one listener, and certs using the same dns validator
+// don't race on its records.
+type solverCache struct {
+	http01    map[int]*challenge.Http01Solver
+	tlsAlpn01 map[int]*challenge.TlsAlpn01Solver
+	dns01     map[string]*challenge.Dns01Solver
+}
+
+func (cache solverCache) solverFrom(validator app.Validator) (acme.Solver, error) {
+	switch {
+	case validator.Http01 != nil:
+		port := validator.Http01.Port
+		if solver, ok := cache.http01[port]; ok {
+			return solver, nil
+		}
+		solver, err := challenge.NewHttp01Solver(port)
+		if err != nil {
+			return nil, err
+		}
+		cache.http01[port] = solver
+		return solver, nil
+	case validator.TlsAlpn01 != nil:
+		port := validator.TlsAlpn01.Port
+		if solver, ok := cache.tlsAlpn01[port]; ok {
+			return solver, nil
+		}
+		solver, err := challenge.NewTlsAlpn01Solver(port)
+		if err != nil {
+			return nil, err
+		}
+		cache.tlsAlpn01[port] = solver
+		return solver, nil
+	case validator.Dns01 != nil:
+		if solver, ok := cache.dns01[validator.Name]; ok {
+			return solver, nil
+		}
+		provider, err := dnsProviderFrom(*validator.Dns01)
+		if err != nil {
+			return nil, fmt.Errorf("validator '%s': %v", validator.Name, err)
+		}
+		solver, err := challenge.NewDns01Solver(provider)
+		if err != nil {
+			return nil, err
+		}
+		cache.dns01[validator.Name] = solver
+		return solver, nil
+	}
+	return nil, fmt.Errorf("validator '%s' has no challenge type", validator.Name)
+}
+
+func dnsProviderFrom(conf app.Dns01Validator) (challenge.Provider, error) {
+	switch conf.Provider {
+	case challenge.ProviderAzure:
+		client, err := azure.NewDnsClient(
+			conf.Azure.SubscriptionId, conf.Azure.ResourceGroup,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return challenge.NewAzureDnsProvider(client, conf.Azure.Zone)
+	case challenge.ProviderRfc2136:
+		return challenge.NewRfc2136Provider(
+			&challenge.Rfc2136Config{
+				Nameserver:    conf.Rfc2136.Nameserver,
+				Zone:          conf.Rfc2136.Zone,
+				Ttl:           conf.Rfc2136.Ttl,
+				TsigKeyName:   conf.Rfc2136.TsigKeyName,
+				TsigAlgorithm: conf.Rfc2136.TsigAlgorithm,
+				TsigSecret:    conf.Rfc2136.TsigSecret,
+			},
+		)
+	}
+	return nil, fmt.Errorf("unknown dns provider '%s'", conf.Provider)
+}
@@ -4,8 +4,13 @@ import (
 	"io/ioutil"
 	"net/mail"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/figglewatts/certforgot/pkg/factory"
+	"github.com/figglewatts/certforgot/pkg/state"
 	"github.com/go-playground/validator"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -13,19 +18,32 @@ import (
 
 var validate *validator.Validate
 
+// rfc1035Label is a DNS label as RFC 1035 defines it, which validator v9
+// doesn't know.
+var rfc1035Label = regexp.MustCompile(`^[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
 func init() {
 	validate = validator.New()
+	validate.RegisterValidation(
+		"dns_rfc1035_label", func(fl validator.FieldLevel) bool {
+			return rfc1035Label.MatchString(fl.Field().String())
+		},
+	)
 }
 
 type AcmeConfig struct {
 	Server url.URL      `validate:"required"`
 	Email  mail.Address `validate:"required"`
+	// AccountPerEmail keys accounts in the state on email as well as server,
+	// so that changing the email uses a different account.
+	AccountPerEmail bool
 }
 
 func (c *AcmeConfig) UnmarshalYAML(value *yaml.Node) error {
 	aux := &struct {
-		Server string `validate:"required,url"`
-		Email  string `validate:"required,email"`
+		Server          string `validate:"required,url"`
+		Email           string `validate:"required,email"`
+		AccountPerEmail bool   `yaml:"accountPerEmail"`
 	}{}
 	if err := value.Decode(aux); err != nil {
 		return err
@@ -45,22 +63,191 @@ func (c *AcmeConfig) UnmarshalYAML(value *yaml.Node) error {
 	}
 	c.Server = *parsedUrl
 	c.Email = *parsedEmail
+	c.AccountPerEmail = aux.AccountPerEmail
+	return nil
 }
 
 type StateConfig struct {
-	Local         *LocalStateConfig
-	Sql           *SqlStateConfig
-	AzureBlob     *AzureBlobStateConfig
-	AzureKeyVault *AzureKeyVaultStateConfig
+	Local         *LocalStateConfig         `yaml:"local"`
+	Sql           *SqlStateConfig           `yaml:"sql"`
+	AzureBlob     *AzureBlobStateConfig     `yaml:"azureBlob"`
+	AzureKeyVault *AzureKeyVaultStateConfig `yaml:"azureKeyVault"`
+	Kubernetes    *KubernetesStateConfig    `yaml:"kubernetes"`
+	Vault         *VaultStateConfig         `yaml:"vault"`
+	S3            *S3StateConfig            `yaml:"s3"`
+	// Encryption encrypts local, blob, kubernetes and s3 state at rest.
+	Encryption *StateEncryptionConfig `yaml:"encryption"`
+	// LockTtl is how long the state lock outlives an instance that died
+	// holding it, for backends whose locks expire.
+	LockTtl time.Duration `yaml:"-"`
+}
+
+// validate checks what the struct tags can't: that exactly one backend is
+// set, and that encryption is set up for a backend supporting it.
+func (c StateConfig) validate() error {
+	if c.configured() != 1 {
+		return errors.New("config must set exactly one state backend")
+	}
+
+	if vault := c.Vault; vault != nil {
+		if err := vault.validate(); err != nil {
+			return err
+		}
+	}
+
+	if encryption := c.Encryption; encryption != nil {
+		if c.Local == nil && c.AzureBlob == nil && c.Kubernetes == nil &&
+			c.S3 == nil {
+			return errors.New(
+				"state encryption is only supported by local, azureBlob, " +
+					"kubernetes and s3 state",
+			)
+		}
+		if encryption.configured() != 1 {
+			return errors.New(
+				"state encryption must set exactly one of passphraseEnv, " +
+					"passphraseFile or ageIdentityFile",
+			)
+		}
+		if len(encryption.AgeRecipients) > 0 && encryption.AgeIdentityFile == "" {
+			return errors.New(
+				"state encryption ageRecipients needs ageIdentityFile",
+			)
+		}
+	}
+	return nil
+}
+
+// StateEncryptionConfig encrypts the state with a passphrase, read from an
+// environment variable or file so it's not kept in the config, or with an age
+// identity file.
+type StateEncryptionConfig struct {
+	PassphraseEnv   string `yaml:"passphraseEnv" validate:"required_without_all=PassphraseFile AgeIdentityFile"`
+	PassphraseFile  string `yaml:"passphraseFile" validate:"required_without_all=PassphraseEnv AgeIdentityFile"`
+	AgeIdentityFile string `yaml:"ageIdentityFile" validate:"required_without_all=PassphraseEnv PassphraseFile"`
+	// AgeRecipients can also decrypt the state, for example to keep a backup
+	// key offline.
+	AgeRecipients []string `yaml:"ageRecipients" validate:"omitempty,dive,startswith=age1"`
+}
+
+func (c StateEncryptionConfig) configured() int {
+	count := 0
+	for _, key := range []string{
+		c.PassphraseEnv, c.PassphraseFile, c.AgeIdentityFile,
+	} {
+		if key != "" {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *StateConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain StateConfig
+	aux := &struct {
+		plain   `yaml:",inline"`
+		LockTtl string `yaml:"lockTtl"`
+	}{}
+	if err := value.Decode(aux); err != nil {
+		return err
+	}
+
+	*c = StateConfig(aux.plain)
+	c.LockTtl = state.DefaultLockTtl
+	if aux.LockTtl != "" {
+		duration, err := ParseDuration(aux.LockTtl)
+		if err != nil {
+			return errors.Wrap(err, "StateConfig has bad lockTtl")
+		}
+		if duration <= 0 {
+			return errors.New("StateConfig lockTtl must be positive")
+		}
+		if c.AzureKeyVault != nil {
+			return errors.New("StateConfig lockTtl is set but Key Vault state can't be locked")
+		}
+		c.LockTtl = duration
+	}
+	return nil
+}
+
+func (c StateConfig) configured() int {
+	count := 0
+	for _, backend := range []bool{
+		c.Local != nil, c.Sql != nil, c.AzureBlob != nil,
+		c.AzureKeyVault != nil, c.Kubernetes != nil, c.Vault != nil,
+		c.S3 != nil,
+	} {
+		if backend {
+			count++
+		}
+	}
+	return count
 }
 
 type LocalStateConfig struct {
-	Directory string `validate:"required,file"`
+	Directory string `yaml:"directory" validate:"required"`
 }
 
 type SqlStateConfig struct {
-	Driver           string `validate:"required"`
-	ConnectionString string `validate:"required"`
+	Driver           string `yaml:"driver" validate:"required,oneof=postgres mysql sqlite"`
+	ConnectionString string `yaml:"connectionString" validate:"required"`
+}
+
+// KubernetesStateConfig keeps the state in a Secret. The kubeconfig defaults
+// to $KUBECONFIG, ~/.kube/config or the pod's service account, and the
+// namespace to the kubeconfig's.
+type KubernetesStateConfig struct {
+	SecretName string `yaml:"secretName" validate:"required"`
+	Namespace  string `yaml:"namespace"`
+	Kubeconfig string `yaml:"kubeconfig"`
+}
+
+// VaultStateConfig keeps the state in a vault KV version 2 engine. The address
+// defaults to $VAULT_ADDR, and without an AppRole or token file the token is
+// read from $VAULT_TOKEN.
+type VaultStateConfig struct {
+	Address   string                   `yaml:"address" validate:"omitempty,url"`
+	Mount     string                   `yaml:"mount"`
+	Path      string                   `yaml:"path"`
+	TokenFile string                   `yaml:"tokenFile"`
+	AppRole   *VaultAppRoleStateConfig `yaml:"appRole"`
+}
+
+// VaultAppRoleStateConfig logs in with AppRole, reading the secret ID from an
+// environment variable or file so it's not kept in the config.
+type VaultAppRoleStateConfig struct {
+	Mount        string `yaml:"mount"`
+	RoleId       string `yaml:"roleId" validate:"required"`
+	SecretIdEnv  string `yaml:"secretIdEnv"`
+	SecretIdFile string `yaml:"secretIdFile"`
+}
+
+func (c VaultStateConfig) validate() error {
+	if c.AppRole == nil {
+		return nil
+	}
+	if c.TokenFile != "" {
+		return errors.New("vault state can't set both tokenFile and appRole")
+	}
+	if (c.AppRole.SecretIdEnv == "") == (c.AppRole.SecretIdFile == "") {
+		return errors.New(
+			"vault state appRole must set exactly one of secretIdEnv or " +
+				"secretIdFile",
+		)
+	}
+	return nil
+}
+
+// S3StateConfig keeps the state in an object in an S3-compatible store. The key
+// defaults to the local state's file name, and credentials and the region
+// come from the environment or shared AWS config. Stores other than AWS need
+// an endpoint, and usually pathStyle.
+type S3StateConfig struct {
+	Bucket    string `yaml:"bucket" validate:"required"`
+	Key       string `yaml:"key"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint" validate:"omitempty,url"`
+	PathStyle bool   `yaml:"pathStyle"`
 }
 
 type AzureBlobStateConfig struct {
@@ -89,16 +276,23 @@ func (c *AzureBlobStateConfig) UnmarshalYAML(value *yaml.Node) error {
 }
 
 type AzureKeyVaultStateConfig struct {
-	Url             url.URL `validate:"required"`
-	KeyName         string  `validate:"required,dns_rfc1035_label"`
-	EmailSecretName string  `validate:"required,dns_rfc1035_label"`
+	Url url.URL `validate:"required"`
+	// KeyName is the secret holding the account's private key.
+	KeyName         string `validate:"required,dns_rfc1035_label"`
+	EmailSecretName string `validate:"required,dns_rfc1035_label"`
+	// IndexSecretName is the secret listing the accounts held in the vault.
+	IndexSecretName string `validate:"omitempty,dns_rfc1035_label"`
+	// HistorySecretName is the secret holding the recent issuances.
+	HistorySecretName string `validate:"omitempty,dns_rfc1035_label"`
 }
 
 func (c *AzureKeyVaultStateConfig) UnmarshalYAML(value *yaml.Node) error {
 	aux := &struct {
-		Url             string `validate:"required"`
-		KeyName         string `validate:"required,dns_rfc1035_label"`
-		EmailSecretName string `validate:"required,dns_rfc1035_label"`
+		Url               string `yaml:"url" validate:"required"`
+		KeyName           string `yaml:"keyName" validate:"required,dns_rfc1035_label"`
+		EmailSecretName   string `yaml:"emailSecretName" validate:"required,dns_rfc1035_label"`
+		IndexSecretName   string `yaml:"indexSecretName" validate:"omitempty,dns_rfc1035_label"`
+		HistorySecretName string `yaml:"historySecretName" validate:"omitempty,dns_rfc1035_label"`
 	}{}
 
 	if err := value.Decode(aux); err != nil {
@@ -117,6 +311,8 @@ func (c *AzureKeyVaultStateConfig) UnmarshalYAML(value *yaml.Node) error {
 	c.Url = *parsedUrl
 	c.KeyName = aux.KeyName
 	c.EmailSecretName = aux.EmailSecretName
+	c.IndexSecretName = aux.IndexSecretName
+	c.HistorySecretName = aux.HistorySecretName
 	return nil
 }
 
@@ -126,7 +322,7 @@ type CertificatePolicy struct {
 
 func (c *CertificatePolicy) UnmarshalYAML(value *yaml.Node) error {
 	aux := &struct {
-		RenewBefore string `validate:"required"`
+		RenewBefore string `yaml:"renewBefore" validate:"required"`
 	}{}
 
 	if err := value.Decode(aux); err != nil {
@@ -137,7 +333,7 @@ func (c *CertificatePolicy) UnmarshalYAML(value *yaml.Node) error {
 		return errors.Wrap(err, "CertificatePolicy failed validation")
 	}
 
-	duration, err := time.ParseDuration(aux.RenewBefore)
+	duration, err := ParseDuration(aux.RenewBefore)
 	if err != nil {
 		return errors.Wrap(err, "CertificatePolicy has bad duration")
 	}
@@ -146,41 +342,139 @@ func (c *CertificatePolicy) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// ParseDuration parses a duration as time.ParseDuration does, additionally
+// accepting a whole number of days such as "30d".
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
 type Validator struct {
-	Name   string `validate:"required"`
-	Dns01  string `validate:"required"`
-	Http01 int    `validate:"required,max=65535"`
+	Name      string              `yaml:"name" validate:"required"`
+	Dns01     *Dns01Validator     `yaml:"dns01" validate:"required_without_all=Http01 TlsAlpn01"`
+	Http01    *Http01Validator    `yaml:"http01" validate:"required_without_all=Dns01 TlsAlpn01"`
+	TlsAlpn01 *TlsAlpn01Validator `yaml:"tlsAlpn01" validate:"required_without_all=Dns01 Http01"`
+}
+
+// validateProvider checks that a dns01 validator has the config block for its
+// provider.
+func (v Validator) validateProvider() error {
+	if v.Dns01 == nil {
+		return nil
+	}
+	switch {
+	case v.Dns01.Provider == "azure" && v.Dns01.Azure == nil:
+		return errors.New("dns01 provider azure requires an azure block")
+	case v.Dns01.Provider == "rfc2136" && v.Dns01.Rfc2136 == nil:
+		return errors.New("dns01 provider rfc2136 requires an rfc2136 block")
+	}
+	return nil
+}
+
+type Dns01Validator struct {
+	Provider string              `yaml:"provider" validate:"required,oneof=azure rfc2136"`
+	Azure    *AzureDnsProvider   `yaml:"azure"`
+	Rfc2136  *Rfc2136DnsProvider `yaml:"rfc2136"`
+}
+
+type AzureDnsProvider struct {
+	SubscriptionId string `yaml:"subscriptionId" validate:"required"`
+	ResourceGroup  string `yaml:"resourceGroup" validate:"required"`
+	// Zone is found from the zones in the resource group if not given.
+	Zone string `yaml:"zone"`
+}
+
+type Rfc2136DnsProvider struct {
+	// Nameserver is host[:port], port 53 if not given.
+	Nameserver string `yaml:"nameserver" validate:"required"`
+	Zone       string `yaml:"zone" validate:"required"`
+	Ttl        uint32 `yaml:"ttl"`
+
+	TsigKeyName   string `yaml:"tsigKeyName" validate:"required_with=TsigSecret"`
+	TsigAlgorithm string `yaml:"tsigAlgorithm"`
+	// TsigSecret is base64 encoded.
+	TsigSecret string `yaml:"tsigSecret" validate:"required_with=TsigKeyName,omitempty,base64"`
+}
+
+type Http01Validator struct {
+	Port int `yaml:"port" validate:"required,min=1,max=65535"`
+}
+
+type TlsAlpn01Validator struct {
+	Port int `yaml:"port" validate:"required,min=1,max=65535"`
 }
 
 type Certificate struct {
-	Metadata  CertificateMetadata  `validate:"required"`
-	Source    CertificateSource    `validate:"required"`
-	Validator string               `validate:"required"`
-	Installer CertificateInstaller `validate:"required"`
-	Policy    *CertificatePolicy
+	Metadata  CertificateMetadata  `yaml:"metadata" validate:"required"`
+	Source    CertificateSource    `yaml:"source" validate:"required"`
+	Validator string               `yaml:"validator" validate:"required"`
+	Installer CertificateInstaller `yaml:"installer" validate:"required"`
+	Policy    *CertificatePolicy   `yaml:"policy"`
 }
 
 type CertificateMetadata struct {
-	Name    string   `validate:"required"`
-	Domains []string `validate:"required,dive,required"`
+	Name    string   `yaml:"name" validate:"required"`
+	Domains []string `yaml:"domains" validate:"required,dive,required"`
 }
 
 type CertificateSource struct {
-	Type     string `validate:"required"`
-	Location string `validate:"required"`
+	Type     string `yaml:"type" validate:"required"`
+	Location string `yaml:"location" validate:"required"`
+	// Password decrypts pfx files.
+	Password *CertificatePasswordConfig `yaml:"password"`
 }
 
 type CertificateInstaller struct {
-	Type     string `validate:"required"`
-	Location string `validate:"required"`
+	Type     string `yaml:"type" validate:"required"`
+	Location string `yaml:"location" validate:"required"`
+	// Password encrypts pfx files.
+	Password *CertificatePasswordConfig `yaml:"password"`
+}
+
+// CertificatePasswordConfig reads a password from an environment variable or
+// file so it's not kept in the config.
+type CertificatePasswordConfig struct {
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+func (c *CertificatePasswordConfig) validate() error {
+	if c != nil && (c.Env == "") == (c.File == "") {
+		return errors.New("password must set exactly one of env or file")
+	}
+	return nil
 }
 
 type Config struct {
-	Acme         AcmeConfig        `validate:"required"`
-	State        StateConfig       `validate:"required"`
-	GlobalPolicy CertificatePolicy `validate:"required"`
-	Validators   []Validator       `validate:"required,dive,required"`
-	Certs        []Certificate     `validate:"required,dive,required"`
+	Acme         AcmeConfig        `yaml:"acme" validate:"required"`
+	State        StateConfig       `yaml:"state" validate:"required"`
+	GlobalPolicy CertificatePolicy `yaml:"globalPolicy" validate:"required"`
+	Validators   []Validator       `yaml:"validators" validate:"required,dive,required"`
+	Certs        []Certificate     `yaml:"certs" validate:"required,dive,required"`
+}
+
+func (c Config) Validator(name string) (Validator, bool) {
+	for _, validator := range c.Validators {
+		if validator.Name == name {
+			return validator, true
+		}
+	}
+	return Validator{}, false
+}
+
+func (c Config) Cert(name string) (Certificate, bool) {
+	for _, cert := range c.Certs {
+		if cert.Metadata.Name == name {
+			return cert, true
+		}
+	}
+	return Certificate{}, false
 }
 
 func Load(path string) (*Config, error) {
@@ -198,5 +492,87 @@ func Load(path string) (*Config, error) {
 		return nil, errors.Wrap(err, "config failed validation")
 	}
 
+	if err := conf.State.validate(); err != nil {
+		return nil, err
+	}
+
+	for _, validator := range conf.Validators {
+		if err := validator.validateProvider(); err != nil {
+			return nil, errors.Wrapf(
+				err, "validator '%s'", validator.Name,
+			)
+		}
+	}
+
+	for _, cert := range conf.Certs {
+		if _, ok := conf.Validator(cert.Validator); !ok {
+			return nil, errors.Errorf(
+				"cert '%s' has unknown validator '%s'", cert.Metadata.Name,
+				cert.Validator,
+			)
+		}
+
+		err := factory.Sources.Validate(cert.Source.Type, cert.Source.Location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cert '%s'", cert.Metadata.Name)
+		}
+		if err := cert.Source.Password.validate(); err != nil {
+			return nil, errors.Wrapf(err, "cert '%s' source", cert.Metadata.Name)
+		}
+
+		err = factory.Installers.Validate(
+			cert.Installer.Type, cert.Installer.Location,
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cert '%s'", cert.Metadata.Name)
+		}
+		if err := cert.Installer.Password.validate(); err != nil {
+			return nil, errors.Wrapf(
+				err, "cert '%s' installer", cert.Metadata.Name,
+			)
+		}
+	}
+
+	return &conf, nil
+}
+
+// LoadState loads just the state backend from a file, which is laid out like
+// a config file, so that a config file can itself be given.
+func LoadState(path string) (*StateConfig, error) {
+	confBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read state file")
+	}
+
+	conf := struct {
+		State StateConfig `yaml:"state" validate:"required"`
+	}{}
+	if err := yaml.Unmarshal(confBytes, &conf); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal YAML")
+	}
+
+	if err := validate.Struct(conf); err != nil {
+		return nil, errors.Wrap(err, "state failed validation")
+	}
+	if err := conf.State.validate(); err != nil {
+		return nil, err
+	}
+	return &conf.State, nil
+}
+
+// ParseState parses a state backend given inline as YAML, laid out like the
+// config file's state block, e.g. `{local: {directory: ./state}}`.
+func ParseState(block string) (*StateConfig, error) {
+	conf := StateConfig{}
+	if err := yaml.Unmarshal([]byte(block), &conf); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal YAML")
+	}
+
+	if err := validate.Struct(conf); err != nil {
+		return nil, errors.Wrap(err, "state failed validation")
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
 	return &conf, nil
 }
@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	AccountStatusValid       = "valid"
+	AccountStatusDeactivated = "deactivated"
+	AccountStatusRevoked     = "revoked"
+)
+
+var ErrAccountDoesNotExist = errors.New("account does not exist")
+
+type Account struct {
+	Url                  string   `json:"-"`
+	Status               string   `json:"status"`
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	Orders               string   `json:"orders,omitempty"`
+}
+
+type newAccountRequest struct {
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting,omitempty"`
+}
+
+func contactFor(email *mail.Address) []string {
+	if email == nil || email.Address == "" {
+		return nil
+	}
+	return []string{"mailto:" + email.Address}
+}
+
+// Register creates an account for the client's key, agreeing to the terms of
+// service. If an account already exists for the key it is returned instead.
+func (client *Client) Register(
+	ctx context.Context, email *mail.Address,
+) (Account, error) {
+	return client.newAccount(
+		ctx, newAccountRequest{
+			Contact:              contactFor(email),
+			TermsOfServiceAgreed: true,
+		},
+	)
+}
+
+// RegisterState registers the account held in s, using its email and key.
+func (client *Client) RegisterState(
+	ctx context.Context, s state.State,
+) (Account, error) {
+	if s.UserPrivateKey.Key == nil {
+		return Account{}, errors.New("state has no account key")
+	}
+
+	stateThumbprint, err := Thumbprint(s.UserPrivateKey.Key)
+	if err != nil {
+		return Account{}, fmt.Errorf("state key thumbprint: %v", err)
+	}
+	clientThumbprint, err := Thumbprint(client.key)
+	if err != nil {
+		return Account{}, fmt.Errorf("client key thumbprint: %v", err)
+	}
+	if stateThumbprint != clientThumbprint {
+		return Account{}, errors.New("state key does not match client key")
+	}
+	return client.Register(ctx, s.UserEmail.Address)
+}
+
+// Lookup finds the existing account for the client's key, returning
+// ErrAccountDoesNotExist if the server has no account for it.
+func (client *Client) Lookup(ctx context.Context) (Account, error) {
+	account, err := client.newAccount(
+		ctx, newAccountRequest{OnlyReturnExisting: true},
+	)
+
+	var problem Problem
+	if errors.As(err, &problem) &&
+		problem.Type == ProblemAccountDoesNotExist {
+		return account, ErrAccountDoesNotExist
+	}
+	return account, err
+}
+
+func (client *Client) newAccount(
+	ctx context.Context, request newAccountRequest,
+) (Account, error) {
+	account := Account{}
+	resp, err := client.postSigned(
+		ctx, client.directory.NewAccount, request, &account, true,
+	)
+	if err != nil {
+		return account, err
+	}
+
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusCreated {
+		return account, fmt.Errorf(
+			"unexpected status %d creating account", resp.StatusCode,
+		)
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return account, errors.New("server did not return an account url")
+	}
+
+	account.Url = location
+	client.accountUrl = location
+	return account, nil
+}
+
+// UpdateContact replaces the contact of the client's account with email.
+func (client *Client) UpdateContact(
+	ctx context.Context, email *mail.Address,
+) (Account, error) {
+	if client.accountUrl == "" {
+		return Account{}, errors.New("client has no account")
+	}
+
+	// contacts are cleared with an empty array rather than null
+	contact := contactFor(email)
+	if contact == nil {
+		contact = []string{}
+	}
+	request := struct {
+		Contact []string `json:"contact"`
+	}{contact}
+
+	account := Account{Url: client.accountUrl}
+	_, err := client.post(ctx, client.accountUrl, request, &account)
+	return account, err
+}
+
+// NewAccountKey generates a new P-256 account key.
+func NewAccountKey() (jwk.Key, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.New(privateKey)
+}
@@ -0,0 +1,99 @@
+package acme
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Register(t *testing.T) {
+	client, server := fakeClient(t)
+	ctx := context.Background()
+	email := &mail.Address{Address: "test@example.com"}
+
+	account, err := client.Register(ctx, email)
+	assert.NoError(t, err)
+	assert.Equal(t, AccountStatusValid, account.Status)
+	assert.Equal(t, []string{"mailto:test@example.com"}, account.Contact)
+	assert.Equal(t, account.Url, client.AccountUrl())
+
+	// registering again returns the same account
+	again, err := client.Register(ctx, email)
+	assert.NoError(t, err)
+	assert.Equal(t, account.Url, again.Url)
+	assert.Len(t, server.Accounts(), 1)
+}
+
+func TestClient_RegisterState(t *testing.T) {
+	client, _ := fakeClient(t)
+	ctx := context.Background()
+
+	s := state.NewState(
+		&mail.Address{Address: "test@example.com"}, client.Key(),
+	)
+	account, err := client.RegisterState(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mailto:test@example.com"}, account.Contact)
+
+	other := state.NewState(
+		&mail.Address{Address: "test@example.com"}, accountKey(t),
+	)
+	_, err = client.RegisterState(ctx, other)
+	assert.Error(t, err)
+}
+
+func TestClient_KeyWithKid(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	key := accountKey(t)
+	assert.NoError(t, key.Set(jwk.KeyIDKey, "stored-kid"))
+	client, err := NewClient(ctx, server.DirectoryUrl(), key, server.Client())
+	assert.NoError(t, err)
+
+	// the key's own kid is neither sent alongside the jwk nor in place of
+	// the account url
+	_, err = client.Register(ctx, &mail.Address{Address: "test@example.com"})
+	assert.NoError(t, err)
+	account, err := client.UpdateContact(
+		ctx, &mail.Address{Address: "new@example.com"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mailto:new@example.com"}, account.Contact)
+}
+
+func TestClient_Lookup(t *testing.T) {
+	client, _ := fakeClient(t)
+	ctx := context.Background()
+
+	_, err := client.Lookup(ctx)
+	assert.ErrorIs(t, err, ErrAccountDoesNotExist)
+
+	registered, err := client.Register(ctx, nil)
+	assert.NoError(t, err)
+
+	found, err := client.Lookup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, registered.Url, found.Url)
+}
+
+func TestClient_Lookup_KeepsAccount(t *testing.T) {
+	client, _ := fakeClient(t)
+	ctx := context.Background()
+	registered, err := client.Register(ctx, nil)
+	assert.NoError(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.Lookup(cancelled)
+	assert.Error(t, err)
+
+	// later requests are still signed with the account url
+	assert.Equal(t, registered.Url, client.AccountUrl())
+	_, err = client.UpdateContact(ctx, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,513 @@
+package acmetest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	OrderPath       = "/order/"
+	AuthzPath       = "/authz/"
+	ChallengePath   = "/challenge/"
+	FinalizePath    = "/finalize/"
+	CertificatePath = "/certificate/"
+
+	DefaultValidity = 90 * 24 * time.Hour
+)
+
+// ValidateFunc checks that a challenge has been fulfilled, returning an error
+// if it hasn't. A Server with no ValidateFunc accepts every challenge.
+type ValidateFunc func(
+	challengeType, domain, token, keyAuthorization string,
+) error
+
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type Order struct {
+	Url            string
+	Status         string
+	Identifiers    []Identifier
+	Authorizations []*Authorization
+	Certificate    []byte
+	account        *Account
+}
+
+type Authorization struct {
+	Url        string
+	Status     string
+	Identifier Identifier
+	Wildcard   bool
+	Challenges []*Challenge
+}
+
+type Challenge struct {
+	Url    string
+	Type   string
+	Status string
+	Token  string
+	Error  string
+}
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newAuthority() (authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return authority{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * DefaultValidity),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	if err != nil {
+		return authority{}, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return authority{}, err
+	}
+	return authority{cert, key}, nil
+}
+
+// Issuer returns the certificate the server signs issued certificates with.
+func (server *Server) Issuer() *x509.Certificate {
+	return server.authority.cert
+}
+
+// SetValidate sets the function used to check challenge responses.
+func (server *Server) SetValidate(validate ValidateFunc) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.validate = validate
+}
+
+// SetValidity sets the lifetime of certificates issued from now on.
+func (server *Server) SetValidity(validity time.Duration) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.validity = validity
+}
+
+func (server *Server) Orders() []Order {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	orders := make([]Order, 0, len(server.orders))
+	for _, order := range server.orders {
+		orders = append(orders, *order)
+	}
+	sort.Slice(
+		orders, func(i, j int) bool { return orders[i].Url < orders[j].Url },
+	)
+	return orders
+}
+
+func (server *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	payload := struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}{}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	order := &Order{
+		Url:         server.nextUrl(OrderPath),
+		Status:      "pending",
+		Identifiers: payload.Identifiers,
+		account:     request.account,
+	}
+	for _, identifier := range payload.Identifiers {
+		if identifier.Type != "dns" {
+			writeProblem(
+				w, http.StatusBadRequest, "unsupportedIdentifier",
+				identifier.Type,
+			)
+			return
+		}
+		order.Authorizations = append(
+			order.Authorizations, server.newAuthorization(identifier),
+		)
+	}
+	server.orders[order.Url] = order
+
+	w.Header().Set("Location", order.Url)
+	writeJson(w, http.StatusCreated, server.orderJson(order))
+}
+
+func (server *Server) newAuthorization(identifier Identifier) *Authorization {
+	authz := &Authorization{
+		Url:        server.nextUrl(AuthzPath),
+		Status:     "pending",
+		Identifier: identifier,
+	}
+
+	challengeTypes := []string{"http-01", "dns-01", "tls-alpn-01"}
+	if strings.HasPrefix(identifier.Value, "*.") {
+		authz.Wildcard = true
+		authz.Identifier.Value = strings.TrimPrefix(identifier.Value, "*.")
+		challengeTypes = []string{"dns-01"}
+	}
+
+	for _, challengeType := range challengeTypes {
+		token := make([]byte, 16)
+		_, _ = rand.Read(token)
+		challenge := &Challenge{
+			Url:    server.nextUrl(ChallengePath),
+			Type:   challengeType,
+			Status: "pending",
+			Token:  base64.RawURLEncoding.EncodeToString(token),
+		}
+		authz.Challenges = append(authz.Challenges, challenge)
+		server.challenges[challenge.Url] = authz
+	}
+	server.authzs[authz.Url] = authz
+	return authz
+}
+
+func (server *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	order := server.orders[server.URL+r.URL.Path]
+	if order == nil || order.account != request.account {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+
+	// orders only spend a single poll processing
+	if order.Status == "processing" {
+		order.Status = "valid"
+	}
+	writeJson(w, http.StatusOK, server.orderJson(order))
+}
+
+func (server *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	if _, ok := server.verify(w, r, false); !ok {
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	authz := server.authzs[server.URL+r.URL.Path]
+	if authz == nil {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such authz")
+		return
+	}
+	writeJson(w, http.StatusOK, authzJson(authz))
+}
+
+func (server *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	challengeUrl := server.URL + r.URL.Path
+	server.lock.Lock()
+	authz := server.challenges[challengeUrl]
+	validate := server.validate
+	server.lock.Unlock()
+	if authz == nil {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+
+	var challenge *Challenge
+	for _, c := range authz.Challenges {
+		if c.Url == challengeUrl {
+			challenge = c
+		}
+	}
+
+	thumbprint, err := thumbprintOf(request.account.Key)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "serverInternal", "")
+		return
+	}
+	keyAuth := challenge.Token + "." + thumbprint
+
+	// validation happens before responding, which a real server wouldn't do
+	var validateErr error
+	if validate != nil {
+		validateErr = validate(
+			challenge.Type, authz.Identifier.Value, challenge.Token, keyAuth,
+		)
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if validateErr != nil {
+		challenge.Status = "invalid"
+		challenge.Error = validateErr.Error()
+		authz.Status = "invalid"
+	} else {
+		challenge.Status = "valid"
+		authz.Status = "valid"
+	}
+	server.updateOrders()
+
+	writeJson(w, http.StatusOK, challengeJson(challenge))
+}
+
+func (server *Server) updateOrders() {
+	for _, order := range server.orders {
+		if order.Status != "pending" {
+			continue
+		}
+
+		status := "ready"
+		for _, authz := range order.Authorizations {
+			if authz.Status == "invalid" {
+				status = "invalid"
+				break
+			}
+			if authz.Status != "valid" {
+				status = "pending"
+			}
+		}
+		order.Status = status
+	}
+}
+
+func (server *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	payload := struct {
+		Csr string `json:"csr"`
+	}{}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	orderUrl := server.URL + OrderPath +
+		strings.TrimPrefix(r.URL.Path, FinalizePath)
+	order := server.orders[orderUrl]
+	if order == nil || order.account != request.account {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if order.Status != "ready" {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", order.Status)
+		return
+	}
+
+	csrDer, err := base64.RawURLEncoding.DecodeString(payload.Csr)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDer)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	if !sameNames(csr.DNSNames, order.Identifiers) {
+		writeProblem(
+			w, http.StatusBadRequest, "badCSR",
+			"csr names do not match order",
+		)
+		return
+	}
+
+	chain, err := server.issue(csr)
+	if err != nil {
+		writeProblem(
+			w, http.StatusInternalServerError, "serverInternal", err.Error(),
+		)
+		return
+	}
+	order.Certificate = chain
+	order.Status = "processing"
+
+	w.Header().Set("Location", order.Url)
+	w.Header().Set("Retry-After", "0")
+	writeJson(w, http.StatusOK, server.orderJson(order))
+}
+
+func (server *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	orderUrl := server.URL + OrderPath +
+		strings.TrimPrefix(r.URL.Path, CertificatePath)
+	order := server.orders[orderUrl]
+	if order == nil || order.account != request.account ||
+		order.Status != "valid" {
+		writeProblem(w, http.StatusNotFound, "malformed", "no certificate")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(order.Certificate)
+}
+
+func (server *Server) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	server.nextId++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(server.nextId)),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(server.validity),
+		KeyUsage: x509.KeyUsageDigitalSignature |
+			x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, server.authority.cert, csr.PublicKey,
+		server.authority.key,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &bytes.Buffer{}
+	for _, certDer := range [][]byte{der, server.authority.cert.Raw} {
+		err := pem.Encode(chain, &pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chain.Bytes(), nil
+}
+
+func sameNames(names []string, identifiers []Identifier) bool {
+	if len(names) != len(identifiers) {
+		return false
+	}
+
+	wanted := map[string]bool{}
+	for _, identifier := range identifiers {
+		wanted[identifier.Value] = true
+	}
+	for _, name := range names {
+		if !wanted[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func (server *Server) nextUrl(prefix string) string {
+	server.nextId++
+	return fmt.Sprintf("%s%s%d", server.URL, prefix, server.nextId)
+}
+
+func (server *Server) orderJson(order *Order) map[string]interface{} {
+	id := strings.TrimPrefix(order.Url, server.URL+OrderPath)
+	authzUrls := []string{}
+	for _, authz := range order.Authorizations {
+		authzUrls = append(authzUrls, authz.Url)
+	}
+
+	result := map[string]interface{}{
+		"status":         order.Status,
+		"identifiers":    order.Identifiers,
+		"authorizations": authzUrls,
+		"finalize":       server.URL + FinalizePath + id,
+	}
+	if order.Status == "valid" {
+		result["certificate"] = server.URL + CertificatePath + id
+	}
+	if order.Status == "invalid" {
+		result["error"] = map[string]interface{}{
+			"type":   problemPrefix + "unauthorized",
+			"detail": "authorization failed",
+		}
+	}
+	return result
+}
+
+func authzJson(authz *Authorization) map[string]interface{} {
+	challenges := []map[string]interface{}{}
+	for _, challenge := range authz.Challenges {
+		challenges = append(challenges, challengeJson(challenge))
+	}
+	return map[string]interface{}{
+		"status":     authz.Status,
+		"identifier": authz.Identifier,
+		"wildcard":   authz.Wildcard,
+		"challenges": challenges,
+	}
+}
+
+func challengeJson(challenge *Challenge) map[string]interface{} {
+	result := map[string]interface{}{
+		"type":   challenge.Type,
+		"url":    challenge.Url,
+		"status": challenge.Status,
+		"token":  challenge.Token,
+	}
+	if challenge.Error != "" {
+		result["error"] = map[string]interface{}{
+			"type":   problemPrefix + "incorrectResponse",
+			"detail": challenge.Error,
+		}
+	}
+	return result
+}
@@ -0,0 +1,364 @@
+// Package acmetest provides an in-process fake ACME server for tests.
+package acmetest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+const (
+	DirectoryPath  = "/directory"
+	NewNoncePath   = "/new-nonce"
+	NewAccountPath = "/new-account"
+	AccountPath    = "/account/"
+	NewOrderPath   = "/new-order"
+
+	problemPrefix = "urn:ietf:params:acme:error:"
+)
+
+type Account struct {
+	Url     string
+	Status  string
+	Contact []string
+	Key     jwk.Key
+}
+
+type Server struct {
+	*httptest.Server
+
+	lock            sync.Mutex
+	nonces          map[string]bool
+	rejectNextNonce bool
+	accounts        map[string]*Account
+	orders          map[string]*Order
+	authzs          map[string]*Authorization
+	challenges      map[string]*Authorization
+	nextId          int
+	mux             *http.ServeMux
+
+	authority authority
+	validate  ValidateFunc
+	validity  time.Duration
+}
+
+func NewServer(t testing.TB) *Server {
+	ca, err := newAuthority()
+	if err != nil {
+		t.Fatalf("creating acmetest authority: %v", err)
+	}
+
+	server := &Server{
+		nonces:     map[string]bool{},
+		accounts:   map[string]*Account{},
+		orders:     map[string]*Order{},
+		authzs:     map[string]*Authorization{},
+		challenges: map[string]*Authorization{},
+		mux:        http.NewServeMux(),
+		authority:  ca,
+		validity:   DefaultValidity,
+	}
+	server.mux.HandleFunc(DirectoryPath, server.handleDirectory)
+	server.mux.HandleFunc(NewNoncePath, server.handleNewNonce)
+	server.mux.HandleFunc(NewAccountPath, server.handleNewAccount)
+	server.mux.HandleFunc(AccountPath, server.handleAccount)
+	server.mux.HandleFunc(NewOrderPath, server.handleNewOrder)
+	server.mux.HandleFunc(OrderPath, server.handleOrder)
+	server.mux.HandleFunc(AuthzPath, server.handleAuthz)
+	server.mux.HandleFunc(ChallengePath, server.handleChallenge)
+	server.mux.HandleFunc(FinalizePath, server.handleFinalize)
+	server.mux.HandleFunc(CertificatePath, server.handleCertificate)
+
+	server.Server = httptest.NewServer(server.mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *Server) DirectoryUrl() *url.URL {
+	parsed, _ := url.Parse(server.URL + DirectoryPath)
+	return parsed
+}
+
+// RejectNextNonce makes the next signed request fail with badNonce.
+func (server *Server) RejectNextNonce() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.rejectNextNonce = true
+}
+
+func (server *Server) Accounts() []Account {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	accounts := make([]Account, 0, len(server.accounts))
+	for _, account := range server.accounts {
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
+
+func (server *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	writeJson(
+		w, http.StatusOK, map[string]interface{}{
+			"newNonce":   server.URL + NewNoncePath,
+			"newAccount": server.URL + NewAccountPath,
+			"newOrder":   server.URL + NewOrderPath,
+			"revokeCert": server.URL + "/revoke-cert",
+			"keyChange":  server.URL + "/key-change",
+		},
+	)
+}
+
+func (server *Server) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	server.addNonce(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (server *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, true)
+	if !ok {
+		return
+	}
+
+	payload := struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}{}
+	if err := json.Unmarshal(request.payload, &payload); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	thumbprint, err := thumbprintOf(request.key)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	for _, account := range server.accounts {
+		existingThumbprint, _ := thumbprintOf(account.Key)
+		if existingThumbprint == thumbprint {
+			w.Header().Set("Location", account.Url)
+			writeJson(w, http.StatusOK, accountJson(account))
+			return
+		}
+	}
+
+	if payload.OnlyReturnExisting {
+		writeProblem(
+			w, http.StatusBadRequest, "accountDoesNotExist",
+			"no account for key",
+		)
+		return
+	}
+
+	server.nextId++
+	account := &Account{
+		Url:     fmt.Sprintf("%s%s%d", server.URL, AccountPath, server.nextId),
+		Status:  "valid",
+		Contact: payload.Contact,
+		Key:     request.key,
+	}
+	server.accounts[account.Url] = account
+
+	w.Header().Set("Location", account.Url)
+	writeJson(w, http.StatusCreated, accountJson(account))
+}
+
+func (server *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	request, ok := server.verify(w, r, false)
+	if !ok {
+		return
+	}
+
+	if request.account.Url != server.URL+r.URL.Path {
+		writeProblem(
+			w, http.StatusForbidden, "unauthorized", "not your account",
+		)
+		return
+	}
+
+	update := struct {
+		Contact *[]string `json:"contact"`
+	}{}
+	if len(request.payload) > 0 {
+		if err := json.Unmarshal(request.payload, &update); err != nil {
+			writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+			return
+		}
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if update.Contact != nil {
+		request.account.Contact = *update.Contact
+	}
+	writeJson(w, http.StatusOK, accountJson(request.account))
+}
+
+type signedRequest struct {
+	payload []byte
+	key     jwk.Key
+	account *Account
+}
+
+// verify checks the JWS body of r, writing a problem and returning false if it
+// is invalid. Requests to newAccount must use a jwk, all others a kid.
+func (server *Server) verify(
+	w http.ResponseWriter, r *http.Request, useJwk bool,
+) (signedRequest, bool) {
+	server.addNonce(w)
+	request := signedRequest{}
+
+	if r.Method != http.MethodPost {
+		writeProblem(w, http.StatusMethodNotAllowed, "malformed", "not POST")
+		return request, false
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return request, false
+	}
+
+	flattened := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{}
+	if err := json.Unmarshal(body, &flattened); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return request, false
+	}
+	compact := []byte(
+		strings.Join(
+			[]string{
+				flattened.Protected, flattened.Payload, flattened.Signature,
+			}, ".",
+		),
+	)
+
+	message, err := jws.Parse(compact)
+	if err != nil || len(message.Signatures()) != 1 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "bad jws")
+		return request, false
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+
+	nonce, _ := headers.Get("nonce")
+	nonceStr, _ := nonce.(string)
+	server.lock.Lock()
+	validNonce := server.nonces[nonceStr] && !server.rejectNextNonce
+	delete(server.nonces, nonceStr)
+	server.rejectNextNonce = false
+	server.lock.Unlock()
+	if !validNonce {
+		writeProblem(w, http.StatusBadRequest, "badNonce", "bad nonce")
+		return request, false
+	}
+
+	requestUrl, _ := headers.Get("url")
+	if requestUrl != server.URL+r.URL.Path {
+		writeProblem(w, http.StatusUnauthorized, "unauthorized", "bad url")
+		return request, false
+	}
+
+	if useJwk {
+		if headers.JWK() == nil || headers.KeyID() != "" {
+			writeProblem(w, http.StatusBadRequest, "malformed", "expected jwk")
+			return request, false
+		}
+		request.key = headers.JWK()
+	} else {
+		server.lock.Lock()
+		request.account = server.accounts[headers.KeyID()]
+		server.lock.Unlock()
+		if headers.JWK() != nil || request.account == nil {
+			writeProblem(
+				w, http.StatusBadRequest, "accountDoesNotExist",
+				"expected known kid",
+			)
+			return request, false
+		}
+		request.key = request.account.Key
+	}
+
+	// verify against the raw key, as jwx compares any kid header to the key's
+	var rawKey interface{}
+	if err := request.key.Raw(&rawKey); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return request, false
+	}
+	payload, err := jws.Verify(compact, headers.Algorithm(), rawKey)
+	if err != nil {
+		writeProblem(w, http.StatusForbidden, "unauthorized", err.Error())
+		return request, false
+	}
+	request.payload = payload
+	return request, true
+}
+
+func (server *Server) addNonce(w http.ResponseWriter) {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+
+	server.lock.Lock()
+	server.nonces[nonce] = true
+	server.lock.Unlock()
+
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func accountJson(account *Account) map[string]interface{} {
+	return map[string]interface{}{
+		"status":  account.Status,
+		"contact": account.Contact,
+		"orders":  account.Url + "/orders",
+	}
+}
+
+func thumbprintOf(key jwk.Key) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeProblem(w http.ResponseWriter, status int, kind, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(
+		map[string]interface{}{
+			"type":   problemPrefix + kind,
+			"detail": detail,
+			"status": status,
+		},
+	)
+}
@@ -0,0 +1,108 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/figglewatts/certforgot/pkg/state"
+)
+
+// Bootstrap returns a client for the account held under accountKey in
+// stateSource. On first run a new account key is generated, persisted and
+// registered. If email no longer matches the stored email the account contact
+// and state are updated. State from before multiple accounts were held is
+// adopted as accountKey's.
+func Bootstrap(
+	ctx context.Context, directoryUrl *url.URL, email *mail.Address,
+	stateSource state.Source, accountKey state.AccountKey,
+	httpClient *http.Client,
+) (*Client, error) {
+	exists, err := stateSource.Exists(ctx, accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("checking state: %v", err)
+	}
+
+	adopter, ok := stateSource.(state.LegacyAdopter)
+	if !exists && ok {
+		exists, err = adopter.AdoptLegacy(ctx, accountKey)
+		if err != nil {
+			return nil, fmt.Errorf("adopting legacy state: %v", err)
+		}
+	}
+
+	var s state.State
+	var version state.Version
+	if exists {
+		s, version, err = stateSource.Get(ctx, accountKey)
+		if err != nil {
+			return nil, fmt.Errorf("getting state: %v", err)
+		}
+	} else {
+		key, err := NewAccountKey()
+		if err != nil {
+			return nil, fmt.Errorf("generating account key: %v", err)
+		}
+
+		// persist before registering so that the key is never lost
+		s = state.NewState(email, key)
+		version, err = stateSource.Update(ctx, accountKey, s, state.NoVersion)
+		if err != nil {
+			return nil, fmt.Errorf("saving new state: %v", err)
+		}
+	}
+
+	client, err := NewClient(ctx, directoryUrl, s.UserPrivateKey.Key, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("creating acme client: %v", err)
+	}
+
+	account, err := client.Lookup(ctx)
+	if errors.Is(err, ErrAccountDoesNotExist) {
+		account, err = client.Register(ctx, email)
+		if err != nil {
+			return nil, fmt.Errorf("registering account: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("looking up account: %v", err)
+	}
+
+	if !sameEmail(s.UserEmail.Address, email) ||
+		!hasContact(account, email) {
+		if _, err := client.UpdateContact(ctx, email); err != nil {
+			return nil, fmt.Errorf("updating account contact: %v", err)
+		}
+
+		s.UserEmail = state.Email{Address: email}
+		_, err = stateSource.Update(ctx, accountKey, s, version)
+		if err != nil {
+			return nil, fmt.Errorf("saving updated state: %v", err)
+		}
+	}
+
+	return client, nil
+}
+
+func sameEmail(a, b *mail.Address) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return strings.EqualFold(a.Address, b.Address)
+}
+
+func hasContact(account Account, email *mail.Address) bool {
+	wanted := contactFor(email)
+	if len(wanted) == 0 {
+		return len(account.Contact) == 0
+	}
+	for _, contact := range account.Contact {
+		if strings.EqualFold(contact, wanted[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package acme
+
+import (
+	"context"
+	"io/ioutil"
+	"net/mail"
+	"path"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/figglewatts/certforgot/pkg/azure/azuretest"
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBootstrap(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	stateSource, err := state.NewLocalSource(t.TempDir(), nil)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "first@example.com"}
+	key := state.NewAccountKey(server.DirectoryUrl(), nil)
+
+	// first run creates and persists an account
+	client, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, client.AccountUrl())
+
+	saved, _, err := stateSource.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "first@example.com", saved.UserEmail.Address.Address)
+	savedThumbprint, err := Thumbprint(saved.UserPrivateKey.Key)
+	assert.NoError(t, err)
+	clientThumbprint, err := Thumbprint(client.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, clientThumbprint, savedThumbprint)
+
+	// a later run reuses the account
+	again, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, client.AccountUrl(), again.AccountUrl())
+	assert.Len(t, server.Accounts(), 1)
+
+	// changing the email updates the contact rather than a new account
+	changed := &mail.Address{Address: "second@example.com"}
+	updated, err := Bootstrap(
+		ctx, server.DirectoryUrl(), changed, stateSource, key,
+		server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, client.AccountUrl(), updated.AccountUrl())
+
+	accounts := server.Accounts()
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, []string{"mailto:second@example.com"}, accounts[0].Contact)
+
+	saved, _, err = stateSource.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "second@example.com", saved.UserEmail.Address.Address)
+}
+
+func TestBootstrap_AzureKeyVault(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	stateSource, err := state.NewAzureKeyVaultSource(
+		azuretest.NewKeyVault(), nil,
+	)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "test@example.com"}
+	key := state.NewAccountKey(server.DirectoryUrl(), nil)
+
+	client, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+
+	// the second run signs with the key read back from the vault
+	again, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, client.AccountUrl(), again.AccountUrl())
+	assert.Len(t, server.Accounts(), 1)
+}
+
+func TestBootstrap_LegacyState(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	directory := t.TempDir()
+	stateSource, err := state.NewLocalSource(directory, nil)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "test@example.com"}
+	key := state.NewAccountKey(server.DirectoryUrl(), nil)
+
+	// an account registered before the state held multiple accounts
+	legacyKey := accountKey(t)
+	legacy, err := NewClient(ctx, server.DirectoryUrl(), legacyKey, server.Client())
+	assert.NoError(t, err)
+	_, err = legacy.Register(ctx, email)
+	assert.NoError(t, err)
+	marshaled, err := yaml.Marshal(state.NewState(email, legacyKey))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(
+		path.Join(directory, state.FileName), marshaled, 0644,
+	)
+	assert.NoError(t, err)
+
+	client, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.AccountUrl(), client.AccountUrl())
+	assert.Len(t, server.Accounts(), 1)
+
+	keys, err := stateSource.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []state.AccountKey{key}, keys)
+}
+
+func TestBootstrap_LegacyAzureKeyVault(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	vault := azuretest.NewKeyVault()
+	stateSource, err := state.NewAzureKeyVaultSource(vault, nil)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "test@example.com"}
+	key := state.NewAccountKey(server.DirectoryUrl(), nil)
+
+	// an earlier version imported the key, which can't be read back
+	_, err = vault.SetSecret(
+		ctx, state.DefaultEmailSecretName, "<test@example.com>",
+	)
+	assert.NoError(t, err)
+	err = vault.ImportKey(ctx, state.DefaultKeyName, accountKey(t))
+	assert.NoError(t, err)
+
+	_, err = Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.ErrorContains(t, err, state.DefaultEmailSecretName)
+	assert.Empty(t, server.Accounts())
+}
+
+func TestBootstrap_UnregisteredState(t *testing.T) {
+	server := acmetest.NewServer(t)
+	ctx := context.Background()
+	stateSource, err := state.NewLocalSource(t.TempDir(), nil)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "test@example.com"}
+	key := state.NewAccountKey(server.DirectoryUrl(), nil)
+
+	// a key saved by a run that failed before registering
+	_, err = stateSource.Update(
+		ctx, key, state.NewState(email, accountKey(t)), state.NoVersion,
+	)
+	assert.NoError(t, err)
+
+	client, err := Bootstrap(
+		ctx, server.DirectoryUrl(), email, stateSource, key, server.Client(),
+	)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, client.AccountUrl())
+	assert.Len(t, server.Accounts(), 1)
+}
+
+func TestBootstrap_MultipleDirectories(t *testing.T) {
+	staging := acmetest.NewServer(t)
+	production := acmetest.NewServer(t)
+	ctx := context.Background()
+	stateSource, err := state.NewLocalSource(t.TempDir(), nil)
+	assert.NoError(t, err)
+	email := &mail.Address{Address: "test@example.com"}
+
+	thumbprints := map[string]string{}
+	for _, server := range []*acmetest.Server{staging, production, staging} {
+		key := state.NewAccountKey(server.DirectoryUrl(), nil)
+		client, err := Bootstrap(
+			ctx, server.DirectoryUrl(), email, stateSource, key,
+			server.Client(),
+		)
+		assert.NoError(t, err)
+		assert.Len(t, server.Accounts(), 1)
+
+		thumbprint, err := Thumbprint(client.Key())
+		assert.NoError(t, err)
+		if existing, ok := thumbprints[key.Directory]; ok {
+			assert.Equal(t, existing, thumbprint)
+		}
+		thumbprints[key.Directory] = thumbprint
+	}
+
+	// each directory has its own account key
+	assert.Len(t, thumbprints, 2)
+	assert.NotEqual(
+		t, thumbprints[staging.DirectoryUrl().String()],
+		thumbprints[production.DirectoryUrl().String()],
+	)
+	keys, err := stateSource.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestClient_UpdateContact(t *testing.T) {
+	client, server := fakeClient(t)
+	ctx := context.Background()
+
+	_, err := client.UpdateContact(ctx, nil)
+	assert.Error(t, err)
+
+	_, err = client.Register(ctx, &mail.Address{Address: "old@example.com"})
+	assert.NoError(t, err)
+
+	account, err := client.UpdateContact(
+		ctx, &mail.Address{Address: "new@example.com"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mailto:new@example.com"}, account.Contact)
+	assert.Equal(
+		t, []string{"mailto:new@example.com"}, server.Accounts()[0].Contact,
+	)
+
+	account, err = client.UpdateContact(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, account.Contact)
+	assert.Empty(t, server.Accounts()[0].Contact)
+}
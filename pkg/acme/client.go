@@ -0,0 +1,237 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	ContentTypeJose = "application/jose+json"
+
+	maxBadNonceRetries = 3
+)
+
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+	Meta       struct {
+		TermsOfService          string `json:"termsOfService"`
+		ExternalAccountRequired bool   `json:"externalAccountRequired"`
+	} `json:"meta"`
+}
+
+type Client struct {
+	httpClient *http.Client
+	directory  Directory
+	key        jwk.Key
+	accountUrl string
+
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+
+	nonceLock sync.Mutex
+	nonces    []string
+}
+
+func NewClient(
+	ctx context.Context, directoryUrl *url.URL, key jwk.Key,
+	httpClient *http.Client,
+) (*Client, error) {
+	if key == nil {
+		return nil, errors.New("account key must not be nil")
+	}
+	if _, err := signatureAlgorithm(key); err != nil {
+		return nil, err
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	client := &Client{
+		httpClient:   httpClient,
+		key:          key,
+		pollInterval: DefaultPollInterval,
+		pollTimeout:  DefaultPollTimeout,
+	}
+	if err := client.discover(ctx, directoryUrl); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (client *Client) Directory() Directory {
+	return client.directory
+}
+
+func (client *Client) Key() jwk.Key {
+	return client.key
+}
+
+func (client *Client) AccountUrl() string {
+	return client.accountUrl
+}
+
+func (client *Client) discover(ctx context.Context, directoryUrl *url.URL) error {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, directoryUrl.String(), nil,
+	)
+	if err != nil {
+		return fmt.Errorf("creating directory request: %v", err)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching directory '%s': %v", directoryUrl, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(
+			"fetching directory '%s': %v", directoryUrl, problemFrom(resp),
+		)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&client.directory); err != nil {
+		return fmt.Errorf("decoding directory: %v", err)
+	}
+	if client.directory.NewNonce == "" || client.directory.NewAccount == "" {
+		return fmt.Errorf("directory '%s' is incomplete", directoryUrl)
+	}
+	return nil
+}
+
+func (client *Client) nonce(ctx context.Context) (string, error) {
+	client.nonceLock.Lock()
+	if n := len(client.nonces); n > 0 {
+		nonce := client.nonces[n-1]
+		client.nonces = client.nonces[:n-1]
+		client.nonceLock.Unlock()
+		return nonce, nil
+	}
+	client.nonceLock.Unlock()
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodHead, client.directory.NewNonce, nil,
+	)
+	if err != nil {
+		return "", fmt.Errorf("creating nonce request: %v", err)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching nonce: %v", err)
+	}
+	resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("server did not return a nonce")
+	}
+	return nonce, nil
+}
+
+func (client *Client) storeNonce(resp *http.Response) {
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return
+	}
+
+	client.nonceLock.Lock()
+	defer client.nonceLock.Unlock()
+	client.nonces = append(client.nonces, nonce)
+}
+
+// post sends a signed request to url. A nil payload is sent as a POST-as-GET.
+// When result is a *[]byte the raw response body is returned into it,
+// otherwise the body is decoded as JSON.
+func (client *Client) post(
+	ctx context.Context, url string, payload interface{}, result interface{},
+) (*http.Response, error) {
+	return client.postSigned(ctx, url, payload, result, false)
+}
+
+// postSigned is post, signing with the key's jwk rather than the account url
+// if useJwk is set, as requests to create or find an account must be.
+func (client *Client) postSigned(
+	ctx context.Context, url string, payload interface{}, result interface{},
+	useJwk bool,
+) (*http.Response, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		resp, err = client.postOnce(ctx, url, payload, result, useJwk)
+		if err == nil {
+			return resp, nil
+		}
+
+		var problem Problem
+		if !errors.As(err, &problem) || problem.Type != ProblemBadNonce ||
+			attempt >= maxBadNonceRetries {
+			return resp, err
+		}
+	}
+}
+
+func (client *Client) postOnce(
+	ctx context.Context, url string, payload interface{}, result interface{},
+	useJwk bool,
+) (*http.Response, error) {
+	nonce, err := client.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.sign(url, nonce, payload, useJwk)
+	if err != nil {
+		return nil, fmt.Errorf("signing request to '%s': %v", url, err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, url, bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating request to '%s': %v", url, err)
+	}
+	req.Header.Set("Content-Type", ContentTypeJose)
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("posting to '%s': %v", url, err)
+	}
+	defer resp.Body.Close()
+	client.storeNonce(resp)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, problemFrom(resp)
+	}
+
+	switch r := result.(type) {
+	case nil:
+	case *[]byte:
+		*r, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, fmt.Errorf("reading response from '%s': %v", url, err)
+		}
+	default:
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp, fmt.Errorf(
+				"decoding response from '%s': %v", url, err,
+			)
+		}
+	}
+	return resp, nil
+}
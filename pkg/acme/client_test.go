@@ -0,0 +1,83 @@
+package acme
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClient(t *testing.T) {
+	server := acmetest.NewServer(t)
+	symmetricKey, err := jwk.New([]byte("test"))
+	assert.NoError(t, err)
+	badUrl, err := url.Parse(server.URL + "/missing")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		directoryUrl *url.URL
+		key          jwk.Key
+		wantErr      assert.ErrorAssertionFunc
+	}{
+		{"works", server.DirectoryUrl(), accountKey(t), assert.NoError},
+		{"nil key", server.DirectoryUrl(), nil, assert.Error},
+		{"symmetric key", server.DirectoryUrl(), symmetricKey, assert.Error},
+		{"bad directory", badUrl, accountKey(t), assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client, err := NewClient(
+					context.Background(), tt.directoryUrl, tt.key,
+					server.Client(),
+				)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(
+					t, server.URL+acmetest.NewAccountPath,
+					client.Directory().NewAccount,
+				)
+			},
+		)
+	}
+}
+
+func TestClient_nonce(t *testing.T) {
+	client, _ := fakeClient(t)
+	ctx := context.Background()
+
+	first, err := client.nonce(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first)
+
+	second, err := client.nonce(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestClient_post_BadNonceRetry(t *testing.T) {
+	client, server := fakeClient(t)
+	ctx := context.Background()
+
+	server.RejectNextNonce()
+	account, err := client.Register(ctx, nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, account.Url)
+}
+
+func TestThumbprint(t *testing.T) {
+	key := accountKey(t)
+	publicKey, err := key.PublicKey()
+	assert.NoError(t, err)
+
+	private, err := Thumbprint(key)
+	assert.NoError(t, err)
+	public, err := Thumbprint(publicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, private, public)
+}
@@ -0,0 +1,119 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+type flattenedJws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func signatureAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case jwk.RSAPrivateKey:
+		return jwa.RS256, nil
+	case jwk.ECDSAPrivateKey:
+		switch k.Crv() {
+		case jwa.P256:
+			return jwa.ES256, nil
+		case jwa.P384:
+			return jwa.ES384, nil
+		case jwa.P521:
+			return jwa.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported curve '%s'", k.Crv())
+	case jwk.OKPPrivateKey:
+		return jwa.EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported account key type '%s'", key.KeyType())
+}
+
+// Thumbprint returns the base64url encoded RFC 7638 thumbprint of key, as
+// used in challenge key authorizations.
+func Thumbprint(key jwk.Key) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+func (client *Client) sign(
+	url string, nonce string, payload interface{}, useJwk bool,
+) ([]byte, error) {
+	headers := jws.NewHeaders()
+	if err := headers.Set("nonce", nonce); err != nil {
+		return nil, err
+	}
+	if err := headers.Set("url", url); err != nil {
+		return nil, err
+	}
+
+	if client.accountUrl != "" && !useJwk {
+		if err := headers.Set(jws.KeyIDKey, client.accountUrl); err != nil {
+			return nil, err
+		}
+	} else {
+		publicKey, err := client.key.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("getting public key: %v", err)
+		}
+		if err := headers.Set(jws.JWKKey, publicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return signJws(client.key, headers, payload)
+}
+
+func signJws(
+	key jwk.Key, headers jws.Headers, payload interface{},
+) ([]byte, error) {
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var marshaledPayload []byte
+	if payload != nil {
+		marshaledPayload, err = json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling payload: %v", err)
+		}
+	}
+
+	// jws copies a jwk's own kid into the header, where it would replace the
+	// account url, so the raw key is signed with instead
+	var rawKey interface{}
+	if err := key.Raw(&rawKey); err != nil {
+		return nil, fmt.Errorf("getting raw key: %v", err)
+	}
+	compact, err := jws.Sign(
+		marshaledPayload, alg, rawKey, jws.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, encodedPayload, signature, err := jws.SplitCompact(compact)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(
+		flattenedJws{
+			Protected: string(protected),
+			Payload:   string(encodedPayload),
+			Signature: string(signature),
+		},
+	)
+}
@@ -0,0 +1,380 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
+	StatusRevoked     = "revoked"
+
+	IdentifierTypeDns = "dns"
+
+	CertificateKeyBits = 2048
+
+	DefaultPollInterval = time.Second
+	DefaultPollTimeout  = 5 * time.Minute
+)
+
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type Order struct {
+	Url            string       `json:"-"`
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires,omitempty"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+type Authorization struct {
+	Url        string      `json:"-"`
+	Status     string      `json:"status"`
+	Identifier Identifier  `json:"identifier"`
+	Challenges []Challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
+}
+
+type Challenge struct {
+	Type   string   `json:"type"`
+	Url    string   `json:"url"`
+	Status string   `json:"status"`
+	Token  string   `json:"token"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+type Certificate struct {
+	Leaf          *x509.Certificate
+	Intermediates []*x509.Certificate
+	PrivateKey    *rsa.PrivateKey
+}
+
+// Solver fulfils a single type of ACME challenge, e.g. http-01 or dns-01.
+type Solver interface {
+	Type() string
+	Present(ctx context.Context, domain, token, keyAuthorization string) error
+	CleanUp(ctx context.Context, domain, token, keyAuthorization string) error
+}
+
+// Issue orders a certificate for domains, solving each authorization with
+// solver, and returns the issued chain with its newly generated private key.
+// The client must already have an account.
+func (client *Client) Issue(
+	ctx context.Context, domains []string, solver Solver,
+) (Certificate, error) {
+	if client.accountUrl == "" {
+		return Certificate{}, errors.New("client has no account")
+	}
+	if len(domains) == 0 {
+		return Certificate{}, errors.New("no domains to issue for")
+	}
+
+	order, err := client.NewOrder(ctx, domains)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("creating order: %v", err)
+	}
+
+	for _, authzUrl := range order.Authorizations {
+		if err := client.authorize(ctx, authzUrl, solver); err != nil {
+			return Certificate{}, fmt.Errorf(
+				"authorizing '%s': %v", authzUrl, err,
+			)
+		}
+	}
+
+	order, err = client.pollOrder(ctx, order.Url, StatusReady)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, CertificateKeyBits)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("generating key: %v", err)
+	}
+
+	csr, err := createCsr(domains, key)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("creating csr: %v", err)
+	}
+
+	if err := client.finalize(ctx, order, csr); err != nil {
+		return Certificate{}, fmt.Errorf("finalizing order: %v", err)
+	}
+
+	order, err = client.pollOrder(ctx, order.Url, StatusValid)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	leaf, intermediates, err := client.downloadCertificate(
+		ctx, order.Certificate,
+	)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("downloading certificate: %v", err)
+	}
+
+	return Certificate{leaf, intermediates, key}, nil
+}
+
+func (client *Client) NewOrder(
+	ctx context.Context, domains []string,
+) (Order, error) {
+	request := struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}{}
+	for _, domain := range domains {
+		request.Identifiers = append(
+			request.Identifiers, Identifier{IdentifierTypeDns, domain},
+		)
+	}
+
+	order := Order{}
+	resp, err := client.post(ctx, client.directory.NewOrder, request, &order)
+	if err != nil {
+		return order, err
+	}
+
+	order.Url = resp.Header.Get("Location")
+	if order.Url == "" {
+		return order, errors.New("server did not return an order url")
+	}
+	return order, nil
+}
+
+func (client *Client) GetOrder(ctx context.Context, url string) (Order, error) {
+	order := Order{Url: url}
+	_, err := client.post(ctx, url, nil, &order)
+	return order, err
+}
+
+func (client *Client) GetAuthorization(
+	ctx context.Context, url string,
+) (Authorization, error) {
+	authz := Authorization{Url: url}
+	_, err := client.post(ctx, url, nil, &authz)
+	return authz, err
+}
+
+// KeyAuthorization returns the key authorization for token, as in RFC 8555
+// section 8.1.
+func (client *Client) KeyAuthorization(token string) (string, error) {
+	thumbprint, err := Thumbprint(client.key)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
+
+func (client *Client) authorize(
+	ctx context.Context, authzUrl string, solver Solver,
+) (err error) {
+	authz, err := client.GetAuthorization(ctx, authzUrl)
+	if err != nil {
+		return err
+	}
+	if authz.Status == StatusValid {
+		return nil
+	}
+	if authz.Status != StatusPending {
+		return fmt.Errorf("authorization is %s", authz.Status)
+	}
+
+	var challenge *Challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == solver.Type() {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf(
+			"no %s challenge offered for '%s'", solver.Type(),
+			authz.Identifier.Value,
+		)
+	}
+
+	keyAuth, err := client.KeyAuthorization(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("computing key authorization: %v", err)
+	}
+
+	domain := authz.Identifier.Value
+	if err := solver.Present(ctx, domain, challenge.Token, keyAuth); err != nil {
+		return fmt.Errorf("presenting challenge: %v", err)
+	}
+	defer func() {
+		cleanUpErr := solver.CleanUp(ctx, domain, challenge.Token, keyAuth)
+		if err == nil && cleanUpErr != nil {
+			err = fmt.Errorf("cleaning up challenge: %v", cleanUpErr)
+		}
+	}()
+
+	// an empty object tells the server we're ready for validation
+	if _, err := client.post(ctx, challenge.Url, struct{}{}, nil); err != nil {
+		return fmt.Errorf("responding to challenge: %v", err)
+	}
+
+	return client.poll(
+		ctx, func() (string, *Problem, *http.Response, error) {
+			authz := Authorization{}
+			resp, err := client.post(ctx, authzUrl, nil, &authz)
+			var problem *Problem
+			for _, c := range authz.Challenges {
+				if c.Error != nil {
+					problem = c.Error
+				}
+			}
+			return authz.Status, problem, resp, err
+		}, StatusValid,
+	)
+}
+
+func (client *Client) pollOrder(
+	ctx context.Context, url string, want string,
+) (Order, error) {
+	order := Order{Url: url}
+	err := client.poll(
+		ctx, func() (string, *Problem, *http.Response, error) {
+			order = Order{Url: url}
+			resp, err := client.post(ctx, url, nil, &order)
+			return order.Status, order.Error, resp, err
+		}, want,
+	)
+	if err != nil {
+		return order, fmt.Errorf("waiting for order to be %s: %v", want, err)
+	}
+	return order, nil
+}
+
+// poll calls fetch until it reports the wanted status, a final status, or the
+// poll timeout elapses, honouring any Retry-After the server sends.
+func (client *Client) poll(
+	ctx context.Context,
+	fetch func() (string, *Problem, *http.Response, error),
+	want string,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, client.pollTimeout)
+	defer cancel()
+
+	for {
+		status, problem, resp, err := fetch()
+		if err != nil {
+			return err
+		}
+
+		switch status {
+		case want:
+			return nil
+		case StatusInvalid, StatusDeactivated, StatusExpired, StatusRevoked:
+			if problem != nil {
+				return fmt.Errorf("status is %s: %v", status, problem)
+			}
+			return fmt.Errorf("status is %s", status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("still %s: %v", status, ctx.Err())
+		case <-time.After(client.retryAfter(resp)):
+		}
+	}
+}
+
+func (client *Client) retryAfter(resp *http.Response) time.Duration {
+	if resp != nil {
+		header := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(header); err == nil {
+			return time.Until(date)
+		}
+	}
+	return client.pollInterval
+}
+
+func (client *Client) finalize(
+	ctx context.Context, order Order, csr []byte,
+) error {
+	request := struct {
+		Csr string `json:"csr"`
+	}{base64.RawURLEncoding.EncodeToString(csr)}
+
+	_, err := client.post(ctx, order.Finalize, request, nil)
+	return err
+}
+
+func (client *Client) downloadCertificate(
+	ctx context.Context, url string,
+) (*x509.Certificate, []*x509.Certificate, error) {
+	if url == "" {
+		return nil, nil, errors.New("order has no certificate url")
+	}
+
+	var chainPem []byte
+	if _, err := client.post(ctx, url, nil, &chainPem); err != nil {
+		return nil, nil, err
+	}
+
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, chainPem = pem.Decode(chainPem)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing certificate: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil, errors.New("no certificates in response")
+	}
+	return chain[0], chain[1:], nil
+}
+
+func createCsr(domains []string, key *rsa.PrivateKey) ([]byte, error) {
+	commonName := domains[0]
+	for _, domain := range domains {
+		if !strings.HasPrefix(domain, "*.") {
+			commonName = domain
+			break
+		}
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: domains,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
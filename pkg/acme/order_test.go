@@ -0,0 +1,125 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSolver struct {
+	challengeType string
+	presented     map[string]string
+	cleanedUp     []string
+}
+
+func newFakeSolver(challengeType string) *fakeSolver {
+	return &fakeSolver{challengeType, map[string]string{}, nil}
+}
+
+func (solver *fakeSolver) Type() string {
+	return solver.challengeType
+}
+
+func (solver *fakeSolver) Present(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	solver.presented[token] = keyAuthorization
+	return nil
+}
+
+func (solver *fakeSolver) CleanUp(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	solver.cleanedUp = append(solver.cleanedUp, token)
+	return nil
+}
+
+func TestClient_Issue(t *testing.T) {
+	tests := []struct {
+		name          string
+		domains       []string
+		challengeType string
+		validateErr   error
+		wantErr       assert.ErrorAssertionFunc
+	}{
+		{
+			"single", []string{"example.com"}, "http-01", nil,
+			assert.NoError,
+		},
+		{
+			"wildcard", []string{"example.com", "*.example.com"}, "dns-01",
+			nil, assert.NoError,
+		},
+		{
+			"wildcard without dns-01", []string{"*.example.com"}, "http-01",
+			nil, assert.Error,
+		},
+		{
+			"validation failed", []string{"example.com"}, "http-01",
+			errors.New("wrong key authorization"), assert.Error,
+		},
+		{"no domains", nil, "http-01", nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client, server := fakeClient(t)
+				client.pollInterval = 0
+				ctx := context.Background()
+				_, err := client.Register(ctx, nil)
+				assert.NoError(t, err)
+
+				server.SetValidate(
+					func(challengeType, domain, token, keyAuth string) error {
+						return tt.validateErr
+					},
+				)
+				solver := newFakeSolver(tt.challengeType)
+
+				got, err := client.Issue(ctx, tt.domains, solver)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+
+				assert.ElementsMatch(t, tt.domains, got.Leaf.DNSNames)
+				assert.Equal(t, server.Issuer().Raw, got.Intermediates[0].Raw)
+				assert.NoError(t, got.Leaf.CheckSignatureFrom(server.Issuer()))
+				assert.Equal(t, &got.PrivateKey.PublicKey, got.Leaf.PublicKey)
+				assert.Len(t, solver.presented, len(tt.domains))
+				assert.Len(t, solver.cleanedUp, len(tt.domains))
+			},
+		)
+	}
+}
+
+func TestClient_Issue_NoAccount(t *testing.T) {
+	client, _ := fakeClient(t)
+	_, err := client.Issue(
+		context.Background(), []string{"example.com"},
+		newFakeSolver("http-01"),
+	)
+	assert.Error(t, err)
+}
+
+func TestClient_KeyAuthorization(t *testing.T) {
+	client, _ := fakeClient(t)
+	thumbprint, err := Thumbprint(client.Key())
+	assert.NoError(t, err)
+
+	got, err := client.KeyAuthorization("token")
+	assert.NoError(t, err)
+	assert.Equal(t, "token."+thumbprint, got)
+}
+
+func Test_createCsr(t *testing.T) {
+	key := testCertificateKey(t)
+	csrDer, err := createCsr([]string{"*.example.com", "example.com"}, key)
+	assert.NoError(t, err)
+
+	csr, err := parseCsr(csrDer)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", csr.Subject.CommonName)
+	assert.Equal(t, []string{"*.example.com", "example.com"}, csr.DNSNames)
+}
@@ -0,0 +1,47 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	ProblemPrefix = "urn:ietf:params:acme:error:"
+
+	ProblemBadNonce              = ProblemPrefix + "badNonce"
+	ProblemAccountDoesNotExist   = ProblemPrefix + "accountDoesNotExist"
+	ProblemMalformed             = ProblemPrefix + "malformed"
+	ProblemUnauthorized          = ProblemPrefix + "unauthorized"
+	ProblemBadSignatureAlgorithm = ProblemPrefix + "badSignatureAlgorithm"
+)
+
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (problem Problem) Error() string {
+	return fmt.Sprintf(
+		"acme problem '%s' (status %d): %s", problem.Type, problem.Status,
+		problem.Detail,
+	)
+}
+
+func problemFrom(resp *http.Response) error {
+	problem := Problem{Status: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading error response: %v", err)
+	}
+
+	if err := json.Unmarshal(body, &problem); err != nil {
+		return fmt.Errorf(
+			"unexpected response (status %d): %s", resp.StatusCode, body,
+		)
+	}
+	return problem
+}
@@ -0,0 +1,43 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+func accountKey(t *testing.T) jwk.Key {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	key, err := jwk.New(privateKey)
+	assert.NoError(t, err)
+	return key
+}
+
+func fakeClient(t *testing.T) (*Client, *acmetest.Server) {
+	server := acmetest.NewServer(t)
+	client, err := NewClient(
+		context.Background(), server.DirectoryUrl(), accountKey(t),
+		server.Client(),
+	)
+	assert.NoError(t, err)
+	return client, server
+}
+
+func testCertificateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	return key
+}
+
+func parseCsr(der []byte) (*x509.CertificateRequest, error) {
+	return x509.ParseCertificateRequest(der)
+}
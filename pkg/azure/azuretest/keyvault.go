@@ -0,0 +1,109 @@
+// Package azuretest provides in-memory fakes of the azure clients for tests.
+package azuretest
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"strconv"
+	"sync"
+
+	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// KeyVault is a fake azure.KeyVaultClient. Like Key Vault, it only gives back
+// the public half of the keys imported into it.
+type KeyVault struct {
+	lock         sync.Mutex
+	keys         map[string]jwk.Key
+	secrets      map[string][]azure.Secret
+	certificates map[string][]*x509.Certificate
+}
+
+func NewKeyVault() *KeyVault {
+	return &KeyVault{
+		keys:         map[string]jwk.Key{},
+		secrets:      map[string][]azure.Secret{},
+		certificates: map[string][]*x509.Certificate{},
+	}
+}
+
+func (vault *KeyVault) GetKey(
+	ctx context.Context, keyName string, version string,
+) (jwk.Key, error) {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	key, ok := vault.keys[keyName]
+	if !ok {
+		return nil, nil
+	}
+	return key.PublicKey()
+}
+
+func (vault *KeyVault) ImportKey(
+	ctx context.Context, keyName string, key jwk.Key,
+) error {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	vault.keys[keyName] = key
+	return nil
+}
+
+// GetSecret gets the latest version of the secret if version is empty.
+func (vault *KeyVault) GetSecret(
+	ctx context.Context, secretName string, version string,
+) (*azure.Secret, error) {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	versions := vault.secrets[secretName]
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	if version == "" {
+		latest := versions[len(versions)-1]
+		return &latest, nil
+	}
+	for _, secret := range versions {
+		if secret.Version == version {
+			return &secret, nil
+		}
+	}
+	return nil, nil
+}
+
+// SetSecret versions secrets by counting from 1.
+func (vault *KeyVault) SetSecret(
+	ctx context.Context, secretName string, value string,
+) (string, error) {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	version := strconv.Itoa(len(vault.secrets[secretName]) + 1)
+	vault.secrets[secretName] = append(
+		vault.secrets[secretName],
+		azure.Secret{Value: value, Version: version},
+	)
+	return version, nil
+}
+
+func (vault *KeyVault) GetCertificate(
+	ctx context.Context, certificateName string, version string,
+) (*x509.Certificate, error) {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	chain := vault.certificates[certificateName]
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain[0], nil
+}
+
+func (vault *KeyVault) ImportCertificate(
+	ctx context.Context, certificateName string,
+	chain []*x509.Certificate, key *rsa.PrivateKey,
+) error {
+	vault.lock.Lock()
+	defer vault.lock.Unlock()
+	vault.certificates[certificateName] = chain
+	return nil
+}
@@ -1,21 +1,48 @@
 package azure
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"path"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
+const (
+	// blob leases are either infinite or between these durations
+	MinLeaseDuration = 15 * time.Second
+	MaxLeaseDuration = 60 * time.Second
+)
+
+// ErrLeased is returned when acquiring a lease on a blob that's leased.
+var ErrLeased = errors.New("blob is leased")
+
+// ErrModified is returned when uploading a blob that has changed since it was
+// downloaded.
+var ErrModified = errors.New("blob was modified")
+
 type BlobClient interface {
-	Upload(ctx context.Context, buffer []byte) error
-	Download(ctx context.Context) ([]byte, error)
+	// Upload replaces the blob if its ETag is still etag, or creates it if
+	// etag is empty and the blob doesn't exist, returning the new ETag. It
+	// returns ErrModified if neither is the case.
+	Upload(ctx context.Context, buffer []byte, etag string) (string, error)
+	// Download returns the blob's contents and ETag.
+	Download(ctx context.Context) ([]byte, string, error)
 	Exists(ctx context.Context) (bool, error)
+
+	// AcquireLease leases the blob, creating it if it doesn't exist, and
+	// returns the lease ID.
+	AcquireLease(ctx context.Context, duration time.Duration) (string, error)
+	RenewLease(ctx context.Context, leaseId string) error
+	ReleaseLease(ctx context.Context, leaseId string) error
 }
 
 //go:generate mockery --name BlobClient --filename blobclient_mock.go --with-expecter
@@ -39,22 +66,50 @@ func NewBlobClient(containerUrl *url.URL, blobName string) (BlobClient, error) {
 	return blobClient{client}, nil
 }
 
-func (client blobClient) Upload(ctx context.Context, buffer []byte) error {
-	_, err := client.blob.UploadBuffer(
-		ctx, buffer, azblob.UploadOption{},
+func (client blobClient) Upload(
+	ctx context.Context, buffer []byte, etag string,
+) (string, error) {
+	conditions := &azblob.ModifiedAccessConditions{IfMatch: &etag}
+	if etag == "" {
+		conditions = &azblob.ModifiedAccessConditions{IfNoneMatch: to.Ptr("*")}
+	}
+
+	resp, err := client.blob.Upload(
+		ctx, streaming.NopCloser(bytes.NewReader(buffer)),
+		&azblob.BlockBlobUploadOptions{
+			BlobAccessConditions: &azblob.BlobAccessConditions{
+				ModifiedAccessConditions: conditions,
+			},
+		},
 	)
-	if err != nil {
-		return fmt.Errorf("uploading: %v", err)
+	if hasErrorCode(err, azblob.StorageErrorCodeConditionNotMet) ||
+		hasErrorCode(err, azblob.StorageErrorCodeBlobAlreadyExists) {
+		return "", ErrModified
+	} else if err != nil {
+		return "", fmt.Errorf("uploading: %v", err)
 	}
-	return nil
+
+	if resp.ETag == nil {
+		return "", errors.New("uploading: no etag")
+	}
+	return *resp.ETag, nil
 }
 
-func (client blobClient) Download(ctx context.Context) ([]byte, error) {
+func (client blobClient) Download(ctx context.Context) ([]byte, string, error) {
 	resp, err := client.blob.Download(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("downloading: %v", err)
+		return nil, "", fmt.Errorf("downloading: %v", err)
 	}
-	return ioutil.ReadAll(resp.Body(nil))
+	buffer, err := ioutil.ReadAll(resp.Body(nil))
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading: %v", err)
+	}
+
+	var etag string
+	if resp.ETag != nil {
+		etag = *resp.ETag
+	}
+	return buffer, etag, nil
 }
 
 func (client blobClient) Exists(ctx context.Context) (bool, error) {
@@ -70,3 +125,70 @@ func (client blobClient) Exists(ctx context.Context) (bool, error) {
 	}
 	return true, nil
 }
+
+func (client blobClient) AcquireLease(
+	ctx context.Context, duration time.Duration,
+) (string, error) {
+	if duration < MinLeaseDuration {
+		duration = MinLeaseDuration
+	} else if duration > MaxLeaseDuration {
+		duration = MaxLeaseDuration
+	}
+
+	lease, err := client.blob.NewBlobLeaseClient(nil)
+	if err != nil {
+		return "", fmt.Errorf("creating lease client: %v", err)
+	}
+
+	options := &azblob.BlobAcquireLeaseOptions{
+		Duration: to.Ptr(int32(duration / time.Second)),
+	}
+	resp, err := lease.AcquireLease(ctx, options)
+	if hasErrorCode(err, azblob.StorageErrorCodeBlobNotFound) {
+		// an existing blob would be leased, so uploading can't clobber it
+		_, err := client.blob.UploadBuffer(ctx, nil, azblob.UploadOption{})
+		if hasErrorCode(err, azblob.StorageErrorCodeLeaseIDMissing) {
+			return "", ErrLeased
+		} else if err != nil {
+			return "", fmt.Errorf("creating blob: %v", err)
+		}
+		resp, err = lease.AcquireLease(ctx, options)
+	}
+	if hasErrorCode(err, azblob.StorageErrorCodeLeaseAlreadyPresent) {
+		return "", ErrLeased
+	}
+	if err != nil {
+		return "", fmt.Errorf("acquiring lease: %v", err)
+	}
+	if resp.LeaseID == nil {
+		return "", errors.New("acquiring lease: no lease id returned")
+	}
+	return *resp.LeaseID, nil
+}
+
+func (client blobClient) RenewLease(ctx context.Context, leaseId string) error {
+	lease, err := client.blob.NewBlobLeaseClient(&leaseId)
+	if err != nil {
+		return fmt.Errorf("creating lease client: %v", err)
+	}
+	if _, err := lease.RenewLease(ctx, nil); err != nil {
+		return fmt.Errorf("renewing lease: %v", err)
+	}
+	return nil
+}
+
+func (client blobClient) ReleaseLease(ctx context.Context, leaseId string) error {
+	lease, err := client.blob.NewBlobLeaseClient(&leaseId)
+	if err != nil {
+		return fmt.Errorf("creating lease client: %v", err)
+	}
+	if _, err := lease.ReleaseLease(ctx, nil); err != nil {
+		return fmt.Errorf("releasing lease: %v", err)
+	}
+	return nil
+}
+
+func hasErrorCode(err error, code azblob.StorageErrorCode) bool {
+	var storageErr *azblob.StorageError
+	return errors.As(err, &storageErr) && storageErr.ErrorCode == code
+}
@@ -0,0 +1,134 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+)
+
+type DnsClient interface {
+	Zones(ctx context.Context) ([]string, error)
+
+	GetTxtRecord(ctx context.Context, zone string, name string) ([]string, error)
+	SetTxtRecord(
+		ctx context.Context, zone string, name string, values []string,
+		ttl int64,
+	) error
+	DeleteTxtRecord(ctx context.Context, zone string, name string) error
+}
+
+//go:generate mockery --name DnsClient --filename dnsclient_mock.go --with-expecter
+
+type dnsClient struct {
+	resourceGroup string
+	zones         *armdns.ZonesClient
+	records       *armdns.RecordSetsClient
+}
+
+func NewDnsClient(subscriptionId string, resourceGroup string) (DnsClient, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating credential: %v", err)
+	}
+
+	zones, err := armdns.NewZonesClient(subscriptionId, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating zones client: %v", err)
+	}
+	records, err := armdns.NewRecordSetsClient(subscriptionId, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating record sets client: %v", err)
+	}
+	return dnsClient{resourceGroup, zones, records}, nil
+}
+
+// Zones returns the names of the DNS zones in the resource group.
+func (client dnsClient) Zones(ctx context.Context) ([]string, error) {
+	var names []string
+	pager := client.zones.NewListByResourceGroupPager(client.resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing zones: %v", err)
+		}
+		for _, zone := range page.Value {
+			if zone.Name != nil {
+				names = append(names, *zone.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (client dnsClient) GetTxtRecord(
+	ctx context.Context, zone string, name string,
+) ([]string, error) {
+	resp, err := client.records.Get(
+		ctx, client.resourceGroup, zone, name, armdns.RecordTypeTXT, nil,
+	)
+	if err != nil {
+		var httpErr *azcore.ResponseError
+		if errors.As(err, &httpErr) {
+			if httpErr.StatusCode == http.StatusNotFound {
+				return nil, nil // return nil as not found
+			}
+		}
+		return nil, fmt.Errorf("getting record: %v", err)
+	}
+
+	var values []string
+	if resp.Properties == nil {
+		return values, nil
+	}
+	for _, record := range resp.Properties.TxtRecords {
+		for _, value := range record.Value {
+			if value != nil {
+				values = append(values, *value)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (client dnsClient) SetTxtRecord(
+	ctx context.Context, zone string, name string, values []string, ttl int64,
+) error {
+	var records []*armdns.TxtRecord
+	for i := range values {
+		records = append(
+			records, &armdns.TxtRecord{Value: []*string{&values[i]}},
+		)
+	}
+
+	recordSet := armdns.RecordSet{
+		Properties: &armdns.RecordSetProperties{
+			TTL:        &ttl,
+			TxtRecords: records,
+		},
+	}
+	_, err := client.records.CreateOrUpdate(
+		ctx, client.resourceGroup, zone, name, armdns.RecordTypeTXT, recordSet,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("setting record: %v", err)
+	}
+	return nil
+}
+
+func (client dnsClient) DeleteTxtRecord(
+	ctx context.Context, zone string, name string,
+) error {
+	_, err := client.records.Delete(
+		ctx, client.resourceGroup, zone, name, armdns.RecordTypeTXT, nil,
+	)
+	if err != nil {
+		return fmt.Errorf("deleting record: %v", err)
+	}
+	return nil
+}
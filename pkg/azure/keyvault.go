@@ -26,20 +26,36 @@ type KeyVaultClient interface {
 	) (jwk.Key, error)
 	ImportKey(ctx context.Context, keyName string, key jwk.Key) error
 
+	// GetSecret returns nil if the secret doesn't exist.
 	GetSecret(
 		ctx context.Context, secretName string, version string,
-	) (*string, error)
-	SetSecret(ctx context.Context, secretName string, value string) error
+	) (*Secret, error)
+	// SetSecret adds a version of the secret, returning the version.
+	SetSecret(
+		ctx context.Context, secretName string, value string,
+	) (string, error)
 
+	// GetCertificate returns nil if there's no such certificate.
 	GetCertificate(
 		ctx context.Context, certificateName string, version string,
 	) (*x509.Certificate, error)
+	// ImportCertificate imports chain, the leaf followed by its
+	// intermediates, with the leaf's key.
 	ImportCertificate(
 		ctx context.Context, certificateName string,
-		certificate *x509.Certificate, key *rsa.PrivateKey,
+		chain []*x509.Certificate, key *rsa.PrivateKey,
 	) error
 }
 
+// Secret is the value of a secret at a version. A certificate's secret, named
+// after the certificate, has the chain and key with a ContentType of
+// application/x-pem-file or application/x-pkcs12.
+type Secret struct {
+	Value       string
+	Version     string
+	ContentType string
+}
+
 //go:generate mockery --name KeyVaultClient --filename keyvaultclient_mock.go --with-expecter
 
 type keyVaultClient struct {
@@ -116,7 +132,7 @@ func (client keyVaultClient) ImportKey(
 
 func (client keyVaultClient) GetSecret(
 	ctx context.Context, secretName string, version string,
-) (*string, error) {
+) (*Secret, error) {
 	resp, err := client.secrets.GetSecret(ctx, secretName, version, nil)
 	if err != nil {
 		var httpErr *azcore.ResponseError
@@ -127,20 +143,34 @@ func (client keyVaultClient) GetSecret(
 		}
 		return nil, fmt.Errorf("getting secret: %v", err) // return err
 	}
-	return resp.Value, nil
+	if resp.Value == nil {
+		return nil, errors.New("getting secret: no value")
+	}
+
+	secret := &Secret{Value: *resp.Value}
+	if resp.ID != nil {
+		secret.Version = resp.ID.Version()
+	}
+	if resp.ContentType != nil {
+		secret.ContentType = *resp.ContentType
+	}
+	return secret, nil
 }
 
 func (client keyVaultClient) SetSecret(
 	ctx context.Context, secretName string, value string,
-) error {
+) (string, error) {
 	setSecretParams := azsecrets.SetSecretParameters{
 		Value: &value,
 	}
-	_, err := client.secrets.SetSecret(ctx, secretName, setSecretParams, nil)
+	resp, err := client.secrets.SetSecret(ctx, secretName, setSecretParams, nil)
 	if err != nil {
-		return fmt.Errorf("setting secret: %v", err)
+		return "", fmt.Errorf("setting secret: %v", err)
 	}
-	return nil
+	if resp.ID == nil {
+		return "", nil
+	}
+	return resp.ID.Version(), nil
 }
 
 func (client keyVaultClient) GetCertificate(
@@ -150,6 +180,12 @@ func (client keyVaultClient) GetCertificate(
 		ctx, certificateName, version, nil,
 	)
 	if err != nil {
+		var httpErr *azcore.ResponseError
+		if errors.As(err, &httpErr) {
+			if httpErr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+		}
 		return nil, fmt.Errorf("getting certificate: %v", err)
 	}
 
@@ -163,9 +199,9 @@ func (client keyVaultClient) GetCertificate(
 
 func (client keyVaultClient) ImportCertificate(
 	ctx context.Context, certificateName string,
-	certificate *x509.Certificate, key *rsa.PrivateKey,
+	chain []*x509.Certificate, key *rsa.PrivateKey,
 ) error {
-	encodedCertAndKey, err := encodeCertAndKeyToBase64(certificate, key)
+	encodedCertAndKey, err := encodeCertAndKeyToBase64(chain, key)
 	if err != nil {
 		return fmt.Errorf("encoding certificate and key: %v", err)
 	}
@@ -184,7 +220,7 @@ func (client keyVaultClient) ImportCertificate(
 }
 
 func encodeCertAndKeyToBase64(
-	cert *x509.Certificate,
+	chain []*x509.Certificate,
 	key *rsa.PrivateKey,
 ) (string, error) {
 	marshaledKey, err := x509.MarshalPKCS8PrivateKey(key)
@@ -197,11 +233,13 @@ func encodeCertAndKeyToBase64(
 	}
 	pemBytes := pem.EncodeToMemory(&keyBlock)
 
-	certBlock := pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: cert.Raw,
+	for _, cert := range chain {
+		certBlock := pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		}
+		pemBytes = append(pemBytes, pem.EncodeToMemory(&certBlock)...)
 	}
-	pemBytes = append(pemBytes, pem.EncodeToMemory(&certBlock)...)
 
 	return base64.StdEncoding.EncodeToString(pemBytes), nil
 }
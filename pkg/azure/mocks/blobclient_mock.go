@@ -6,6 +6,8 @@ import (
 	context "context"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // BlobClient is an autogenerated mock type for the BlobClient type
@@ -21,8 +23,53 @@ func (_m *BlobClient) EXPECT() *BlobClient_Expecter {
 	return &BlobClient_Expecter{mock: &_m.Mock}
 }
 
+// AcquireLease provides a mock function with given fields: ctx, duration
+func (_m *BlobClient) AcquireLease(ctx context.Context, duration time.Duration) (string, error) {
+	ret := _m.Called(ctx, duration)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) string); ok {
+		r0 = rf(ctx, duration)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, duration)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BlobClient_AcquireLease_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'AcquireLease'
+type BlobClient_AcquireLease_Call struct {
+	*mock.Call
+}
+
+// AcquireLease is a helper method to define mock.On call
+//  - ctx context.Context
+//  - duration time.Duration
+func (_e *BlobClient_Expecter) AcquireLease(ctx interface{}, duration interface{}) *BlobClient_AcquireLease_Call {
+	return &BlobClient_AcquireLease_Call{Call: _e.mock.On("AcquireLease", ctx, duration)}
+}
+
+func (_c *BlobClient_AcquireLease_Call) Run(run func(ctx context.Context, duration time.Duration)) *BlobClient_AcquireLease_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(time.Duration))
+	})
+	return _c
+}
+
+func (_c *BlobClient_AcquireLease_Call) Return(_a0 string, _a1 error) *BlobClient_AcquireLease_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 // Download provides a mock function with given fields: ctx
-func (_m *BlobClient) Download(ctx context.Context) ([]byte, error) {
+func (_m *BlobClient) Download(ctx context.Context) ([]byte, string, error) {
 	ret := _m.Called(ctx)
 
 	var r0 []byte
@@ -34,14 +81,21 @@ func (_m *BlobClient) Download(ctx context.Context) ([]byte, error) {
 		}
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context) string); ok {
 		r1 = rf(ctx)
 	} else {
-		r1 = ret.Error(1)
+		r1 = ret.Get(1).(string)
 	}
 
-	return r0, r1
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context) error); ok {
+		r2 = rf(ctx)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
 }
 
 // BlobClient_Download_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Download'
@@ -62,8 +116,8 @@ func (_c *BlobClient_Download_Call) Run(run func(ctx context.Context)) *BlobClie
 	return _c
 }
 
-func (_c *BlobClient_Download_Call) Return(_a0 []byte, _a1 error) *BlobClient_Download_Call {
-	_c.Call.Return(_a0, _a1)
+func (_c *BlobClient_Download_Call) Return(_a0 []byte, _a1 string, _a2 error) *BlobClient_Download_Call {
+	_c.Call.Return(_a0, _a1, _a2)
 	return _c
 }
 
@@ -111,13 +165,13 @@ func (_c *BlobClient_Exists_Call) Return(_a0 bool, _a1 error) *BlobClient_Exists
 	return _c
 }
 
-// Upload provides a mock function with given fields: ctx, buffer
-func (_m *BlobClient) Upload(ctx context.Context, buffer []byte) error {
-	ret := _m.Called(ctx, buffer)
+// ReleaseLease provides a mock function with given fields: ctx, leaseId
+func (_m *BlobClient) ReleaseLease(ctx context.Context, leaseId string) error {
+	ret := _m.Called(ctx, leaseId)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, []byte) error); ok {
-		r0 = rf(ctx, buffer)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, leaseId)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -125,6 +179,89 @@ func (_m *BlobClient) Upload(ctx context.Context, buffer []byte) error {
 	return r0
 }
 
+// BlobClient_ReleaseLease_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReleaseLease'
+type BlobClient_ReleaseLease_Call struct {
+	*mock.Call
+}
+
+// ReleaseLease is a helper method to define mock.On call
+//  - ctx context.Context
+//  - leaseId string
+func (_e *BlobClient_Expecter) ReleaseLease(ctx interface{}, leaseId interface{}) *BlobClient_ReleaseLease_Call {
+	return &BlobClient_ReleaseLease_Call{Call: _e.mock.On("ReleaseLease", ctx, leaseId)}
+}
+
+func (_c *BlobClient_ReleaseLease_Call) Run(run func(ctx context.Context, leaseId string)) *BlobClient_ReleaseLease_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *BlobClient_ReleaseLease_Call) Return(_a0 error) *BlobClient_ReleaseLease_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+// RenewLease provides a mock function with given fields: ctx, leaseId
+func (_m *BlobClient) RenewLease(ctx context.Context, leaseId string) error {
+	ret := _m.Called(ctx, leaseId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, leaseId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// BlobClient_RenewLease_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RenewLease'
+type BlobClient_RenewLease_Call struct {
+	*mock.Call
+}
+
+// RenewLease is a helper method to define mock.On call
+//  - ctx context.Context
+//  - leaseId string
+func (_e *BlobClient_Expecter) RenewLease(ctx interface{}, leaseId interface{}) *BlobClient_RenewLease_Call {
+	return &BlobClient_RenewLease_Call{Call: _e.mock.On("RenewLease", ctx, leaseId)}
+}
+
+func (_c *BlobClient_RenewLease_Call) Run(run func(ctx context.Context, leaseId string)) *BlobClient_RenewLease_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *BlobClient_RenewLease_Call) Return(_a0 error) *BlobClient_RenewLease_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+// Upload provides a mock function with given fields: ctx, buffer, etag
+func (_m *BlobClient) Upload(ctx context.Context, buffer []byte, etag string) (string, error) {
+	ret := _m.Called(ctx, buffer, etag)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, []byte, string) string); ok {
+		r0 = rf(ctx, buffer, etag)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []byte, string) error); ok {
+		r1 = rf(ctx, buffer, etag)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BlobClient_Upload_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Upload'
 type BlobClient_Upload_Call struct {
 	*mock.Call
@@ -133,19 +270,20 @@ type BlobClient_Upload_Call struct {
 // Upload is a helper method to define mock.On call
 //  - ctx context.Context
 //  - buffer []byte
-func (_e *BlobClient_Expecter) Upload(ctx interface{}, buffer interface{}) *BlobClient_Upload_Call {
-	return &BlobClient_Upload_Call{Call: _e.mock.On("Upload", ctx, buffer)}
+//  - etag string
+func (_e *BlobClient_Expecter) Upload(ctx interface{}, buffer interface{}, etag interface{}) *BlobClient_Upload_Call {
+	return &BlobClient_Upload_Call{Call: _e.mock.On("Upload", ctx, buffer, etag)}
 }
 
-func (_c *BlobClient_Upload_Call) Run(run func(ctx context.Context, buffer []byte)) *BlobClient_Upload_Call {
+func (_c *BlobClient_Upload_Call) Run(run func(ctx context.Context, buffer []byte, etag string)) *BlobClient_Upload_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].([]byte))
+		run(args[0].(context.Context), args[1].([]byte), args[2].(string))
 	})
 	return _c
 }
 
-func (_c *BlobClient_Upload_Call) Return(_a0 error) *BlobClient_Upload_Call {
-	_c.Call.Return(_a0)
+func (_c *BlobClient_Upload_Call) Return(_a0 string, _a1 error) *BlobClient_Upload_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
@@ -3,6 +3,8 @@
 package mocks
 
 import (
+	azure "github.com/figglewatts/certforgot/pkg/azure"
+
 	context "context"
 
 	jwk "github.com/lestrrat-go/jwx/jwk"
@@ -123,15 +125,15 @@ func (_c *KeyVaultClient_GetKey_Call) Return(_a0 jwk.Key, _a1 error) *KeyVaultCl
 }
 
 // GetSecret provides a mock function with given fields: ctx, secretName, version
-func (_m *KeyVaultClient) GetSecret(ctx context.Context, secretName string, version string) (*string, error) {
+func (_m *KeyVaultClient) GetSecret(ctx context.Context, secretName string, version string) (*azure.Secret, error) {
 	ret := _m.Called(ctx, secretName, version)
 
-	var r0 *string
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) *string); ok {
+	var r0 *azure.Secret
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *azure.Secret); ok {
 		r0 = rf(ctx, secretName, version)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*string)
+			r0 = ret.Get(0).(*azure.Secret)
 		}
 	}
 
@@ -165,18 +167,18 @@ func (_c *KeyVaultClient_GetSecret_Call) Run(run func(ctx context.Context, secre
 	return _c
 }
 
-func (_c *KeyVaultClient_GetSecret_Call) Return(_a0 *string, _a1 error) *KeyVaultClient_GetSecret_Call {
+func (_c *KeyVaultClient_GetSecret_Call) Return(_a0 *azure.Secret, _a1 error) *KeyVaultClient_GetSecret_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-// ImportCertificate provides a mock function with given fields: ctx, certificateName, certificate, key
-func (_m *KeyVaultClient) ImportCertificate(ctx context.Context, certificateName string, certificate *x509.Certificate, key *rsa.PrivateKey) error {
-	ret := _m.Called(ctx, certificateName, certificate, key)
+// ImportCertificate provides a mock function with given fields: ctx, certificateName, chain, key
+func (_m *KeyVaultClient) ImportCertificate(ctx context.Context, certificateName string, chain []*x509.Certificate, key *rsa.PrivateKey) error {
+	ret := _m.Called(ctx, certificateName, chain, key)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, *x509.Certificate, *rsa.PrivateKey) error); ok {
-		r0 = rf(ctx, certificateName, certificate, key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []*x509.Certificate, *rsa.PrivateKey) error); ok {
+		r0 = rf(ctx, certificateName, chain, key)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -192,15 +194,15 @@ type KeyVaultClient_ImportCertificate_Call struct {
 // ImportCertificate is a helper method to define mock.On call
 //  - ctx context.Context
 //  - certificateName string
-//  - certificate *x509.Certificate
+//  - chain []*x509.Certificate
 //  - key *rsa.PrivateKey
-func (_e *KeyVaultClient_Expecter) ImportCertificate(ctx interface{}, certificateName interface{}, certificate interface{}, key interface{}) *KeyVaultClient_ImportCertificate_Call {
-	return &KeyVaultClient_ImportCertificate_Call{Call: _e.mock.On("ImportCertificate", ctx, certificateName, certificate, key)}
+func (_e *KeyVaultClient_Expecter) ImportCertificate(ctx interface{}, certificateName interface{}, chain interface{}, key interface{}) *KeyVaultClient_ImportCertificate_Call {
+	return &KeyVaultClient_ImportCertificate_Call{Call: _e.mock.On("ImportCertificate", ctx, certificateName, chain, key)}
 }
 
-func (_c *KeyVaultClient_ImportCertificate_Call) Run(run func(ctx context.Context, certificateName string, certificate *x509.Certificate, key *rsa.PrivateKey)) *KeyVaultClient_ImportCertificate_Call {
+func (_c *KeyVaultClient_ImportCertificate_Call) Run(run func(ctx context.Context, certificateName string, chain []*x509.Certificate, key *rsa.PrivateKey)) *KeyVaultClient_ImportCertificate_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(string), args[2].(*x509.Certificate), args[3].(*rsa.PrivateKey))
+		run(args[0].(context.Context), args[1].(string), args[2].([]*x509.Certificate), args[3].(*rsa.PrivateKey))
 	})
 	return _c
 }
@@ -250,17 +252,24 @@ func (_c *KeyVaultClient_ImportKey_Call) Return(_a0 error) *KeyVaultClient_Impor
 }
 
 // SetSecret provides a mock function with given fields: ctx, secretName, value
-func (_m *KeyVaultClient) SetSecret(ctx context.Context, secretName string, value string) error {
+func (_m *KeyVaultClient) SetSecret(ctx context.Context, secretName string, value string) (string, error) {
 	ret := _m.Called(ctx, secretName, value)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
 		r0 = rf(ctx, secretName, value)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(string)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, secretName, value)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // KeyVaultClient_SetSecret_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SetSecret'
@@ -283,8 +292,8 @@ func (_c *KeyVaultClient_SetSecret_Call) Run(run func(ctx context.Context, secre
 	return _c
 }
 
-func (_c *KeyVaultClient_SetSecret_Call) Return(_a0 error) *KeyVaultClient_SetSecret_Call {
-	_c.Call.Return(_a0)
+func (_c *KeyVaultClient_SetSecret_Call) Return(_a0 string, _a1 error) *KeyVaultClient_SetSecret_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
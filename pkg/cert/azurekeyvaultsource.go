@@ -1,12 +1,20 @@
 package cert
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 
 	"github.com/figglewatts/certforgot/pkg/azure"
 )
 
+const (
+	pemContentType    = "application/x-pem-file"
+	pkcs12ContentType = "application/x-pkcs12"
+)
+
 type AzureKeyVaultSource struct {
 	client   azure.KeyVaultClient
 	certName string
@@ -18,8 +26,68 @@ func NewAzureKeyVaultSource(
 	return AzureKeyVaultSource{client, certificateName}, nil
 }
 
+// Get reads the intermediates and private key from the certificate's secret,
+// which needs permission to get secrets. Only the leaf is returned if there's
+// no such secret.
 func (source AzureKeyVaultSource) Get(ctx context.Context) (
-	*x509.Certificate, error,
+	Certificate, error,
 ) {
-	return source.client.GetCertificate(ctx, source.certName, "")
+	leaf, err := source.client.GetCertificate(ctx, source.certName, "")
+	if err != nil {
+		return Certificate{}, err
+	}
+	if leaf == nil {
+		return Certificate{}, fmt.Errorf(
+			"no certificate '%s': %w", source.certName, ErrNotFound,
+		)
+	}
+
+	secret, err := source.client.GetSecret(ctx, source.certName, "")
+	if err != nil {
+		return Certificate{}, err
+	}
+	if secret == nil {
+		return Certificate{Leaf: leaf}, nil
+	}
+
+	var cert Certificate
+	switch secret.ContentType {
+	case pemContentType:
+		cert, err = parsePem([]byte(secret.Value))
+	case pkcs12ContentType:
+		cert, err = parsePkcs12(secret.Value)
+	default:
+		return Certificate{Leaf: leaf}, nil
+	}
+	if err != nil {
+		return Certificate{}, fmt.Errorf("parsing certificate secret: %v", err)
+	}
+
+	// the secret has the whole chain, so the leaf is dropped from it
+	cert.Intermediates = withoutCertificate(
+		append([]*x509.Certificate{cert.Leaf}, cert.Intermediates...), leaf,
+	)
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// parsePkcs12 reads a secret's base64 pfx, which key vault doesn't encrypt.
+func parsePkcs12(value string) (Certificate, error) {
+	pfxData, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("decoding pkcs12: %v", err)
+	}
+	return parsePfx(pfxData, "")
+}
+
+func withoutCertificate(
+	certs []*x509.Certificate, cert *x509.Certificate,
+) []*x509.Certificate {
+	var result []*x509.Certificate
+	for _, c := range certs {
+		if !bytes.Equal(c.Raw, cert.Raw) {
+			result = append(result, c)
+		}
+	}
+	return result
 }
@@ -1,59 +1,95 @@
 package cert
 
 import (
+	"bytes"
 	"context"
-	"crypto/x509"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"reflect"
 	"testing"
 
+	"github.com/figglewatts/certforgot/pkg/azure"
 	"github.com/figglewatts/certforgot/pkg/azure/mocks"
 	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 func TestAzureKeyVaultSource_Get(t *testing.T) {
-	type fields struct {
-		certName string
-	}
-	type args struct {
-		ctx context.Context
-	}
-	cert := x509.Certificate{}
+	ca, _, cert, certKey := caCert(t)
+	chain, err := parsePem(bytes.Join([][]byte{cert.Bytes(), ca.Bytes()}, nil))
+	assert.NoError(t, err)
+	withKey, err := parsePem(
+		bytes.Join([][]byte{certKey.Bytes(), cert.Bytes(), ca.Bytes()}, nil),
+	)
+	assert.NoError(t, err)
+	pfxData, err := pkcs12.Encode(
+		rand.Reader, withKey.PrivateKey, withKey.Leaf, withKey.Intermediates, "",
+	)
+	assert.NoError(t, err)
+
 	tests := []struct {
 		name    string
-		fields  fields
-		args    args
-		want    *x509.Certificate
+		secret  *azure.Secret
+		want    Certificate
 		wantErr bool
 	}{
-		{"get", fields{"test"}, args{context.Background()}, &cert, false},
+		{"no secret", nil, Certificate{Leaf: chain.Leaf}, false},
+		{
+			"pem secret", &azure.Secret{
+				Value:       certKey.String() + cert.String() + ca.String(),
+				ContentType: pemContentType,
+			}, withKey, false,
+		},
+		{
+			"pkcs12 secret", &azure.Secret{
+				Value:       base64.StdEncoding.EncodeToString(pfxData),
+				ContentType: pkcs12ContentType,
+			}, withKey, false,
+		},
+		{
+			"other secret", &azure.Secret{Value: "value"},
+			Certificate{Leaf: chain.Leaf}, false,
+		},
+		{
+			"bad pkcs12 secret", &azure.Secret{
+				Value: "bad", ContentType: pkcs12ContentType,
+			}, Certificate{}, true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
+				ctx := context.Background()
 				client := mocks.NewKeyVaultClient(t)
-				source := AzureKeyVaultSource{
-					client:   client,
-					certName: tt.fields.certName,
-				}
+				source := AzureKeyVaultSource{client: client, certName: "test"}
 
 				client.EXPECT().
-					GetCertificate(tt.args.ctx, tt.fields.certName, "").
-					Return(&cert, nil)
+					GetCertificate(ctx, "test", "").
+					Return(chain.Leaf, nil)
+				client.EXPECT().GetSecret(ctx, "test", "").Return(tt.secret, nil)
 
-				got, err := source.Get(tt.args.ctx)
-				if (err != nil) != tt.wantErr {
-					t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+				got, err := source.Get(ctx)
+				if tt.wantErr {
+					assert.Error(t, err)
 					return
 				}
-				if !reflect.DeepEqual(got, tt.want) {
-					t.Errorf("Get() got = %v, want %v", got, tt.want)
-				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
 			},
 		)
 	}
 }
 
+func TestAzureKeyVaultSource_Get_NotFound(t *testing.T) {
+	ctx := context.Background()
+	client := mocks.NewKeyVaultClient(t)
+	source := AzureKeyVaultSource{client: client, certName: "test"}
+	client.EXPECT().GetCertificate(ctx, "test", "").Return(nil, nil)
+
+	_, err := source.Get(ctx)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
 func TestNewAzureKeyVaultSource(t *testing.T) {
 	type args struct {
 		certificateName string
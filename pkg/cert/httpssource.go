@@ -2,7 +2,9 @@ package cert
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -27,28 +29,65 @@ func NewHttpsSource(url *url.URL, client *http.Client) (HttpsSource, error) {
 	return HttpsSource{url, client}, nil
 }
 
-func (source HttpsSource) Get(ctx context.Context) (*x509.Certificate, error) {
-	return source.getCertFromUrl(ctx, source.url)
-}
+// Get returns the chain the server presents, without the private key. The
+// chain is returned even if it fails verification, such as when it's expired.
+func (source HttpsSource) Get(ctx context.Context) (Certificate, error) {
+	client, roots, err := source.inspectingClient()
+	if err != nil {
+		return Certificate{}, err
+	}
+	defer client.CloseIdleConnections()
 
-func (source HttpsSource) getCertFromUrl(ctx context.Context, url *url.URL) (
-	cert *x509.Certificate, err error,
-) {
-	req, err := http.NewRequestWithContext(ctx, "HEAD", url.String(), nil)
+	req, err := http.NewRequestWithContext(
+		ctx, "HEAD", source.url.String(), nil,
+	)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to create request for '%s': %v", url, err,
+		return Certificate{}, fmt.Errorf(
+			"unable to create request for '%s': %v", source.url, err,
 		)
 	}
 
-	resp, err := source.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("unable to perform HEAD for '%s': %v", url, err)
+		return Certificate{}, fmt.Errorf(
+			"unable to perform HEAD for '%s': %v", source.url, err,
+		)
 	}
+	resp.Body.Close()
 
 	if resp.TLS == nil {
-		return nil, fmt.Errorf("resource '%s' was not encrypted", url)
+		return Certificate{}, fmt.Errorf(
+			"resource '%s' was not encrypted", source.url,
+		)
+	}
+
+	// after redirects the chain is the last server's
+	return presentedCertificate(
+		resp.TLS.PeerCertificates, roots, resp.Request.URL.Hostname(),
+	), nil
+}
+
+// inspectingClient copies the source's client with verification turned off,
+// returning the roots it would have verified against.
+func (source HttpsSource) inspectingClient() (
+	*http.Client, *x509.CertPool, error,
+) {
+	transport, ok := source.client.Transport.(*http.Transport)
+	if source.client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return nil, nil, errors.New("client doesn't use an http.Transport")
+	}
+
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
 	}
+	roots := transport.TLSClientConfig.RootCAs
+	transport.TLSClientConfig.InsecureSkipVerify = true
 
-	return resp.TLS.PeerCertificates[0], nil
+	client := *source.client
+	client.Transport = transport
+	return &client, roots, nil
 }
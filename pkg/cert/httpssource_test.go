@@ -2,28 +2,30 @@ package cert
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func localHttps(t *testing.T) (
-	*x509.Certificate, *httptest.Server, http.Client,
-) {
+// localHttps serves a certificate signed by a test CA, presenting the CA as
+// an intermediate.
+func localHttps(t *testing.T) (Certificate, *httptest.Server, http.Client) {
 	caCert, _, cert, key := caCert(t)
 
-	block, _ := pem.Decode(cert.Bytes())
-	parsedCert, err := x509.ParseCertificate(block.Bytes)
+	chain, err := parsePem(append(cert.Bytes(), caCert.Bytes()...))
 	assert.NoError(t, err)
 
-	serverCert, err := tls.X509KeyPair(cert.Bytes(), key.Bytes())
+	serverCert, err := tls.X509KeyPair(
+		append(cert.Bytes(), caCert.Bytes()...), key.Bytes(),
+	)
 	assert.NoError(t, err)
 
 	tlsConf := &tls.Config{
@@ -52,7 +54,7 @@ func localHttps(t *testing.T) (
 		Transport: transport,
 	}
 
-	return parsedCert, server, client
+	return chain, server, client
 }
 
 func localHttp(t *testing.T) *httptest.Server {
@@ -82,6 +84,63 @@ func TestHttpsSource_Get(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equalf(t, cert, got, "Get(%v)", ctx)
+	assert.Len(t, got.Intermediates, 1)
+	assert.Nil(t, got.PrivateKey)
+}
+
+func TestHttpsSource_Get_Untrusted(t *testing.T) {
+	cert, server, _ := localHttps(t)
+	parsedUrl, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	source, err := NewHttpsSource(parsedUrl, &http.Client{})
+	assert.NoError(t, err)
+	got, err := source.Get(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, cert.Leaf, got.Leaf)
+	var authorityErr x509.UnknownAuthorityError
+	assert.ErrorAs(t, got.VerifyErr, &authorityErr)
+}
+
+func TestHttpsSource_Get_Expired(t *testing.T) {
+	template, key := certAndKey(t)
+	template.NotBefore = time.Now().Add(-48 * time.Hour)
+	template.NotAfter = time.Now().Add(-24 * time.Hour)
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	assert.NoError(t, err)
+	expired, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{
+			{Certificate: [][]byte{der}, PrivateKey: key},
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(expired)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	parsedUrl, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	source, err := NewHttpsSource(parsedUrl, client)
+	assert.NoError(t, err)
+
+	got, err := source.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expired, got.Leaf)
+	var invalidErr x509.CertificateInvalidError
+	assert.ErrorAs(t, got.VerifyErr, &invalidErr)
+	assert.Equal(t, x509.Expired, invalidErr.Reason)
 }
 
 func TestHttpsSource_Get_Unencrypted(t *testing.T) {
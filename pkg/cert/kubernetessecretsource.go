@@ -0,0 +1,62 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// KubernetesSecretSource reads the certificate in a kubernetes.io/tls Secret.
+type KubernetesSecretSource struct {
+	secrets typedcorev1.SecretInterface
+	name    string
+}
+
+func NewKubernetesSecretSource(
+	secrets typedcorev1.SecretInterface, name string,
+) (KubernetesSecretSource, error) {
+	if name == "" {
+		return KubernetesSecretSource{}, errors.New("secret name is empty")
+	}
+	return KubernetesSecretSource{secrets, name}, nil
+}
+
+// Get returns the chain in tls.crt, with the key in tls.key if there is one.
+func (source KubernetesSecretSource) Get(ctx context.Context) (
+	Certificate, error,
+) {
+	secret, err := source.secrets.Get(ctx, source.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Certificate{}, fmt.Errorf(
+			"no secret '%s': %w", source.name, ErrNotFound,
+		)
+	} else if err != nil {
+		return Certificate{}, fmt.Errorf(
+			"getting secret '%s': %v", source.name, err,
+		)
+	}
+
+	chain, ok := secret.Data[corev1.TLSCertKey]
+	if !ok {
+		return Certificate{}, fmt.Errorf(
+			"secret '%s' has no %s", source.name, corev1.TLSCertKey,
+		)
+	}
+	cert, err := parsePem(
+		bytes.Join(
+			[][]byte{chain, secret.Data[corev1.TLSPrivateKeyKey]}, []byte("\n"),
+		),
+	)
+	if err != nil {
+		return Certificate{}, fmt.Errorf(
+			"parsing secret '%s': %v", source.name, err,
+		)
+	}
+	return cert, nil
+}
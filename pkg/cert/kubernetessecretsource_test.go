@@ -0,0 +1,93 @@
+package cert
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesSecretSource_Get(t *testing.T) {
+	const namespace = "default"
+	caCert, _, cert, key := caCert(t)
+	chainPem := append(cert.Bytes(), caCert.Bytes()...)
+	chain, err := parsePem(append(chainPem, key.Bytes()...))
+	assert.NoError(t, err)
+	withoutKey := chain
+	withoutKey.PrivateKey = nil
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		want    Certificate
+		wantErr bool
+	}{
+		{
+			"chain and key", map[string][]byte{
+				corev1.TLSCertKey:       chainPem,
+				corev1.TLSPrivateKeyKey: key.Bytes(),
+			}, chain, false,
+		},
+		{
+			"no key", map[string][]byte{corev1.TLSCertKey: chainPem},
+			withoutKey, false,
+		},
+		{
+			"no certificate", map[string][]byte{
+				corev1.TLSPrivateKeyKey: key.Bytes(),
+			}, Certificate{}, true,
+		},
+		{
+			"bad certificate", map[string][]byte{
+				corev1.TLSCertKey: []byte("not a certificate"),
+			}, Certificate{}, true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				clientset := fake.NewSimpleClientset(
+					&corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "tls", Namespace: namespace,
+						},
+						Type: corev1.SecretTypeTLS,
+						Data: tt.data,
+					},
+				)
+				source, err := NewKubernetesSecretSource(
+					clientset.CoreV1().Secrets(namespace), "tls",
+				)
+				assert.NoError(t, err)
+
+				got, err := source.Get(context.Background())
+				if tt.wantErr {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestKubernetesSecretSource_Get_NotFound(t *testing.T) {
+	source, err := NewKubernetesSecretSource(
+		fake.NewSimpleClientset().CoreV1().Secrets("default"), "tls",
+	)
+	assert.NoError(t, err)
+	_, err = source.Get(context.Background())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNewKubernetesSecretSource(t *testing.T) {
+	assert.Implements(t, (*Source)(nil), new(KubernetesSecretSource))
+	_, err := NewKubernetesSecretSource(
+		fake.NewSimpleClientset().CoreV1().Secrets("default"), "",
+	)
+	assert.Error(t, err)
+}
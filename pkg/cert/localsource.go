@@ -3,7 +3,7 @@ package cert
 import (
 	"context"
 	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 )
@@ -11,63 +11,62 @@ import (
 type LocalSource struct {
 	filePath   string
 	sourceType FileType
+	password   string
 }
 
 //go:generate go run github.com/abice/go-enum -f=$GOFILE --marshal --nocase
 
-// ENUM(pem, der)
+// ENUM(pem, der, pfx)
 type FileType int
 
-func NewLocalSource(filePath string, sourceType FileType) (LocalSource, error) {
-	return LocalSource{filePath, sourceType}, nil
+// NewLocalSource creates a source reading the certificate from filePath. The
+// password is only used to decrypt pfx files.
+func NewLocalSource(
+	filePath string, sourceType FileType, password string,
+) (LocalSource, error) {
+	return LocalSource{filePath, sourceType, password}, nil
 }
 
-func (source LocalSource) Get(ctx context.Context) (*x509.Certificate, error) {
-	certContents, err := getCertContents(source)
-	if err != nil {
-		return nil, fmt.Errorf(
+// Get reads the certificate from the file. A PEM file's first certificate is
+// the leaf, followed by any intermediates, and its key is read if it has one.
+// A pfx file has the leaf, intermediates and key.
+func (source LocalSource) Get(ctx context.Context) (Certificate, error) {
+	fileContents, err := os.ReadFile(source.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return Certificate{}, fmt.Errorf(
+			"no file at '%s': %w", source.filePath, ErrNotFound,
+		)
+	} else if err != nil {
+		return Certificate{}, fmt.Errorf(
 			"unable to load certificate at '%s': %v", source.filePath, err,
 		)
 	}
 
-	cert, err := x509.ParseCertificate(certContents)
+	cert, err := parseCertificate(
+		fileContents, source.sourceType, source.password,
+	)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return Certificate{}, fmt.Errorf(
 			"unable to parse certificate at '%s': %v", source.filePath, err,
 		)
 	}
-
 	return cert, nil
 }
 
-func getCertContents(source LocalSource) ([]byte, error) {
-	fileContents, err := os.ReadFile(source.filePath)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to read file at '%s': %v", source.filePath, err,
-		)
-	}
-
-	switch source.sourceType {
-	default:
+func parseCertificate(
+	contents []byte, fileType FileType, password string,
+) (Certificate, error) {
+	switch fileType {
 	case FileTypeDer:
-		return fileContents, nil
-	case FileTypePem:
-		decodeBuf := fileContents
-		for {
-			block, rest := pem.Decode(decodeBuf)
-			if block == nil {
-				break
-			}
-			if block.Type == "CERTIFICATE" {
-				return block.Bytes, nil
-			}
-			decodeBuf = rest
+		leaf, err := x509.ParseCertificate(contents)
+		if err != nil {
+			return Certificate{}, err
 		}
-
-		// if we get here then a cert wasn't found
-		return nil, fmt.Errorf("no certificate found in '%s'", source.filePath)
+		return Certificate{Leaf: leaf}, nil
+	case FileTypePem:
+		return parsePem(contents)
+	case FileTypePfx:
+		return parsePfx(contents, password)
 	}
-
-	return nil, fmt.Errorf("unknown type '%v'", source.sourceType)
+	return Certificate{}, fmt.Errorf("unknown type '%v'", fileType)
 }
@@ -16,13 +16,16 @@ const (
 	FileTypePem FileType = iota
 	// FileTypeDer is a FileType of type Der.
 	FileTypeDer
+	// FileTypePfx is a FileType of type Pfx.
+	FileTypePfx
 )
 
-const _FileTypeName = "pemder"
+const _FileTypeName = "pemderpfx"
 
 var _FileTypeMap = map[FileType]string{
 	FileTypePem: _FileTypeName[0:3],
 	FileTypeDer: _FileTypeName[3:6],
+	FileTypePfx: _FileTypeName[6:9],
 }
 
 // String implements the Stringer interface.
@@ -38,6 +41,8 @@ var _FileTypeValue = map[string]FileType{
 	strings.ToLower(_FileTypeName[0:3]): FileTypePem,
 	_FileTypeName[3:6]:                  FileTypeDer,
 	strings.ToLower(_FileTypeName[3:6]): FileTypeDer,
+	_FileTypeName[6:9]:                  FileTypePfx,
+	strings.ToLower(_FileTypeName[6:9]): FileTypePfx,
 }
 
 // ParseFileType attempts to convert a string to a FileType.
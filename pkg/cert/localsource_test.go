@@ -3,15 +3,19 @@ package cert
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 func certAndKeyOnDisk(
@@ -130,7 +134,7 @@ func TestLocalSource_Get(t *testing.T) {
 				if tt.wantErr(t, err, fmt.Sprintf("Get(%v)", tt.args.ctx)) {
 					return
 				}
-				assert.Equalf(t, cert, got, "Get(%v)", tt.args.ctx)
+				assert.Equalf(t, cert, got.Leaf, "Get(%v)", tt.args.ctx)
 			},
 		)
 	}
@@ -152,7 +156,9 @@ func TestNewLocalSource(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := NewLocalSource(tt.args.filePath, tt.args.sourceType)
+				got, err := NewLocalSource(
+					tt.args.filePath, tt.args.sourceType, "",
+				)
 				if !tt.wantErr(
 					t, err, fmt.Sprintf(
 						"NewLocalSource(%v, %v)", tt.args.filePath,
@@ -170,31 +176,124 @@ func TestNewLocalSource(t *testing.T) {
 	}
 }
 
-func Test_getCertContents(t *testing.T) {
-	type args struct {
-		source LocalSource
+func Test_parseCertificate(t *testing.T) {
+	ca, _, cert, certKey := caCert(t)
+	parsedCa, err := parsePem(ca.Bytes())
+	assert.NoError(t, err)
+	parsedCert, err := parsePem(cert.Bytes())
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(certKey.Bytes())
+	rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	assert.NoError(t, err)
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecKeyBytes, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	assert.NoError(t, err)
+
+	join := func(blocks ...[]byte) []byte {
+		return bytes.Join(blocks, nil)
+	}
+	encode := func(blockType string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
 	}
+
 	tests := []struct {
-		name    string
-		args    args
-		want    []byte
-		wantErr assert.ErrorAssertionFunc
+		name     string
+		contents []byte
+		fileType FileType
+		want     Certificate
+		wantErr  assert.ErrorAssertionFunc
 	}{
-		// TODO: Add test cases.
+		{
+			"der", parsedCert.Leaf.Raw, FileTypeDer,
+			Certificate{Leaf: parsedCert.Leaf}, assert.NoError,
+		},
+		{
+			"pem chain", join(cert.Bytes(), certKey.Bytes(), ca.Bytes()),
+			FileTypePem, Certificate{
+				Leaf:          parsedCert.Leaf,
+				Intermediates: []*x509.Certificate{parsedCa.Leaf},
+				PrivateKey:    rsaKey,
+			}, assert.NoError,
+		},
+		{
+			"pem key first", join(certKey.Bytes(), cert.Bytes()), FileTypePem,
+			Certificate{Leaf: parsedCert.Leaf, PrivateKey: rsaKey},
+			assert.NoError,
+		},
+		{
+			"pem pkcs8 key",
+			join(cert.Bytes(), encode("PRIVATE KEY", pkcs8Key)),
+			FileTypePem, Certificate{Leaf: parsedCert.Leaf, PrivateKey: rsaKey},
+			assert.NoError,
+		},
+		{
+			"pem non-rsa key",
+			join(cert.Bytes(), encode("PRIVATE KEY", ecKeyBytes)),
+			FileTypePem, Certificate{Leaf: parsedCert.Leaf}, assert.NoError,
+		},
+		{
+			"pem no certificate", certKey.Bytes(), FileTypePem, Certificate{},
+			assert.Error,
+		},
+		{
+			"pem bad key",
+			join(cert.Bytes(), encode("RSA PRIVATE KEY", []byte("bad"))),
+			FileTypePem, Certificate{}, assert.Error,
+		},
+		{
+			"pem bad intermediate",
+			join(cert.Bytes(), encode("CERTIFICATE", []byte("bad"))),
+			FileTypePem, Certificate{}, assert.Error,
+		},
+		{"unknown type", cert.Bytes(), 1337, Certificate{}, assert.Error},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := getCertContents(tt.args.source)
+				got, err := parseCertificate(tt.contents, tt.fileType, "")
 				if !tt.wantErr(
-					t, err, fmt.Sprintf("getCertContents(%v)", tt.args.source),
+					t, err, fmt.Sprintf("parseCertificate(%v)", tt.fileType),
 				) {
 					return
 				}
 				assert.Equalf(
-					t, tt.want, got, "getCertContents(%v)", tt.args.source,
+					t, tt.want, got, "parseCertificate(%v)", tt.fileType,
 				)
 			},
 		)
 	}
 }
+
+func Test_parseCertificate_Pfx(t *testing.T) {
+	ca, _, cert, certKey := caCert(t)
+	want, err := parsePem(bytes.Join(
+		[][]byte{cert.Bytes(), ca.Bytes(), certKey.Bytes()}, nil,
+	))
+	assert.NoError(t, err)
+	pfxData, err := pkcs12.Encode(
+		rand.Reader, want.PrivateKey, want.Leaf, want.Intermediates, "password",
+	)
+	assert.NoError(t, err)
+
+	got, err := parseCertificate(pfxData, FileTypePfx, "password")
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = parseCertificate(pfxData, FileTypePfx, "wrong")
+	assert.Error(t, err)
+	_, err = parseCertificate(cert.Bytes(), FileTypePfx, "password")
+	assert.Error(t, err)
+}
+
+func TestLocalSource_Get_NotFound(t *testing.T) {
+	source, err := NewLocalSource(
+		path.Join(t.TempDir(), "cert.pem"), FileTypePem, "",
+	)
+	assert.NoError(t, err)
+	_, err = source.Get(context.Background())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -84,6 +84,7 @@ func certAndKey(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
 		IPAddresses: []net.IP{
 			net.IPv4(127, 0, 0, 1), net.IPv6loopback,
 		},
+		DNSNames:  []string{"example.com"},
 		NotBefore: time.Now(),
 		NotAfter:  time.Now().Add(time.Hour * 24 * 90),
 		KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
@@ -2,9 +2,104 @@ package cert
 
 import (
 	"context"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
 )
 
+// ErrNotFound is returned by sources when there's no certificate yet.
+var ErrNotFound = errors.New("certificate not found")
+
 type Source interface {
-	Get(ctx context.Context) (*x509.Certificate, error)
+	Get(ctx context.Context) (Certificate, error)
+}
+
+// Certificate is a certificate as a source has it. PrivateKey is nil if the
+// source doesn't have the key, or it isn't an RSA key.
+type Certificate struct {
+	Leaf          *x509.Certificate
+	Intermediates []*x509.Certificate
+	PrivateKey    *rsa.PrivateKey
+	// VerifyErr is why a chain presented by a server failed verification, for
+	// example because it expired. The chain is still returned, so that it can
+	// be renewed.
+	VerifyErr error
+}
+
+// presentedCertificate verifies a chain presented by serverName as a TLS
+// client would, against roots or the system's if nil.
+func presentedCertificate(
+	chain []*x509.Certificate, roots *x509.CertPool, serverName string,
+) Certificate {
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range chain[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	_, err := chain[0].Verify(
+		x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		},
+	)
+	return Certificate{Leaf: chain[0], Intermediates: chain[1:], VerifyErr: err}
+}
+
+// parsePem reads a certificate from PEM blocks, taking the first certificate
+// as the leaf and the rest as intermediates.
+func parsePem(contents []byte) (Certificate, error) {
+	var cert Certificate
+	for {
+		block, rest := pem.Decode(contents)
+		if block == nil {
+			break
+		}
+		contents = rest
+
+		switch block.Type {
+		case "CERTIFICATE":
+			parsed, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return Certificate{}, fmt.Errorf("parsing certificate: %v", err)
+			}
+			if cert.Leaf == nil {
+				cert.Leaf = parsed
+			} else {
+				cert.Intermediates = append(cert.Intermediates, parsed)
+			}
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return Certificate{}, fmt.Errorf("parsing private key: %v", err)
+			}
+			cert.PrivateKey = key
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return Certificate{}, fmt.Errorf("parsing private key: %v", err)
+			}
+			cert.PrivateKey, _ = key.(*rsa.PrivateKey)
+		}
+	}
+
+	if cert.Leaf == nil {
+		return Certificate{}, errors.New("no certificate found")
+	}
+	return cert, nil
+}
+
+// parsePfx reads a certificate from a PKCS#12 file encrypted with password.
+func parsePfx(contents []byte, password string) (Certificate, error) {
+	key, leaf, caCerts, err := pkcs12.DecodeChain(contents, password)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("decoding pfx: %v", err)
+	}
+	rsaKey, _ := key.(*rsa.PrivateKey)
+	return Certificate{
+		Leaf: leaf, Intermediates: caCerts, PrivateKey: rsaKey,
+	}, nil
 }
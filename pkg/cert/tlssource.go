@@ -0,0 +1,260 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// TlsSource gets the certificate a TLS server presents, negotiating TLS with
+// STARTTLS first for protocols that start in plaintext.
+type TlsSource struct {
+	address    string
+	serverName string
+	startTls   StartTls
+	config     *tls.Config
+}
+
+//go:generate go run github.com/abice/go-enum -f=$GOFILE --marshal --nocase
+
+// ENUM(none, smtp, imap, ldap, postgres)
+type StartTls int
+
+// NewTlsSource creates a source dialing address, a host:port. The server name
+// is sent with SNI and the chain verified against it, and defaults to the
+// host. config, if not nil, is used to verify the chain, which is returned
+// even if it fails verification.
+func NewTlsSource(
+	address string, serverName string, startTls StartTls, config *tls.Config,
+) (TlsSource, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return TlsSource{}, fmt.Errorf("invalid address '%s': %v", address, err)
+	}
+	if serverName == "" {
+		serverName = host
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return TlsSource{address, serverName, startTls, config}, nil
+}
+
+func (source TlsSource) Get(ctx context.Context) (Certificate, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", source.address)
+	if err != nil {
+		return Certificate{}, fmt.Errorf(
+			"unable to connect to '%s': %v", source.address, err,
+		)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := source.negotiate(conn); err != nil {
+		return Certificate{}, fmt.Errorf(
+			"unable to start tls with '%s' over %s: %v", source.address,
+			source.startTls, err,
+		)
+	}
+
+	// the chain is verified after the handshake, so it can be returned when
+	// it's invalid
+	config := source.config.Clone()
+	config.ServerName = source.serverName
+	config.InsecureSkipVerify = true
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return Certificate{}, fmt.Errorf(
+			"unable to perform tls handshake with '%s': %v", source.address,
+			err,
+		)
+	}
+
+	return presentedCertificate(
+		tlsConn.ConnectionState().PeerCertificates, source.config.RootCAs,
+		source.serverName,
+	), nil
+}
+
+// negotiate asks the server to start TLS, leaving conn ready for the
+// handshake. Nothing is read past the server's reply, as it sends nothing
+// more until the handshake starts.
+func (source TlsSource) negotiate(conn net.Conn) error {
+	switch source.startTls {
+	case StartTlsNone:
+		return nil
+	case StartTlsSmtp:
+		return startTlsSmtp(textproto.NewConn(conn))
+	case StartTlsImap:
+		return startTlsImap(textproto.NewConn(conn))
+	case StartTlsLdap:
+		return startTlsLdap(conn)
+	case StartTlsPostgres:
+		return startTlsPostgres(conn)
+	}
+	return fmt.Errorf("unknown protocol '%v'", source.startTls)
+}
+
+func startTlsSmtp(conn *textproto.Conn) error {
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := smtpCommand(conn, 250, "EHLO certforgot"); err != nil {
+		return err
+	}
+	return smtpCommand(conn, 220, "STARTTLS")
+}
+
+func smtpCommand(conn *textproto.Conn, expectCode int, command string) error {
+	if _, err := conn.Cmd(command); err != nil {
+		return err
+	}
+	_, _, err := conn.ReadResponse(expectCode)
+	return err
+}
+
+const imapTag = "a1"
+
+func startTlsImap(conn *textproto.Conn) error {
+	greeting, err := conn.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting '%s'", greeting)
+	}
+
+	if err := conn.PrintfLine("%s STARTTLS", imapTag); err != nil {
+		return err
+	}
+	// untagged responses can come before the tagged one
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, imapTag+" ") {
+			if !strings.HasPrefix(line, imapTag+" OK") {
+				return fmt.Errorf("refused: '%s'", line)
+			}
+			return nil
+		}
+	}
+}
+
+const (
+	// ldapStartTlsOid names the StartTLS extended operation, from RFC 4511.
+	ldapStartTlsOid         = "1.3.6.1.4.1.1466.20037"
+	ldapExtendedResponseTag = 24
+)
+
+// ldapStartTlsRequest is an ExtendedRequest with message ID 1 and no value.
+var ldapStartTlsRequest = append(
+	[]byte{
+		0x30, byte(7 + len(ldapStartTlsOid)), 0x02, 0x01, 0x01,
+		0x77, byte(2 + len(ldapStartTlsOid)), 0x80, byte(len(ldapStartTlsOid)),
+	}, ldapStartTlsOid...,
+)
+
+type ldapMessage struct {
+	MessageId  int
+	ProtocolOp asn1.RawValue
+	Controls   asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+func startTlsLdap(conn net.Conn) error {
+	if _, err := conn.Write(ldapStartTlsRequest); err != nil {
+		return err
+	}
+
+	response, err := readBer(conn)
+	if err != nil {
+		return err
+	}
+	var message ldapMessage
+	if _, err := asn1.Unmarshal(response, &message); err != nil {
+		return fmt.Errorf("parsing response: %v", err)
+	}
+	if message.ProtocolOp.Class != asn1.ClassApplication ||
+		message.ProtocolOp.Tag != ldapExtendedResponseTag {
+		return errors.New("unexpected response")
+	}
+
+	var resultCode asn1.Enumerated
+	_, err = asn1.Unmarshal(message.ProtocolOp.Bytes, &resultCode)
+	if err != nil {
+		return fmt.Errorf("parsing response: %v", err)
+	}
+	if resultCode != 0 {
+		return fmt.Errorf("refused with result code %d", resultCode)
+	}
+	return nil
+}
+
+// maxBerLength bounds the reply read from an LDAP server, which is small.
+const maxBerLength = 64 * 1024
+
+// readBer reads one BER element, without reading past it.
+func readBer(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) == 0 || len(lengthBytes) > 4 {
+			return nil, errors.New("unsupported length")
+		}
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxBerLength {
+		return nil, errors.New("response too long")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return append(header, content...), nil
+}
+
+// postgresSslRequestCode is sent in place of a protocol version to ask for
+// TLS.
+const postgresSslRequestCode = 80877103
+
+func startTlsPostgres(conn net.Conn) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSslRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 'S' {
+		return errors.New("server doesn't support ssl")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version:
+// Revision:
+// Build Date:
+// Built By:
+
+package cert
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// StartTlsNone is a StartTls of type None.
+	StartTlsNone StartTls = iota
+	// StartTlsSmtp is a StartTls of type Smtp.
+	StartTlsSmtp
+	// StartTlsImap is a StartTls of type Imap.
+	StartTlsImap
+	// StartTlsLdap is a StartTls of type Ldap.
+	StartTlsLdap
+	// StartTlsPostgres is a StartTls of type Postgres.
+	StartTlsPostgres
+)
+
+const _StartTlsName = "nonesmtpimapldappostgres"
+
+var _StartTlsMap = map[StartTls]string{
+	StartTlsNone:     _StartTlsName[0:4],
+	StartTlsSmtp:     _StartTlsName[4:8],
+	StartTlsImap:     _StartTlsName[8:12],
+	StartTlsLdap:     _StartTlsName[12:16],
+	StartTlsPostgres: _StartTlsName[16:24],
+}
+
+// String implements the Stringer interface.
+func (x StartTls) String() string {
+	if str, ok := _StartTlsMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("StartTls(%d)", x)
+}
+
+var _StartTlsValue = map[string]StartTls{
+	_StartTlsName[0:4]:                    StartTlsNone,
+	strings.ToLower(_StartTlsName[0:4]):   StartTlsNone,
+	_StartTlsName[4:8]:                    StartTlsSmtp,
+	strings.ToLower(_StartTlsName[4:8]):   StartTlsSmtp,
+	_StartTlsName[8:12]:                   StartTlsImap,
+	strings.ToLower(_StartTlsName[8:12]):  StartTlsImap,
+	_StartTlsName[12:16]:                  StartTlsLdap,
+	strings.ToLower(_StartTlsName[12:16]): StartTlsLdap,
+	_StartTlsName[16:24]:                  StartTlsPostgres,
+	strings.ToLower(_StartTlsName[16:24]): StartTlsPostgres,
+}
+
+// ParseStartTls attempts to convert a string to a StartTls.
+func ParseStartTls(name string) (StartTls, error) {
+	if x, ok := _StartTlsValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _StartTlsValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return StartTls(0), fmt.Errorf("%s is not a valid StartTls", name)
+}
+
+// MarshalText implements the text marshaller method.
+func (x StartTls) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method.
+func (x *StartTls) UnmarshalText(text []byte) error {
+	name := string(text)
+	tmp, err := ParseStartTls(name)
+	if err != nil {
+		return err
+	}
+	*x = tmp
+	return nil
+}
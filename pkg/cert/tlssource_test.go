@@ -0,0 +1,238 @@
+package cert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// negotiator plays the server's side of STARTTLS, returning whether to go on
+// with the handshake.
+type negotiator func(conn net.Conn, r *bufio.Reader) bool
+
+// localTls serves a certificate signed by a test CA on a local port, running
+// negotiate on each connection first. The server names clients ask for are
+// sent on the returned channel.
+func localTls(t *testing.T, negotiate negotiator) (
+	Certificate, string, *tls.Config, chan string,
+) {
+	caCert, _, cert, key := caCert(t)
+	chain, err := parsePem(append(cert.Bytes(), caCert.Bytes()...))
+	assert.NoError(t, err)
+	serverCert, err := tls.X509KeyPair(
+		append(cert.Bytes(), caCert.Bytes()...), key.Bytes(),
+	)
+	assert.NoError(t, err)
+
+	serverNames := make(chan string, 1)
+	serverConf := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (
+			*tls.Certificate, error,
+		) {
+			serverNames <- hello.ServerName
+			return &serverCert, nil
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if negotiate == nil || negotiate(conn, bufio.NewReader(conn)) {
+				tls.Server(conn, serverConf).Handshake()
+			}
+			conn.Close()
+		}
+	}()
+
+	certPool := x509.NewCertPool()
+	certPool.AppendCertsFromPEM(caCert.Bytes())
+	return chain, listener.Addr().String(), &tls.Config{RootCAs: certPool},
+		serverNames
+}
+
+func smtpServer(refuse bool) negotiator {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, "220 mail.example.com ESMTP\r\n")
+		if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "EHLO ") {
+			return false
+		}
+		io.WriteString(conn, "250-mail.example.com\r\n250 STARTTLS\r\n")
+		if line, _ := r.ReadString('\n'); line != "STARTTLS\r\n" {
+			return false
+		}
+		if refuse {
+			io.WriteString(conn, "454 TLS not available\r\n")
+			return false
+		}
+		io.WriteString(conn, "220 ready to start TLS\r\n")
+		return true
+	}
+}
+
+func imapServer(refuse bool) negotiator {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+		if line, _ := r.ReadString('\n'); line != "a1 STARTTLS\r\n" {
+			return false
+		}
+		if refuse {
+			io.WriteString(conn, "a1 NO not now\r\n")
+			return false
+		}
+		io.WriteString(conn, "* CAPABILITY IMAP4rev1\r\na1 OK begin TLS\r\n")
+		return true
+	}
+}
+
+func ldapServer(resultCode byte) negotiator {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		request, err := readBer(r)
+		if err != nil || !bytes.Equal(request, ldapStartTlsRequest) {
+			return false
+		}
+		conn.Write(
+			[]byte{
+				0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01,
+				resultCode, 0x04, 0x00, 0x04, 0x00,
+			},
+		)
+		return resultCode == 0
+	}
+}
+
+func postgresServer(reply byte) negotiator {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(r, request); err != nil {
+			return false
+		}
+		if !bytes.Equal(request, []byte{0, 0, 0, 8, 4, 210, 22, 47}) {
+			return false
+		}
+		conn.Write([]byte{reply})
+		return reply == 'S'
+	}
+}
+
+func TestTlsSource_Get(t *testing.T) {
+	tests := []struct {
+		name           string
+		startTls       StartTls
+		negotiate      negotiator
+		serverName     string
+		wantServerName string
+		wantErr        bool
+	}{
+		{"tls", StartTlsNone, nil, "", "", false},
+		{"sni override", StartTlsNone, nil, "example.com", "example.com", false},
+		{"smtp", StartTlsSmtp, smtpServer(false), "", "", false},
+		{"smtp refused", StartTlsSmtp, smtpServer(true), "", "", true},
+		{"imap", StartTlsImap, imapServer(false), "", "", false},
+		{"imap refused", StartTlsImap, imapServer(true), "", "", true},
+		{"ldap", StartTlsLdap, ldapServer(0), "", "", false},
+		{"ldap refused", StartTlsLdap, ldapServer(2), "", "", true},
+		{"postgres", StartTlsPostgres, postgresServer('S'), "", "", false},
+		{"postgres refused", StartTlsPostgres, postgresServer('N'), "", "", true},
+		{"wrong protocol", StartTlsSmtp, imapServer(false), "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				chain, address, client, serverNames := localTls(t, tt.negotiate)
+				source, err := NewTlsSource(
+					address, tt.serverName, tt.startTls, client,
+				)
+				assert.NoError(t, err)
+
+				ctx, cancel := context.WithTimeout(
+					context.Background(), 5*time.Second,
+				)
+				defer cancel()
+				got, err := source.Get(ctx)
+				if tt.wantErr {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+					assert.Equal(t, chain, got)
+				}
+
+				// clients don't send IP addresses as the server name
+				select {
+				case serverName := <-serverNames:
+					assert.Equal(t, tt.wantServerName, serverName)
+				default:
+				}
+			},
+		)
+	}
+}
+
+func TestTlsSource_Get_Unverified(t *testing.T) {
+	ctx := context.Background()
+	chain, address, client, serverNames := localTls(t, nil)
+
+	source, err := NewTlsSource(address, "other.com", StartTlsNone, client)
+	assert.NoError(t, err)
+	got, err := source.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.Leaf, got.Leaf)
+	var hostnameErr x509.HostnameError
+	assert.ErrorAs(t, got.VerifyErr, &hostnameErr)
+	assert.Equal(t, "other.com", <-serverNames)
+
+	source, err = NewTlsSource(address, "", StartTlsNone, &tls.Config{})
+	assert.NoError(t, err)
+	got, err = source.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.Leaf, got.Leaf)
+	var authorityErr x509.UnknownAuthorityError
+	assert.ErrorAs(t, got.VerifyErr, &authorityErr)
+}
+
+func TestTlsSource_Get_Timeout(t *testing.T) {
+	// a server that never replies to STARTTLS
+	_, address, client, _ := localTls(
+		t, func(conn net.Conn, r *bufio.Reader) bool {
+			io.ReadAll(r)
+			return false
+		},
+	)
+	source, err := NewTlsSource(address, "", StartTlsPostgres, client)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond,
+	)
+	defer cancel()
+	_, err = source.Get(ctx)
+	assert.ErrorContains(t, err, "i/o timeout")
+}
+
+func TestNewTlsSource(t *testing.T) {
+	source, err := NewTlsSource("mail.example.com:25", "", StartTlsSmtp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "mail.example.com", source.serverName)
+
+	source, err = NewTlsSource("10.0.0.1:993", "mail.example.com", 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "mail.example.com", source.serverName)
+
+	_, err = NewTlsSource("mail.example.com", "", StartTlsSmtp, nil)
+	assert.Error(t, err)
+}
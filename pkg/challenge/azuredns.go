@@ -0,0 +1,120 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/figglewatts/certforgot/pkg/azure"
+)
+
+const ProviderAzure = "azure"
+
+const azureDnsTtl = 60
+
+// AzureDnsProvider presents TXT records in Azure DNS. If no zone is given the
+// zone is found from those in the client's resource group.
+type AzureDnsProvider struct {
+	client azure.DnsClient
+	zone   string
+	lock   sync.Mutex
+}
+
+func NewAzureDnsProvider(
+	client azure.DnsClient, zone string,
+) (*AzureDnsProvider, error) {
+	if client == nil {
+		return nil, fmt.Errorf("no azure dns client")
+	}
+	return &AzureDnsProvider{
+		client: client,
+		zone:   strings.TrimSuffix(zone, "."),
+	}, nil
+}
+
+func (provider *AzureDnsProvider) Present(
+	ctx context.Context, fqdn string, value string,
+) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	zone, name, err := provider.split(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	values, err := provider.client.GetTxtRecord(ctx, zone, name)
+	if err != nil {
+		return err
+	}
+	for _, existing := range values {
+		if existing == value {
+			return nil
+		}
+	}
+	return provider.client.SetTxtRecord(
+		ctx, zone, name, append(values, value), azureDnsTtl,
+	)
+}
+
+func (provider *AzureDnsProvider) CleanUp(
+	ctx context.Context, fqdn string, value string,
+) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	zone, name, err := provider.split(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	values, err := provider.client.GetTxtRecord(ctx, zone, name)
+	if err != nil {
+		return err
+	}
+	var remaining []string
+	for _, existing := range values {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
+
+	if len(remaining) == len(values) {
+		return nil
+	}
+	if len(remaining) == 0 {
+		return provider.client.DeleteTxtRecord(ctx, zone, name)
+	}
+	return provider.client.SetTxtRecord(
+		ctx, zone, name, remaining, azureDnsTtl,
+	)
+}
+
+// split returns the zone containing fqdn and the name of fqdn relative to it.
+func (provider *AzureDnsProvider) split(
+	ctx context.Context, fqdn string,
+) (string, string, error) {
+	fqdn = strings.TrimSuffix(fqdn, ".")
+
+	zones := []string{provider.zone}
+	if provider.zone == "" {
+		var err error
+		zones, err = provider.client.Zones(ctx)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	best := ""
+	for _, zone := range zones {
+		zone = strings.TrimSuffix(zone, ".")
+		if strings.HasSuffix(fqdn, "."+zone) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	if best == "" {
+		return "", "", fmt.Errorf("no dns zone found for '%s'", fqdn)
+	}
+	return best, strings.TrimSuffix(fqdn, "."+best), nil
+}
@@ -0,0 +1,134 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/azure/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureDnsProvider_Present(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		zone     string
+		zones    []string
+		fqdn     string
+		existing []string
+		wantZone string
+		wantName string
+		wantSet  []string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			"configured zone", "example.com", nil,
+			"_acme-challenge.example.com", nil,
+			"example.com", "_acme-challenge", []string{"a"}, assert.NoError,
+		},
+		{
+			"longest listed zone", "", []string{"example.com", "sub.example.com"},
+			"_acme-challenge.www.sub.example.com", nil,
+			"sub.example.com", "_acme-challenge.www", []string{"a"},
+			assert.NoError,
+		},
+		{
+			"appends to existing", "example.com", nil,
+			"_acme-challenge.example.com", []string{"b"},
+			"example.com", "_acme-challenge", []string{"b", "a"},
+			assert.NoError,
+		},
+		{
+			"already present", "example.com", nil,
+			"_acme-challenge.example.com", []string{"a"},
+			"example.com", "_acme-challenge", nil, assert.NoError,
+		},
+		{
+			"no zone", "", []string{"example.org"},
+			"_acme-challenge.example.com", nil, "", "", nil, assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := mocks.NewDnsClient(t)
+				provider, err := NewAzureDnsProvider(client, tt.zone)
+				assert.NoError(t, err)
+
+				if tt.zone == "" {
+					client.EXPECT().Zones(ctx).Return(tt.zones, nil)
+				}
+				if tt.wantZone != "" {
+					client.EXPECT().
+						GetTxtRecord(ctx, tt.wantZone, tt.wantName).
+						Return(tt.existing, nil)
+				}
+				if tt.wantSet != nil {
+					client.EXPECT().
+						SetTxtRecord(
+							ctx, tt.wantZone, tt.wantName, tt.wantSet,
+							int64(azureDnsTtl),
+						).
+						Return(nil)
+				}
+
+				tt.wantErr(t, provider.Present(ctx, tt.fqdn, "a"))
+			},
+		)
+	}
+}
+
+func TestAzureDnsProvider_CleanUp(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		existing   []string
+		wantSet    []string
+		wantDelete bool
+	}{
+		{"last value deletes", []string{"a"}, nil, true},
+		{"other values kept", []string{"b", "a"}, []string{"b"}, false},
+		{"not present", []string{"b"}, nil, false},
+		{"no record", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := mocks.NewDnsClient(t)
+				provider, err := NewAzureDnsProvider(client, "example.com.")
+				assert.NoError(t, err)
+
+				client.EXPECT().
+					GetTxtRecord(ctx, "example.com", "_acme-challenge").
+					Return(tt.existing, nil)
+				if tt.wantSet != nil {
+					client.EXPECT().
+						SetTxtRecord(
+							ctx, "example.com", "_acme-challenge", tt.wantSet,
+							int64(azureDnsTtl),
+						).
+						Return(nil)
+				}
+				if tt.wantDelete {
+					client.EXPECT().
+						DeleteTxtRecord(ctx, "example.com", "_acme-challenge").
+						Return(nil)
+				}
+
+				err = provider.CleanUp(ctx, "_acme-challenge.example.com", "a")
+				assert.NoError(t, err)
+			},
+		)
+	}
+}
+
+func TestAzureDnsProvider_ClientError(t *testing.T) {
+	ctx := context.Background()
+	client := mocks.NewDnsClient(t)
+	provider, err := NewAzureDnsProvider(client, "")
+	assert.NoError(t, err)
+
+	client.EXPECT().Zones(ctx).Return(nil, errors.New("boom"))
+	assert.Error(t, provider.Present(ctx, "_acme-challenge.example.com", "a"))
+}
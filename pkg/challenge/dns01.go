@@ -0,0 +1,70 @@
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	TypeDns01 = "dns-01"
+
+	Dns01RecordPrefix = "_acme-challenge."
+)
+
+// Provider manages TXT records in a DNS zone. Several values can be presented
+// for the same name at once, as happens when a wildcard and its base domain
+// are in the same order.
+type Provider interface {
+	Present(ctx context.Context, fqdn string, value string) error
+	CleanUp(ctx context.Context, fqdn string, value string) error
+}
+
+// Dns01Solver fulfils dns-01 challenges by presenting TXT records through a
+// Provider.
+type Dns01Solver struct {
+	provider Provider
+}
+
+func NewDns01Solver(provider Provider) (*Dns01Solver, error) {
+	if provider == nil {
+		return nil, errors.New("no dns provider")
+	}
+	return &Dns01Solver{provider}, nil
+}
+
+func (solver *Dns01Solver) Type() string {
+	return TypeDns01
+}
+
+func (solver *Dns01Solver) Present(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	return solver.provider.Present(
+		ctx, Dns01Record(domain), Dns01Value(keyAuthorization),
+	)
+}
+
+func (solver *Dns01Solver) CleanUp(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	return solver.provider.CleanUp(
+		ctx, Dns01Record(domain), Dns01Value(keyAuthorization),
+	)
+}
+
+// Dns01Record returns the name of the TXT record for domain, without a
+// trailing dot.
+func Dns01Record(domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	return Dns01RecordPrefix + strings.TrimSuffix(domain, ".")
+}
+
+// Dns01Value returns the TXT record value for a key authorization, as in
+// RFC 8555 section 8.4.
+func Dns01Value(keyAuthorization string) string {
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
@@ -0,0 +1,133 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sync"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider keeps TXT records in memory.
+type fakeProvider struct {
+	lock    sync.Mutex
+	records map[string][]string
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{records: map[string][]string{}}
+}
+
+func (provider *fakeProvider) Present(
+	ctx context.Context, fqdn string, value string,
+) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.records[fqdn] = append(provider.records[fqdn], value)
+	return nil
+}
+
+func (provider *fakeProvider) CleanUp(
+	ctx context.Context, fqdn string, value string,
+) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	var remaining []string
+	for _, existing := range provider.records[fqdn] {
+		if existing != value {
+			remaining = append(remaining, existing)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(provider.records, fqdn)
+	} else {
+		provider.records[fqdn] = remaining
+	}
+	return nil
+}
+
+func (provider *fakeProvider) has(fqdn string, value string) bool {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	for _, existing := range provider.records[fqdn] {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDns01Record(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		want   string
+	}{
+		{"plain", "example.com", "_acme-challenge.example.com"},
+		{"wildcard", "*.example.com", "_acme-challenge.example.com"},
+		{"trailing dot", "www.example.com.", "_acme-challenge.www.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, Dns01Record(tt.domain))
+			},
+		)
+	}
+}
+
+func TestDns01Value(t *testing.T) {
+	assert.Equal(
+		t, "61rBZ_4knHblO0MNoxFsXZ_eTFUHum0B6IVRbhvUn5I",
+		Dns01Value("token.thumbprint"),
+	)
+}
+
+func TestNewDns01Solver(t *testing.T) {
+	_, err := NewDns01Solver(nil)
+	assert.Error(t, err)
+
+	solver, err := NewDns01Solver(newFakeProvider())
+	assert.NoError(t, err)
+	assert.Equal(t, TypeDns01, solver.Type())
+}
+
+func TestDns01Solver_Issue(t *testing.T) {
+	ctx := context.Background()
+	server := acmetest.NewServer(t)
+	key, err := acme.NewAccountKey()
+	assert.NoError(t, err)
+	client, err := acme.NewClient(
+		ctx, server.DirectoryUrl(), key, server.Client(),
+	)
+	assert.NoError(t, err)
+	_, err = client.Register(ctx, &mail.Address{Address: "test@example.com"})
+	assert.NoError(t, err)
+
+	provider := newFakeProvider()
+	solver, err := NewDns01Solver(provider)
+	assert.NoError(t, err)
+	server.SetValidate(
+		func(challengeType, domain, token, keyAuthorization string) error {
+			record := Dns01Record(domain)
+			if !provider.has(record, Dns01Value(keyAuthorization)) {
+				return fmt.Errorf("no TXT record at %s", record)
+			}
+			return nil
+		},
+	)
+
+	certificate, err := client.Issue(
+		ctx, []string{"*.example.com", "example.com"}, solver,
+	)
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t, []string{"*.example.com", "example.com"},
+		certificate.Leaf.DNSNames,
+	)
+	assert.Empty(t, provider.records)
+}
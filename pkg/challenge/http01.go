@@ -0,0 +1,187 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeHttp01 = "http-01"
+
+	Http01PathPrefix = "/.well-known/acme-challenge/"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+type http01Token struct {
+	domain           string
+	keyAuthorization string
+	done             chan struct{}
+}
+
+// Http01Solver serves http-01 key authorizations on a port for as long as
+// any challenge is presented. One solver can be shared by concurrent orders,
+// which then share its listener.
+type Http01Solver struct {
+	address string
+
+	// serving is held while starting or stopping the listener, so it's never
+	// started again before it has stopped
+	serving  sync.Mutex
+	lock     sync.Mutex
+	tokens   map[string]http01Token
+	server   *http.Server
+	listener net.Listener
+	stopped  chan struct{}
+}
+
+func NewHttp01Solver(port int) (*Http01Solver, error) {
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	return &Http01Solver{
+		address: fmt.Sprintf(":%d", port),
+		tokens:  map[string]http01Token{},
+	}, nil
+}
+
+func (solver *Http01Solver) Type() string {
+	return TypeHttp01
+}
+
+// Addr returns the address being listened on, or nil if not listening.
+func (solver *Http01Solver) Addr() net.Addr {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	if solver.listener == nil {
+		return nil
+	}
+	return solver.listener.Addr()
+}
+
+func (solver *Http01Solver) Present(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	solver.serving.Lock()
+	defer solver.serving.Unlock()
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+
+	if _, ok := solver.tokens[token]; ok {
+		return fmt.Errorf("token '%s' is already presented", token)
+	}
+
+	if solver.listener == nil {
+		if err := solver.start(); err != nil {
+			return err
+		}
+	}
+
+	presented := http01Token{domain, keyAuthorization, make(chan struct{})}
+	solver.tokens[token] = presented
+
+	// stop serving the token if the order is abandoned
+	go func() {
+		select {
+		case <-ctx.Done():
+			solver.remove(token)
+		case <-presented.done:
+		}
+	}()
+	return nil
+}
+
+func (solver *Http01Solver) CleanUp(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	return solver.remove(token)
+}
+
+func (solver *Http01Solver) remove(token string) error {
+	solver.serving.Lock()
+	defer solver.serving.Unlock()
+	solver.lock.Lock()
+	presented, ok := solver.tokens[token]
+	if !ok {
+		solver.lock.Unlock()
+		return nil
+	}
+	delete(solver.tokens, token)
+	close(presented.done)
+
+	if len(solver.tokens) > 0 {
+		solver.lock.Unlock()
+		return nil
+	}
+
+	// requests being served need the lock, so it's released while stopping
+	server, stopped := solver.server, solver.stopped
+	solver.server, solver.listener, solver.stopped = nil, nil, nil
+	solver.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	<-stopped
+	if err != nil {
+		return fmt.Errorf("shutting down http-01 listener: %v", err)
+	}
+	return nil
+}
+
+// start begins listening, solver.lock must be held.
+func (solver *Http01Solver) start() error {
+	listener, err := net.Listen("tcp", solver.address)
+	if err != nil {
+		return fmt.Errorf("listening on '%s': %v", solver.address, err)
+	}
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(solver.serveHTTP),
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = server.Serve(listener)
+	}()
+
+	solver.listener, solver.server, solver.stopped = listener, server, stopped
+	return nil
+}
+
+func (solver *Http01Solver) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, Http01PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, Http01PathPrefix)
+
+	solver.lock.Lock()
+	presented, ok := solver.tokens[token]
+	solver.lock.Unlock()
+	if !ok || !matchesHost(r.Host, presented.domain) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(presented.keyAuthorization))
+}
+
+func matchesHost(hostHeader, domain string) bool {
+	host, _, err := net.SplitHostPort(hostHeader)
+	if err != nil {
+		host = hostHeader
+	}
+	return strings.EqualFold(strings.TrimSuffix(host, "."), domain)
+}
@@ -0,0 +1,194 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/mail"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getChallenge(t *testing.T, addr net.Addr, host, token string) (int, string) {
+	req, err := http.NewRequest(
+		http.MethodGet, fmt.Sprintf("http://%s%s%s", addr, Http01PathPrefix, token),
+		nil,
+	)
+	assert.NoError(t, err)
+	req.Host = host
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+// freePort finds a port to listen on, for solvers that have to be restarted
+// on the same one.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+type listeningSolver interface {
+	acme.Solver
+	Addr() net.Addr
+}
+
+// presentWhileStopping presents b while the listener left by cleaning up a is
+// stopping, as happens when one order finishes just as another starts.
+func presentWhileStopping(t *testing.T, solver listeningSolver, a, b string) {
+	ctx := context.Background()
+	assert.NoError(t, solver.Present(ctx, a, "tokenA", "tokenA.thumb"))
+
+	cleaned := make(chan error)
+	go func() {
+		cleaned <- solver.CleanUp(ctx, a, "tokenA", "tokenA.thumb")
+	}()
+	for solver.Addr() != nil {
+		runtime.Gosched()
+	}
+	assert.NoError(t, solver.Present(ctx, b, "tokenB", "tokenB.thumb"))
+	assert.NoError(t, <-cleaned)
+	assert.NoError(t, solver.CleanUp(ctx, b, "tokenB", "tokenB.thumb"))
+}
+
+func TestNewHttp01Solver(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    int
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"works", 8080, assert.NoError},
+		{"negative", -1, assert.Error},
+		{"too large", 65536, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := NewHttp01Solver(tt.port)
+				tt.wantErr(t, err)
+			},
+		)
+	}
+}
+
+func TestHttp01Solver(t *testing.T) {
+	solver, err := NewHttp01Solver(0)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	assert.Nil(t, solver.Addr())
+
+	err = solver.Present(ctx, "a.example.com", "tokenA", "tokenA.thumb")
+	assert.NoError(t, err)
+	err = solver.Present(ctx, "b.example.com", "tokenB", "tokenB.thumb")
+	assert.NoError(t, err)
+	addr := solver.Addr()
+	assert.NotNil(t, addr)
+
+	// duplicate tokens are rejected
+	err = solver.Present(ctx, "a.example.com", "tokenA", "tokenA.thumb")
+	assert.Error(t, err)
+
+	status, body := getChallenge(t, addr, "a.example.com", "tokenA")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "tokenA.thumb", body)
+
+	status, _ = getChallenge(t, addr, "b.example.com:80", "tokenA")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = getChallenge(t, addr, "a.example.com", "unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// the listener is shared until the last challenge is cleaned up
+	err = solver.CleanUp(ctx, "a.example.com", "tokenA", "tokenA.thumb")
+	assert.NoError(t, err)
+	status, _ = getChallenge(t, addr, "a.example.com", "tokenA")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, body = getChallenge(t, addr, "b.example.com", "tokenB")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "tokenB.thumb", body)
+
+	err = solver.CleanUp(ctx, "b.example.com", "tokenB", "tokenB.thumb")
+	assert.NoError(t, err)
+	assert.Nil(t, solver.Addr())
+	_, err = net.Dial("tcp", addr.String())
+	assert.Error(t, err)
+}
+
+func TestHttp01Solver_PresentAfterCleanUp(t *testing.T) {
+	solver, err := NewHttp01Solver(freePort(t))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		presentWhileStopping(t, solver, "a.example.com", "b.example.com")
+	}
+}
+
+func TestHttp01Solver_ContextCancelled(t *testing.T) {
+	solver, err := NewHttp01Solver(0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err = solver.Present(ctx, "example.com", "token", "token.thumb")
+	assert.NoError(t, err)
+	assert.NotNil(t, solver.Addr())
+
+	cancel()
+	assert.Eventually(
+		t, func() bool { return solver.Addr() == nil }, time.Second,
+		10*time.Millisecond,
+	)
+
+	// cleaning up after cancellation is harmless
+	err = solver.CleanUp(
+		context.Background(), "example.com", "token", "token.thumb",
+	)
+	assert.NoError(t, err)
+}
+
+func TestHttp01Solver_Issue(t *testing.T) {
+	ctx := context.Background()
+	server := acmetest.NewServer(t)
+	key, err := acme.NewAccountKey()
+	assert.NoError(t, err)
+	client, err := acme.NewClient(
+		ctx, server.DirectoryUrl(), key, server.Client(),
+	)
+	assert.NoError(t, err)
+	_, err = client.Register(ctx, &mail.Address{Address: "test@example.com"})
+	assert.NoError(t, err)
+
+	solver, err := NewHttp01Solver(0)
+	assert.NoError(t, err)
+	server.SetValidate(
+		func(challengeType, domain, token, keyAuthorization string) error {
+			status, body := getChallenge(t, solver.Addr(), domain, token)
+			if status != http.StatusOK || body != keyAuthorization {
+				return fmt.Errorf("got %d '%s'", status, body)
+			}
+			return nil
+		},
+	)
+
+	certificate, err := client.Issue(
+		ctx, []string{"example.com", "www.example.com"}, solver,
+	)
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t, []string{"example.com", "www.example.com"},
+		certificate.Leaf.DNSNames,
+	)
+	assert.Nil(t, solver.Addr())
+}
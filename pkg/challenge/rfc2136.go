@@ -0,0 +1,180 @@
+package challenge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ProviderRfc2136 = "rfc2136"
+
+	DefaultRfc2136Ttl           = 60
+	DefaultRfc2136TsigAlgorithm = "hmac-sha256"
+
+	tsigFudge = 300
+)
+
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+type Rfc2136Config struct {
+	// Nameserver is the host:port to send updates to, port 53 if not given.
+	Nameserver string
+	Zone       string
+	Ttl        uint32
+
+	// TsigKeyName and TsigSecret sign updates if set. TsigSecret is base64.
+	TsigKeyName   string
+	TsigAlgorithm string
+	TsigSecret    string
+}
+
+// Rfc2136Provider presents TXT records with RFC 2136 dynamic updates,
+// optionally signed with TSIG.
+type Rfc2136Provider struct {
+	nameserver    string
+	zone          string
+	ttl           uint32
+	tsigKeyName   string
+	tsigAlgorithm string
+	client        *dns.Client
+}
+
+func NewRfc2136Provider(config *Rfc2136Config) (*Rfc2136Provider, error) {
+	if config.Nameserver == "" {
+		return nil, errors.New("no nameserver")
+	}
+	if config.Zone == "" {
+		return nil, errors.New("no zone")
+	}
+
+	nameserver := config.Nameserver
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	ttl := config.Ttl
+	if ttl == 0 {
+		ttl = DefaultRfc2136Ttl
+	}
+
+	provider := &Rfc2136Provider{
+		nameserver: nameserver,
+		zone:       dns.Fqdn(config.Zone),
+		ttl:        ttl,
+		client:     &dns.Client{},
+	}
+
+	if (config.TsigKeyName == "") != (config.TsigSecret == "") {
+		return nil, errors.New("tsig key name and secret must be set together")
+	}
+	if config.TsigKeyName != "" {
+		algorithmName := config.TsigAlgorithm
+		if algorithmName == "" {
+			algorithmName = DefaultRfc2136TsigAlgorithm
+		}
+		algorithm, ok := tsigAlgorithms[strings.ToLower(
+			strings.TrimSuffix(algorithmName, "."),
+		)]
+		if !ok {
+			return nil, fmt.Errorf(
+				"unknown tsig algorithm '%s'", config.TsigAlgorithm,
+			)
+		}
+		if _, err := base64.StdEncoding.DecodeString(config.TsigSecret); err != nil {
+			return nil, fmt.Errorf("decoding tsig secret: %v", err)
+		}
+
+		provider.tsigKeyName = dns.Fqdn(config.TsigKeyName)
+		provider.tsigAlgorithm = algorithm
+		provider.client.TsigSecret = map[string]string{
+			provider.tsigKeyName: config.TsigSecret,
+		}
+	}
+	return provider, nil
+}
+
+func (provider *Rfc2136Provider) Present(
+	ctx context.Context, fqdn string, value string,
+) error {
+	record, err := provider.record(fqdn, value)
+	if err != nil {
+		return err
+	}
+	update := provider.newUpdate()
+	update.Insert([]dns.RR{record})
+	return provider.send(ctx, update)
+}
+
+func (provider *Rfc2136Provider) CleanUp(
+	ctx context.Context, fqdn string, value string,
+) error {
+	record, err := provider.record(fqdn, value)
+	if err != nil {
+		return err
+	}
+	update := provider.newUpdate()
+	update.Remove([]dns.RR{record})
+	return provider.send(ctx, update)
+}
+
+func (provider *Rfc2136Provider) record(
+	fqdn string, value string,
+) (dns.RR, error) {
+	name := dns.Fqdn(fqdn)
+	if !dns.IsSubDomain(provider.zone, name) {
+		return nil, fmt.Errorf(
+			"'%s' is not in zone '%s'", fqdn, provider.zone,
+		)
+	}
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    provider.ttl,
+		},
+		Txt: []string{value},
+	}, nil
+}
+
+func (provider *Rfc2136Provider) newUpdate() *dns.Msg {
+	update := new(dns.Msg)
+	update.SetUpdate(provider.zone)
+	return update
+}
+
+func (provider *Rfc2136Provider) send(ctx context.Context, update *dns.Msg) error {
+	if provider.tsigKeyName != "" {
+		update.SetTsig(
+			provider.tsigKeyName, provider.tsigAlgorithm, tsigFudge,
+			time.Now().Unix(),
+		)
+	}
+
+	resp, _, err := provider.client.ExchangeContext(
+		ctx, update, provider.nameserver,
+	)
+	if err != nil {
+		return fmt.Errorf("sending update: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf(
+			"update rejected: %s", dns.RcodeToString[resp.Rcode],
+		)
+	}
+	return nil
+}
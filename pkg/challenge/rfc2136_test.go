@@ -0,0 +1,217 @@
+package challenge
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTsigKey    = "certforgot."
+	testTsigSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+// updateServer is an in-process DNS server applying TSIG-signed updates to
+// the TXT records of one zone.
+type updateServer struct {
+	addr string
+
+	lock    sync.Mutex
+	records map[string][]string
+}
+
+func newUpdateServer(t *testing.T, zone string) *updateServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &updateServer{
+		addr:    conn.LocalAddr().String(),
+		records: map[string][]string{},
+	}
+	started := make(chan struct{})
+	dnsServer := &dns.Server{
+		PacketConn:        conn,
+		TsigSecret:        map[string]string{testTsigKey: testTsigSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// the default rejects anything but queries and notifies
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction {
+			return dns.MsgAccept
+		},
+		Handler: dns.HandlerFunc(
+			func(w dns.ResponseWriter, r *dns.Msg) {
+				server.handle(w, r, zone)
+			},
+		),
+	}
+	go func() { _ = dnsServer.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = dnsServer.Shutdown() })
+	return server
+}
+
+func (server *updateServer) handle(w dns.ResponseWriter, r *dns.Msg, zone string) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+
+	tsig := r.IsTsig()
+	switch {
+	case r.Opcode != dns.OpcodeUpdate:
+		resp.Rcode = dns.RcodeNotImplemented
+	case tsig == nil || w.TsigStatus() != nil:
+		resp.Rcode = dns.RcodeNotAuth
+	case len(r.Question) != 1 || r.Question[0].Name != zone:
+		resp.Rcode = dns.RcodeNotZone
+	default:
+		server.apply(r.Ns)
+	}
+
+	if tsig != nil && w.TsigStatus() == nil {
+		resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	_ = w.WriteMsg(resp)
+}
+
+func (server *updateServer) apply(updates []dns.RR) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for _, update := range updates {
+		txt, ok := update.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		name := txt.Hdr.Name
+		switch txt.Hdr.Class {
+		case dns.ClassINET:
+			server.records[name] = append(server.records[name], txt.Txt...)
+		case dns.ClassNONE:
+			var remaining []string
+			for _, value := range server.records[name] {
+				if value != txt.Txt[0] {
+					remaining = append(remaining, value)
+				}
+			}
+			server.records[name] = remaining
+			if len(remaining) == 0 {
+				delete(server.records, name)
+			}
+		}
+	}
+}
+
+func (server *updateServer) get(name string) []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.records[name]
+}
+
+func TestNewRfc2136Provider(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         Rfc2136Config
+		wantNameserver string
+		wantErr        assert.ErrorAssertionFunc
+	}{
+		{
+			"default port", Rfc2136Config{Nameserver: "ns.example.com", Zone: "example.com"},
+			"ns.example.com:53", assert.NoError,
+		},
+		{
+			"tsig", Rfc2136Config{
+				Nameserver: "127.0.0.1:5353", Zone: "example.com",
+				TsigKeyName: "key", TsigSecret: testTsigSecret,
+				TsigAlgorithm: "HMAC-SHA512.",
+			}, "127.0.0.1:5353", assert.NoError,
+		},
+		{"no nameserver", Rfc2136Config{Zone: "example.com"}, "", assert.Error},
+		{"no zone", Rfc2136Config{Nameserver: "ns"}, "", assert.Error},
+		{
+			"key without secret", Rfc2136Config{
+				Nameserver: "ns", Zone: "example.com", TsigKeyName: "key",
+			}, "", assert.Error,
+		},
+		{
+			"bad secret", Rfc2136Config{
+				Nameserver: "ns", Zone: "example.com", TsigKeyName: "key",
+				TsigSecret: "not base64!",
+			}, "", assert.Error,
+		},
+		{
+			"bad algorithm", Rfc2136Config{
+				Nameserver: "ns", Zone: "example.com", TsigKeyName: "key",
+				TsigSecret: testTsigSecret, TsigAlgorithm: "rot13",
+			}, "", assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := NewRfc2136Provider(&tt.config)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(t, tt.wantNameserver, got.nameserver)
+			},
+		)
+	}
+}
+
+func TestRfc2136Provider(t *testing.T) {
+	ctx := context.Background()
+	server := newUpdateServer(t, "example.com.")
+	provider, err := NewRfc2136Provider(
+		&Rfc2136Config{
+			Nameserver:  server.addr,
+			Zone:        "example.com",
+			TsigKeyName: "certforgot",
+			TsigSecret:  testTsigSecret,
+		},
+	)
+	assert.NoError(t, err)
+
+	record := "_acme-challenge.example.com"
+	assert.NoError(t, provider.Present(ctx, record, "a"))
+	assert.NoError(t, provider.Present(ctx, record, "b"))
+	assert.Equal(t, []string{"a", "b"}, server.get(record+"."))
+
+	assert.NoError(t, provider.CleanUp(ctx, record, "a"))
+	assert.Equal(t, []string{"b"}, server.get(record+"."))
+	assert.NoError(t, provider.CleanUp(ctx, record, "b"))
+	assert.Empty(t, server.get(record+"."))
+
+	// records outside the zone are refused before sending
+	assert.Error(t, provider.Present(ctx, "_acme-challenge.example.org", "a"))
+}
+
+func TestRfc2136Provider_Rejected(t *testing.T) {
+	ctx := context.Background()
+	server := newUpdateServer(t, "example.com.")
+
+	tests := []struct {
+		name   string
+		config Rfc2136Config
+	}{
+		{"unsigned", Rfc2136Config{Nameserver: server.addr, Zone: "example.com"}},
+		{
+			"wrong zone", Rfc2136Config{
+				Nameserver: server.addr, Zone: "sub.example.com",
+				TsigKeyName: "certforgot", TsigSecret: testTsigSecret,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				provider, err := NewRfc2136Provider(&tt.config)
+				assert.NoError(t, err)
+				err = provider.Present(ctx, "_acme-challenge.sub.example.com", "a")
+				assert.Error(t, err)
+				assert.Empty(t, server.get("_acme-challenge.sub.example.com."))
+			},
+		)
+	}
+}
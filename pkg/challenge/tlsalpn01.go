@@ -0,0 +1,266 @@
+package challenge
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeTlsAlpn01 = "tls-alpn-01"
+
+	AcmeTlsProtocol = "acme-tls/1"
+
+	handshakeTimeout = 10 * time.Second
+)
+
+// IdPeAcmeIdentifier is the id-pe-acmeIdentifier extension from RFC 8737.
+var IdPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type tlsAlpn01Domain struct {
+	certificate *tls.Certificate
+	done        chan struct{}
+}
+
+// TlsAlpn01Solver answers acme-tls/1 handshakes on a port with a challenge
+// certificate for as long as any challenge is presented. Challenges are told
+// apart by SNI, so only one order at a time can present a given domain.
+type TlsAlpn01Solver struct {
+	address string
+
+	// serving is held while starting or stopping the listener, so it's never
+	// started again before it has stopped
+	serving  sync.Mutex
+	lock     sync.Mutex
+	domains  map[string]tlsAlpn01Domain
+	listener net.Listener
+	stopped  chan struct{}
+}
+
+func NewTlsAlpn01Solver(port int) (*TlsAlpn01Solver, error) {
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	return &TlsAlpn01Solver{
+		address: fmt.Sprintf(":%d", port),
+		domains: map[string]tlsAlpn01Domain{},
+	}, nil
+}
+
+func (solver *TlsAlpn01Solver) Type() string {
+	return TypeTlsAlpn01
+}
+
+// Addr returns the address being listened on, or nil if not listening.
+func (solver *TlsAlpn01Solver) Addr() net.Addr {
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+	if solver.listener == nil {
+		return nil
+	}
+	return solver.listener.Addr()
+}
+
+func (solver *TlsAlpn01Solver) Present(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	certificate, err := TlsAlpn01Certificate(domain, keyAuthorization)
+	if err != nil {
+		return err
+	}
+
+	solver.serving.Lock()
+	defer solver.serving.Unlock()
+	solver.lock.Lock()
+	defer solver.lock.Unlock()
+
+	name := normalizeDomain(domain)
+	if _, ok := solver.domains[name]; ok {
+		return fmt.Errorf("domain '%s' is already presented", domain)
+	}
+
+	if solver.listener == nil {
+		if err := solver.start(); err != nil {
+			return err
+		}
+	}
+
+	presented := tlsAlpn01Domain{certificate, make(chan struct{})}
+	solver.domains[name] = presented
+
+	// stop serving the domain if the order is abandoned
+	go func() {
+		select {
+		case <-ctx.Done():
+			solver.remove(name)
+		case <-presented.done:
+		}
+	}()
+	return nil
+}
+
+func (solver *TlsAlpn01Solver) CleanUp(
+	ctx context.Context, domain, token, keyAuthorization string,
+) error {
+	return solver.remove(normalizeDomain(domain))
+}
+
+func (solver *TlsAlpn01Solver) remove(name string) error {
+	solver.serving.Lock()
+	defer solver.serving.Unlock()
+	solver.lock.Lock()
+	presented, ok := solver.domains[name]
+	if !ok {
+		solver.lock.Unlock()
+		return nil
+	}
+	delete(solver.domains, name)
+	close(presented.done)
+
+	if len(solver.domains) > 0 {
+		solver.lock.Unlock()
+		return nil
+	}
+
+	// handshakes in flight need the lock, so it's released while stopping
+	listener, stopped := solver.listener, solver.stopped
+	solver.listener, solver.stopped = nil, nil
+	solver.lock.Unlock()
+
+	err := listener.Close()
+	<-stopped
+	if err != nil {
+		return fmt.Errorf("shutting down tls-alpn-01 listener: %v", err)
+	}
+	return nil
+}
+
+// start begins listening, solver.lock must be held.
+func (solver *TlsAlpn01Solver) start() error {
+	listener, err := net.Listen("tcp", solver.address)
+	if err != nil {
+		return fmt.Errorf("listening on '%s': %v", solver.address, err)
+	}
+
+	config := &tls.Config{
+		NextProtos:     []string{AcmeTlsProtocol},
+		GetCertificate: solver.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var handshakes sync.WaitGroup
+		defer handshakes.Wait()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handshakes.Add(1)
+			go func() {
+				defer handshakes.Done()
+				handshake(conn, config)
+			}()
+		}
+	}()
+
+	solver.listener, solver.stopped = listener, stopped
+	return nil
+}
+
+// handshake completes the TLS handshake and hangs up, as the validation
+// server only needs to see the certificate.
+func handshake(conn net.Conn, config *tls.Config) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	_ = tls.Server(conn, config).Handshake()
+}
+
+func (solver *TlsAlpn01Solver) getCertificate(
+	hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	if !offersAcmeTls(hello.SupportedProtos) {
+		return nil, fmt.Errorf("client did not offer %s", AcmeTlsProtocol)
+	}
+
+	solver.lock.Lock()
+	presented, ok := solver.domains[normalizeDomain(hello.ServerName)]
+	solver.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no challenge for '%s'", hello.ServerName)
+	}
+	return presented.certificate, nil
+}
+
+func offersAcmeTls(protos []string) bool {
+	for _, proto := range protos {
+		if proto == AcmeTlsProtocol {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// TlsAlpn01Certificate creates the self-signed challenge certificate for
+// domain, as in RFC 8737 section 3.
+func TlsAlpn01Certificate(
+	domain string, keyAuthorization string,
+) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	extensionValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("encoding acme identifier: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{
+			{Id: IdPeAcmeIdentifier, Critical: true, Value: extensionValue},
+		},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate: %v", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package challenge
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// validateTlsAlpn01 performs the validation server's side of RFC 8737.
+func validateTlsAlpn01(
+	addr net.Addr, domain string, protos []string, keyAuthorization string,
+) error {
+	conn, err := tls.Dial(
+		"tcp", addr.String(), &tls.Config{
+			ServerName:         domain,
+			NextProtos:         protos,
+			InsecureSkipVerify: true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != AcmeTlsProtocol {
+		return fmt.Errorf("negotiated '%s'", state.NegotiatedProtocol)
+	}
+	leaf := state.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+		return fmt.Errorf("certificate is for %v", leaf.DNSNames)
+	}
+
+	want := sha256.Sum256([]byte(keyAuthorization))
+	for _, extension := range leaf.Extensions {
+		if !extension.Id.Equal(IdPeAcmeIdentifier) {
+			continue
+		}
+		if !extension.Critical {
+			return errors.New("acme identifier is not critical")
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(extension.Value, &got); err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want[:]) {
+			return errors.New("acme identifier does not match")
+		}
+		return nil
+	}
+	return errors.New("no acme identifier")
+}
+
+func TestTlsAlpn01Solver(t *testing.T) {
+	solver, err := NewTlsAlpn01Solver(0)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	protos := []string{AcmeTlsProtocol}
+
+	err = solver.Present(ctx, "a.example.com", "tokenA", "tokenA.thumb")
+	assert.NoError(t, err)
+	err = solver.Present(ctx, "b.example.com", "tokenB", "tokenB.thumb")
+	assert.NoError(t, err)
+	addr := solver.Addr()
+	assert.NotNil(t, addr)
+
+	// a domain can only be presented once at a time
+	err = solver.Present(ctx, "A.example.com", "tokenC", "tokenC.thumb")
+	assert.Error(t, err)
+
+	tests := []struct {
+		name    string
+		domain  string
+		protos  []string
+		keyAuth string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"a", "a.example.com", protos, "tokenA.thumb", assert.NoError},
+		{"b", "b.example.com", protos, "tokenB.thumb", assert.NoError},
+		{"wrong key auth", "a.example.com", protos, "tokenB.thumb", assert.Error},
+		{"unknown domain", "c.example.com", protos, "tokenA.thumb", assert.Error},
+		{"no alpn", "a.example.com", nil, "tokenA.thumb", assert.Error},
+		{"other alpn", "a.example.com", []string{"h2"}, "tokenA.thumb", assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := validateTlsAlpn01(addr, tt.domain, tt.protos, tt.keyAuth)
+				tt.wantErr(t, err)
+			},
+		)
+	}
+
+	err = solver.CleanUp(ctx, "a.example.com", "tokenA", "tokenA.thumb")
+	assert.NoError(t, err)
+	err = validateTlsAlpn01(addr, "a.example.com", protos, "tokenA.thumb")
+	assert.Error(t, err)
+	err = validateTlsAlpn01(addr, "b.example.com", protos, "tokenB.thumb")
+	assert.NoError(t, err)
+
+	err = solver.CleanUp(ctx, "b.example.com", "tokenB", "tokenB.thumb")
+	assert.NoError(t, err)
+	assert.Nil(t, solver.Addr())
+	_, err = net.Dial("tcp", addr.String())
+	assert.Error(t, err)
+}
+
+func TestTlsAlpn01Solver_PresentAfterCleanUp(t *testing.T) {
+	solver, err := NewTlsAlpn01Solver(freePort(t))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		presentWhileStopping(t, solver, "a.example.com", "b.example.com")
+	}
+}
+
+func TestTlsAlpn01Solver_ContextCancelled(t *testing.T) {
+	solver, err := NewTlsAlpn01Solver(0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err = solver.Present(ctx, "example.com", "token", "token.thumb")
+	assert.NoError(t, err)
+	assert.NotNil(t, solver.Addr())
+
+	cancel()
+	assert.Eventually(
+		t, func() bool { return solver.Addr() == nil }, time.Second,
+		10*time.Millisecond,
+	)
+}
+
+func TestTlsAlpn01Solver_Issue(t *testing.T) {
+	ctx := context.Background()
+	server := acmetest.NewServer(t)
+	key, err := acme.NewAccountKey()
+	assert.NoError(t, err)
+	client, err := acme.NewClient(
+		ctx, server.DirectoryUrl(), key, server.Client(),
+	)
+	assert.NoError(t, err)
+	_, err = client.Register(ctx, &mail.Address{Address: "test@example.com"})
+	assert.NoError(t, err)
+
+	solver, err := NewTlsAlpn01Solver(0)
+	assert.NoError(t, err)
+	server.SetValidate(
+		func(challengeType, domain, token, keyAuthorization string) error {
+			return validateTlsAlpn01(
+				solver.Addr(), domain, []string{AcmeTlsProtocol},
+				keyAuthorization,
+			)
+		},
+	)
+
+	certificate, err := client.Issue(
+		ctx, []string{"example.com", "www.example.com"}, solver,
+	)
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t, []string{"example.com", "www.example.com"},
+		certificate.Leaf.DNSNames,
+	)
+	assert.Nil(t, solver.Addr())
+}
@@ -0,0 +1,64 @@
+package factory
+
+import (
+	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/figglewatts/certforgot/pkg/installer"
+)
+
+var Installers = NewRegistry[installer.Installer]("installer")
+
+func init() {
+	Installers.Register(
+		TypeAzureKeyVaultCertificate, Factory[installer.Installer]{
+			Validate: validateKeyVaultLocation,
+			New: func(location string, options Options) (installer.Installer, error) {
+				parsed, err := ParseKeyVaultLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				client, err := azure.NewKeyVaultClient(parsed.VaultUrl)
+				if err != nil {
+					return nil, err
+				}
+				return installer.NewAzureKeyVaultInstaller(
+					client, parsed.CertificateName,
+				)
+			},
+		},
+	)
+
+	// file installer locations are directories, written as [pem:|der:|pfx:]dir
+	Installers.Register(
+		TypeFile, Factory[installer.Installer]{
+			Validate: validateFileLocation,
+			New: func(location string, options Options) (installer.Installer, error) {
+				parsed, err := ParseFileLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				return installer.NewLocalInstaller(
+					parsed.Path, parsed.FileType,
+					&installer.LocalInstallerConfig{
+						CertName: installer.DefaultCertName,
+						KeyName:  installer.DefaultKeyName,
+						Password: options.Password,
+					},
+				)
+			},
+		},
+	)
+
+	// the secret is created in the namespace if it doesn't exist
+	Installers.Register(
+		TypeKubernetesSecret, Factory[installer.Installer]{
+			Validate: validateKubernetesSecretLocation,
+			New: func(location string, options Options) (installer.Installer, error) {
+				secrets, name, err := kubernetesSecrets(location)
+				if err != nil {
+					return nil, err
+				}
+				return installer.NewKubernetesSecretInstaller(secrets, name)
+			},
+		},
+	)
+}
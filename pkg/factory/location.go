@@ -0,0 +1,173 @@
+package factory
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type KeyVaultLocation struct {
+	VaultUrl        *url.URL
+	CertificateName string
+}
+
+// ParseKeyVaultLocation splits a certificate url such as
+// https://vault.vault.azure.net/certificates/name into the vault url and name.
+func ParseKeyVaultLocation(location string) (KeyVaultLocation, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return KeyVaultLocation{}, err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return KeyVaultLocation{}, errors.New("expected an https vault url")
+	}
+
+	dir, name := path.Split(strings.TrimSuffix(parsed.Path, "/"))
+	if path.Clean(dir) != "/certificates" || name == "" {
+		return KeyVaultLocation{}, errors.New(
+			"expected a path of the form /certificates/<name>",
+		)
+	}
+
+	parsed.Path = ""
+	return KeyVaultLocation{parsed, name}, nil
+}
+
+type FileLocation struct {
+	Path     string
+	FileType cert.FileType
+}
+
+// ParseFileLocation parses a location of the form [pem:|der:|pfx:|p12:]path.
+// Without a prefix the file type is taken from the extension, defaulting to
+// pem.
+func ParseFileLocation(location string) (FileLocation, error) {
+	if location == "" {
+		return FileLocation{}, errors.New("empty path")
+	}
+
+	if prefix, rest, found := strings.Cut(location, ":"); found {
+		if fileType, ok := parseFileTypePrefix(prefix); ok {
+			if rest == "" {
+				return FileLocation{}, errors.New("empty path")
+			}
+			return FileLocation{rest, fileType}, nil
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".der", ".cer":
+		return FileLocation{location, cert.FileTypeDer}, nil
+	case ".pfx", ".p12":
+		return FileLocation{location, cert.FileTypePfx}, nil
+	}
+	return FileLocation{location, cert.FileTypePem}, nil
+}
+
+// parseFileTypePrefix also accepts p12, pfx's other name.
+func parseFileTypePrefix(prefix string) (cert.FileType, bool) {
+	if strings.EqualFold(prefix, "p12") {
+		return cert.FileTypePfx, true
+	}
+	fileType, err := cert.ParseFileType(prefix)
+	return fileType, err == nil
+}
+
+func ParseHttpsLocation(location string) (*url.URL, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("expected an https url")
+	}
+	return parsed, nil
+}
+
+type TlsLocation struct {
+	Address    string
+	ServerName string
+	StartTls   cert.StartTls
+}
+
+// startTlsPorts are the ports protocols starting TLS with STARTTLS listen on
+// by default.
+var startTlsPorts = map[cert.StartTls]string{
+	cert.StartTlsSmtp:     "25",
+	cert.StartTlsImap:     "143",
+	cert.StartTlsLdap:     "389",
+	cert.StartTlsPostgres: "5432",
+}
+
+// ParseTlsLocation parses a location of the form
+// [tls|smtp|imap|ldap|postgres]://host[:port][?serverName=name], where the
+// scheme is the protocol to use STARTTLS with, or tls to start with TLS.
+// The port is only optional when using STARTTLS.
+func ParseTlsLocation(location string) (TlsLocation, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return TlsLocation{}, err
+	}
+	if parsed.Hostname() == "" {
+		return TlsLocation{}, errors.New("expected a host")
+	}
+
+	startTls := cert.StartTlsNone
+	if parsed.Scheme != "tls" {
+		startTls, err = cert.ParseStartTls(parsed.Scheme)
+		if err != nil || startTls == cert.StartTlsNone {
+			return TlsLocation{}, fmt.Errorf(
+				"unknown scheme '%s', expected tls, smtp, imap, ldap or "+
+					"postgres", parsed.Scheme,
+			)
+		}
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = startTlsPorts[startTls]
+	}
+	if port == "" {
+		return TlsLocation{}, errors.New("expected a port")
+	}
+
+	return TlsLocation{
+		Address:    net.JoinHostPort(parsed.Hostname(), port),
+		ServerName: parsed.Query().Get("serverName"),
+		StartTls:   startTls,
+	}, nil
+}
+
+type KubernetesSecretLocation struct {
+	// Namespace is empty to use the kubeconfig's.
+	Namespace string
+	Name      string
+}
+
+// ParseKubernetesSecretLocation parses a location of the form
+// [namespace/]name.
+func ParseKubernetesSecretLocation(location string) (
+	KubernetesSecretLocation, error,
+) {
+	namespace, name, found := strings.Cut(location, "/")
+	if !found {
+		namespace, name = "", location
+	} else if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return KubernetesSecretLocation{}, fmt.Errorf(
+			"invalid namespace '%s': %s", namespace, strings.Join(errs, ", "),
+		)
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return KubernetesSecretLocation{}, fmt.Errorf(
+			"invalid secret name '%s': %s", name, strings.Join(errs, ", "),
+		)
+	}
+	return KubernetesSecretLocation{namespace, name}, nil
+}
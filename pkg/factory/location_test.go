@@ -0,0 +1,178 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyVaultLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		wantUrl  string
+		wantName string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			"works", "https://kv.vault.azure.net/certificates/cert",
+			"https://kv.vault.azure.net", "cert", assert.NoError,
+		},
+		{
+			"trailing slash", "https://kv.vault.azure.net/certificates/cert/",
+			"https://kv.vault.azure.net", "cert", assert.NoError,
+		},
+		{
+			"no name", "https://kv.vault.azure.net/certificates/", "", "",
+			assert.Error,
+		},
+		{
+			"secret", "https://kv.vault.azure.net/secrets/cert", "", "",
+			assert.Error,
+		},
+		{
+			"not https", "http://kv.vault.azure.net/certificates/cert", "",
+			"", assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseKeyVaultLocation(tt.location)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(t, tt.wantUrl, got.VaultUrl.String())
+				assert.Equal(t, tt.wantName, got.CertificateName)
+			},
+		)
+	}
+}
+
+func TestParseFileLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     FileLocation
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			"pem extension", "/certs/cert.pem",
+			FileLocation{"/certs/cert.pem", cert.FileTypePem}, assert.NoError,
+		},
+		{
+			"der extension", "/certs/cert.DER",
+			FileLocation{"/certs/cert.DER", cert.FileTypeDer}, assert.NoError,
+		},
+		{
+			"pfx extension", "/certs/cert.p12",
+			FileLocation{"/certs/cert.p12", cert.FileTypePfx}, assert.NoError,
+		},
+		{
+			"prefix", "der:/certs/cert",
+			FileLocation{"/certs/cert", cert.FileTypeDer}, assert.NoError,
+		},
+		{
+			"p12 prefix", "P12:/certs/cert",
+			FileLocation{"/certs/cert", cert.FileTypePfx}, assert.NoError,
+		},
+		{
+			"windows path", `C:\certs\cert.pem`,
+			FileLocation{`C:\certs\cert.pem`, cert.FileTypePem},
+			assert.NoError,
+		},
+		{"empty", "", FileLocation{}, assert.Error},
+		{"empty after prefix", "pem:", FileLocation{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseFileLocation(tt.location)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestParseTlsLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     TlsLocation
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			"tls", "tls://example.com:8443",
+			TlsLocation{"example.com:8443", "", cert.StartTlsNone},
+			assert.NoError,
+		},
+		{
+			"default port", "smtp://mail.example.com",
+			TlsLocation{"mail.example.com:25", "", cert.StartTlsSmtp},
+			assert.NoError,
+		},
+		{
+			"server name", "postgres://10.0.0.1:5433?serverName=db.example.com",
+			TlsLocation{"10.0.0.1:5433", "db.example.com", cert.StartTlsPostgres},
+			assert.NoError,
+		},
+		{
+			"ipv6", "ldap://[::1]",
+			TlsLocation{"[::1]:389", "", cert.StartTlsLdap}, assert.NoError,
+		},
+		{"no port", "tls://example.com", TlsLocation{}, assert.Error},
+		{"no host", "imap://:143", TlsLocation{}, assert.Error},
+		{"unknown scheme", "ftp://example.com:21", TlsLocation{}, assert.Error},
+		{"none scheme", "none://example.com:21", TlsLocation{}, assert.Error},
+		{"not a url", "example.com:443", TlsLocation{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseTlsLocation(tt.location)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestParseKubernetesSecretLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     KubernetesSecretLocation
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			"name", "example-tls", KubernetesSecretLocation{"", "example-tls"},
+			assert.NoError,
+		},
+		{
+			"namespace", "web/example-tls",
+			KubernetesSecretLocation{"web", "example-tls"}, assert.NoError,
+		},
+		{"empty", "", KubernetesSecretLocation{}, assert.Error},
+		{"no name", "web/", KubernetesSecretLocation{}, assert.Error},
+		{"no namespace", "/example-tls", KubernetesSecretLocation{}, assert.Error},
+		{"too many parts", "a/b/c", KubernetesSecretLocation{}, assert.Error},
+		{"invalid name", "Example_TLS", KubernetesSecretLocation{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseKubernetesSecretLocation(tt.location)
+				if !tt.wantErr(t, err) || err != nil {
+					return
+				}
+				assert.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
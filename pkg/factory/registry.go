@@ -0,0 +1,86 @@
+package factory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Factory creates a T from a config location string. Validate checks the
+// location without creating anything, so that it can be run at config load.
+type Factory[T any] struct {
+	Validate func(location string) error
+	New      func(location string, options Options) (T, error)
+}
+
+// Options holds the settings that don't belong in a location, as they're
+// secret.
+type Options struct {
+	// Password decrypts and encrypts pfx files.
+	Password string
+}
+
+type Registry[T any] struct {
+	kind      string
+	factories map[string]Factory[T]
+}
+
+func NewRegistry[T any](kind string) *Registry[T] {
+	return &Registry[T]{kind, map[string]Factory[T]{}}
+}
+
+func (registry *Registry[T]) Register(typeName string, factory Factory[T]) {
+	registry.factories[strings.ToLower(typeName)] = factory
+}
+
+func (registry *Registry[T]) Types() []string {
+	types := make([]string, 0, len(registry.factories))
+	for typeName := range registry.factories {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (registry *Registry[T]) Validate(typeName, location string) error {
+	factory, err := registry.factory(typeName)
+	if err != nil {
+		return err
+	}
+	if err := factory.Validate(location); err != nil {
+		return fmt.Errorf(
+			"invalid %s location '%s' for type '%s': %v", registry.kind,
+			location, typeName, err,
+		)
+	}
+	return nil
+}
+
+func (registry *Registry[T]) New(
+	typeName, location string, options Options,
+) (T, error) {
+	factory, err := registry.factory(typeName)
+	if err != nil {
+		var empty T
+		return empty, err
+	}
+
+	created, err := factory.New(location, options)
+	if err != nil {
+		return created, fmt.Errorf(
+			"creating %s of type '%s': %v", registry.kind, typeName, err,
+		)
+	}
+	return created, nil
+}
+
+func (registry *Registry[T]) factory(typeName string) (Factory[T], error) {
+	factory, ok := registry.factories[strings.ToLower(typeName)]
+	if !ok {
+		return factory, fmt.Errorf(
+			"unknown %s type '%s', expected one of: %s", registry.kind,
+			typeName, strings.Join(registry.Types(), ", "),
+		)
+	}
+	return factory, nil
+}
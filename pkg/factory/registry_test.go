@@ -0,0 +1,118 @@
+package factory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/figglewatts/certforgot/pkg/installer"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRegistry() *Registry[string] {
+	registry := NewRegistry[string]("thing")
+	registry.Register(
+		"Upper", Factory[string]{
+			Validate: func(location string) error {
+				if location == "" {
+					return errors.New("empty")
+				}
+				return nil
+			},
+			New: func(location string, options Options) (string, error) {
+				return "created " + location + options.Password, nil
+			},
+		},
+	)
+	return registry
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeName string
+		location string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{"works", "upper", "here", assert.NoError},
+		{"case insensitive", "UPPER", "here", assert.NoError},
+		{"unknown type", "lower", "here", assert.Error},
+		{"bad location", "upper", "", assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tt.wantErr(
+					t, testRegistry().Validate(tt.typeName, tt.location),
+				)
+			},
+		)
+	}
+}
+
+func TestRegistry_New(t *testing.T) {
+	registry := testRegistry()
+
+	got, err := registry.New("upper", "here", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "created here", got)
+
+	got, err = registry.New("upper", "here", Options{Password: " secretly"})
+	assert.NoError(t, err)
+	assert.Equal(t, "created here secretly", got)
+
+	_, err = registry.New("lower", "here", Options{})
+	assert.ErrorContains(t, err, "expected one of: upper")
+}
+
+func TestSources(t *testing.T) {
+	assert.Equal(
+		t, []string{
+			TypeAzureKeyVaultCertificate, TypeFile, TypeHttps,
+			TypeKubernetesSecret, TypeTls,
+		},
+		Sources.Types(),
+	)
+
+	source, err := Sources.New(TypeFile, "der:/tmp/cert.der", Options{})
+	assert.NoError(t, err)
+	want, err := cert.NewLocalSource("/tmp/cert.der", cert.FileTypeDer, "")
+	assert.NoError(t, err)
+	assert.Equal(t, want, source)
+
+	source, err = Sources.New(
+		TypeFile, "/tmp/cert.pfx", Options{Password: "password"},
+	)
+	assert.NoError(t, err)
+	want, err = cert.NewLocalSource(
+		"/tmp/cert.pfx", cert.FileTypePfx, "password",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, want, source)
+
+	_, err = Sources.New(TypeHttps, "http://example.com", Options{})
+	assert.Error(t, err)
+}
+
+func TestInstallers(t *testing.T) {
+	assert.Equal(
+		t, []string{
+			TypeAzureKeyVaultCertificate, TypeFile, TypeKubernetesSecret,
+		},
+		Installers.Types(),
+	)
+
+	got, err := Installers.New(
+		TypeFile, "pfx:/tmp/certs", Options{Password: "password"},
+	)
+	assert.NoError(t, err)
+	want, err := installer.NewLocalInstaller(
+		"/tmp/certs", cert.FileTypePfx, &installer.LocalInstallerConfig{
+			CertName: installer.DefaultCertName,
+			KeyName:  installer.DefaultKeyName,
+			Password: "password",
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,135 @@
+package factory
+
+import (
+	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/figglewatts/certforgot/pkg/kube"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	TypeAzureKeyVaultCertificate = "azurekeyvaultcertificate"
+	TypeFile                     = "file"
+	TypeHttps                    = "https"
+	TypeTls                      = "tls"
+	TypeKubernetesSecret         = "kubernetessecret"
+)
+
+var Sources = NewRegistry[cert.Source]("source")
+
+func init() {
+	Sources.Register(
+		TypeAzureKeyVaultCertificate, Factory[cert.Source]{
+			Validate: validateKeyVaultLocation,
+			New: func(location string, options Options) (cert.Source, error) {
+				parsed, err := ParseKeyVaultLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				client, err := azure.NewKeyVaultClient(parsed.VaultUrl)
+				if err != nil {
+					return nil, err
+				}
+				return cert.NewAzureKeyVaultSource(
+					client, parsed.CertificateName,
+				)
+			},
+		},
+	)
+
+	Sources.Register(
+		TypeFile, Factory[cert.Source]{
+			Validate: validateFileLocation,
+			New: func(location string, options Options) (cert.Source, error) {
+				parsed, err := ParseFileLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				return cert.NewLocalSource(
+					parsed.Path, parsed.FileType, options.Password,
+				)
+			},
+		},
+	)
+
+	Sources.Register(
+		TypeHttps, Factory[cert.Source]{
+			Validate: func(location string) error {
+				_, err := ParseHttpsLocation(location)
+				return err
+			},
+			New: func(location string, options Options) (cert.Source, error) {
+				parsed, err := ParseHttpsLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				return cert.NewHttpsSource(parsed, nil)
+			},
+		},
+	)
+
+	Sources.Register(
+		TypeTls, Factory[cert.Source]{
+			Validate: func(location string) error {
+				_, err := ParseTlsLocation(location)
+				return err
+			},
+			New: func(location string, options Options) (cert.Source, error) {
+				parsed, err := ParseTlsLocation(location)
+				if err != nil {
+					return nil, err
+				}
+				return cert.NewTlsSource(
+					parsed.Address, parsed.ServerName, parsed.StartTls, nil,
+				)
+			},
+		},
+	)
+
+	Sources.Register(
+		TypeKubernetesSecret, Factory[cert.Source]{
+			Validate: validateKubernetesSecretLocation,
+			New: func(location string, options Options) (cert.Source, error) {
+				secrets, name, err := kubernetesSecrets(location)
+				if err != nil {
+					return nil, err
+				}
+				return cert.NewKubernetesSecretSource(secrets, name)
+			},
+		},
+	)
+}
+
+func validateKeyVaultLocation(location string) error {
+	_, err := ParseKeyVaultLocation(location)
+	return err
+}
+
+func validateFileLocation(location string) error {
+	_, err := ParseFileLocation(location)
+	return err
+}
+
+func validateKubernetesSecretLocation(location string) error {
+	_, err := ParseKubernetesSecretLocation(location)
+	return err
+}
+
+// kubernetesSecrets gets the secrets in a location's namespace, using the
+// default kubeconfig, along with the secret's name.
+func kubernetesSecrets(location string) (
+	typedcorev1.SecretInterface, string, error,
+) {
+	parsed, err := ParseKubernetesSecretLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+	clientset, namespace, err := kube.NewClientset("")
+	if err != nil {
+		return nil, "", err
+	}
+	if parsed.Namespace != "" {
+		namespace = parsed.Namespace
+	}
+	return clientset.CoreV1().Secrets(namespace), parsed.Name, nil
+}
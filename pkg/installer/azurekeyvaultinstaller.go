@@ -2,10 +2,10 @@ package installer
 
 import (
 	"context"
-	"crypto/rsa"
 	"crypto/x509"
 
 	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/figglewatts/certforgot/pkg/cert"
 )
 
 type AzureKeyVaultInstaller struct {
@@ -19,10 +19,15 @@ func NewAzureKeyVaultInstaller(
 	return AzureKeyVaultInstaller{client, certificateName}, nil
 }
 
+// Install imports the leaf along with its intermediates, so the vault serves
+// the whole chain.
 func (installer AzureKeyVaultInstaller) Install(
-	ctx context.Context, cert *x509.Certificate, key *rsa.PrivateKey,
+	ctx context.Context, certificate cert.Certificate,
 ) error {
+	chain := append(
+		[]*x509.Certificate{certificate.Leaf}, certificate.Intermediates...,
+	)
 	return installer.client.ImportCertificate(
-		ctx, installer.certName, cert, key,
+		ctx, installer.certName, chain, certificate.PrivateKey,
 	)
 }
@@ -2,12 +2,12 @@ package installer
 
 import (
 	"context"
-	"crypto/rsa"
 	"crypto/x509"
 	"fmt"
 	"testing"
 
 	"github.com/figglewatts/certforgot/pkg/azure/mocks"
+	"github.com/figglewatts/certforgot/pkg/cert"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,24 +16,38 @@ func TestAzureKeyVaultInstaller_Install(t *testing.T) {
 		certName string
 	}
 	type args struct {
-		ctx  context.Context
-		cert *x509.Certificate
-		key  *rsa.PrivateKey
+		ctx         context.Context
+		certificate cert.Certificate
 	}
+	chain := certChain(t)
+	leaf := &x509.Certificate{}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr assert.ErrorAssertionFunc
+		name      string
+		fields    fields
+		args      args
+		wantChain []*x509.Certificate
+		wantErr   assert.ErrorAssertionFunc
 	}{
 		{
 			"new", fields{
 				certName: "test",
 			}, args{
-				ctx:  context.Background(),
-				cert: &x509.Certificate{},
-				key:  privKey(t),
+				ctx: context.Background(),
+				certificate: cert.Certificate{
+					Leaf: leaf, PrivateKey: privKey(t),
+				},
 			},
+			[]*x509.Certificate{leaf},
+			assert.NoError,
+		},
+		{
+			"with intermediates", fields{
+				certName: "test",
+			}, args{
+				ctx:         context.Background(),
+				certificate: chain,
+			},
+			append([]*x509.Certificate{chain.Leaf}, chain.Intermediates...),
 			assert.NoError,
 		},
 	}
@@ -48,17 +62,16 @@ func TestAzureKeyVaultInstaller_Install(t *testing.T) {
 
 				client.EXPECT().
 					ImportCertificate(
-						tt.args.ctx, tt.fields.certName, tt.args.cert,
-						tt.args.key,
+						tt.args.ctx, tt.fields.certName, tt.wantChain,
+						tt.args.certificate.PrivateKey,
 					).
 					Return(nil)
 
 				tt.wantErr(
 					t,
-					installer.Install(tt.args.ctx, tt.args.cert, tt.args.key),
+					installer.Install(tt.args.ctx, tt.args.certificate),
 					fmt.Sprintf(
-						"Install(%v, %v, %v)", tt.args.ctx, tt.args.cert,
-						tt.args.key,
+						"Install(%v, %v)", tt.args.ctx, tt.args.certificate,
 					),
 				)
 
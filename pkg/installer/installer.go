@@ -2,10 +2,11 @@ package installer
 
 import (
 	"context"
-	"crypto/rsa"
-	"crypto/x509"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
 )
 
 type Installer interface {
-	Install(ctx context.Context, cert *x509.Certificate, key *rsa.PrivateKey) error
+	// Install installs the certificate, which must have a private key.
+	Install(ctx context.Context, certificate cert.Certificate) error
 }
@@ -0,0 +1,143 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Annotations set on the secrets certforgot installs to, describing the
+// certificate in them.
+const (
+	AnnotationDomains  = "certforgot/domains"
+	AnnotationSerial   = "certforgot/serial"
+	AnnotationNotAfter = "certforgot/not-after"
+)
+
+// maxSecretWrites bounds the attempts to write a secret that keeps changing
+// while it's written.
+const maxSecretWrites = 5
+
+// KubernetesSecretInstaller writes the certificate to a kubernetes.io/tls
+// Secret, which is created if it doesn't exist.
+type KubernetesSecretInstaller struct {
+	secrets typedcorev1.SecretInterface
+	name    string
+}
+
+func NewKubernetesSecretInstaller(
+	secrets typedcorev1.SecretInterface, name string,
+) (KubernetesSecretInstaller, error) {
+	if name == "" {
+		return KubernetesSecretInstaller{}, errors.New("secret name is empty")
+	}
+	return KubernetesSecretInstaller{secrets, name}, nil
+}
+
+// Install replaces tls.crt and tls.key, leaving the secret's other keys,
+// labels and annotations alone.
+func (installer KubernetesSecretInstaller) Install(
+	ctx context.Context, certificate cert.Certificate,
+) error {
+	if certificate.PrivateKey == nil {
+		return errors.New("certificate has no private key")
+	}
+
+	// the leaf comes first, followed by its intermediates
+	var chain bytes.Buffer
+	certs := append(
+		[]*x509.Certificate{certificate.Leaf}, certificate.Intermediates...,
+	)
+	for _, c := range certs {
+		if err := pem.Encode(
+			&chain, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw},
+		); err != nil {
+			return err
+		}
+	}
+	key := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(certificate.PrivateKey),
+		},
+	)
+	data := map[string][]byte{
+		corev1.TLSCertKey:       chain.Bytes(),
+		corev1.TLSPrivateKeyKey: key,
+	}
+	annotations := map[string]string{
+		AnnotationDomains:  strings.Join(certificate.Leaf.DNSNames, ","),
+		AnnotationSerial:   fmt.Sprintf("%x", certificate.Leaf.SerialNumber),
+		AnnotationNotAfter: certificate.Leaf.NotAfter.Format(time.RFC3339),
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := installer.write(ctx, data, annotations)
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) ||
+			attempt == maxSecretWrites {
+			return err
+		}
+	}
+}
+
+func (installer KubernetesSecretInstaller) write(
+	ctx context.Context, data map[string][]byte,
+	annotations map[string]string,
+) error {
+	secret, err := installer.secrets.Get(
+		ctx, installer.name, metav1.GetOptions{},
+	)
+	if apierrors.IsNotFound(err) {
+		_, err = installer.secrets.Create(
+			ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: installer.name,
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "certforgot",
+					},
+					Annotations: annotations,
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: data,
+			}, metav1.CreateOptions{},
+		)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	// a secret's type can't be changed
+	if secret.Type != corev1.SecretTypeTLS {
+		return fmt.Errorf(
+			"secret '%s' is of type '%s', expected '%s'", installer.name,
+			secret.Type, corev1.SecretTypeTLS,
+		)
+	}
+
+	updated := secret.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string][]byte{}
+	}
+	for k, v := range data {
+		updated.Data[k] = v
+	}
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		updated.Annotations[k] = v
+	}
+	_, err = installer.secrets.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,200 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesSecretInstaller_Install(t *testing.T) {
+	const (
+		namespace  = "default"
+		secretName = "example-tls"
+	)
+	ctx := context.Background()
+	chain := certChain(t)
+	newInstaller := func(t *testing.T, objects ...runtime.Object) (
+		KubernetesSecretInstaller, *fake.Clientset,
+	) {
+		clientset := fake.NewSimpleClientset(objects...)
+		installer, err := NewKubernetesSecretInstaller(
+			clientset.CoreV1().Secrets(namespace), secretName,
+		)
+		assert.Nil(t, err)
+		return installer, clientset
+	}
+	getSecret := func(t *testing.T, clientset *fake.Clientset) *corev1.Secret {
+		secret, err := clientset.CoreV1().
+			Secrets(namespace).
+			Get(ctx, secretName, metav1.GetOptions{})
+		assert.Nil(t, err)
+		return secret
+	}
+	installed := func(t *testing.T, clientset *fake.Clientset) cert.Certificate {
+		source, err := cert.NewKubernetesSecretSource(
+			clientset.CoreV1().Secrets(namespace), secretName,
+		)
+		assert.Nil(t, err)
+		got, err := source.Get(ctx)
+		assert.Nil(t, err)
+		return got
+	}
+	wantAnnotations := map[string]string{
+		AnnotationDomains:  "example.com",
+		AnnotationSerial:   "2",
+		AnnotationNotAfter: chain.Leaf.NotAfter.Format(time.RFC3339),
+	}
+
+	t.Run(
+		"NewKubernetesSecretInstaller", func(t *testing.T) {
+			assert.Implements(t, (*Installer)(nil), new(KubernetesSecretInstaller))
+
+			_, err := NewKubernetesSecretInstaller(
+				fake.NewSimpleClientset().CoreV1().Secrets(namespace), "",
+			)
+			assert.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"new", func(t *testing.T) {
+			installer, clientset := newInstaller(t)
+			assert.Nil(t, installer.Install(ctx, chain))
+
+			secret := getSecret(t, clientset)
+			assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+			assert.Equal(
+				t, "certforgot", secret.Labels["app.kubernetes.io/managed-by"],
+			)
+			assert.Equal(t, wantAnnotations, secret.Annotations)
+			assert.Equal(t, chain, installed(t, clientset))
+		},
+	)
+
+	t.Run(
+		"existing", func(t *testing.T) {
+			installer, clientset := newInstaller(
+				t, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        secretName,
+						Namespace:   namespace,
+						Labels:      map[string]string{"app": "example"},
+						Annotations: map[string]string{"owner": "team"},
+					},
+					Type: corev1.SecretTypeTLS,
+					Data: map[string][]byte{
+						corev1.TLSCertKey:       []byte("old"),
+						corev1.TLSPrivateKeyKey: []byte("old"),
+						"ca.crt":                []byte("kept"),
+					},
+				},
+			)
+			assert.Nil(t, installer.Install(ctx, chain))
+
+			secret := getSecret(t, clientset)
+			assert.Equal(t, map[string]string{"app": "example"}, secret.Labels)
+			assert.Equal(t, "team", secret.Annotations["owner"])
+			for k, v := range wantAnnotations {
+				assert.Equal(t, v, secret.Annotations[k])
+			}
+			assert.Equal(t, []byte("kept"), secret.Data["ca.crt"])
+			assert.Equal(t, chain, installed(t, clientset))
+		},
+	)
+
+	t.Run(
+		"not a tls secret", func(t *testing.T) {
+			installer, _ := newInstaller(
+				t, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: secretName, Namespace: namespace,
+					},
+					Type: corev1.SecretTypeOpaque,
+				},
+			)
+			assert.Error(t, installer.Install(ctx, chain))
+		},
+	)
+
+	t.Run(
+		"no private key", func(t *testing.T) {
+			installer, _ := newInstaller(t)
+			withoutKey := chain
+			withoutKey.PrivateKey = nil
+			assert.Error(t, installer.Install(ctx, withoutKey))
+		},
+	)
+
+	t.Run(
+		"conflict", func(t *testing.T) {
+			tests := []struct {
+				name      string
+				verb      string
+				conflicts int
+				wantErr   bool
+			}{
+				{"created first", "create", 1, false},
+				{"updated while writing", "update", 1, false},
+				{"keeps changing", "update", maxSecretWrites, true},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						var objects []runtime.Object
+						if tt.verb == "update" {
+							objects = append(
+								objects, &corev1.Secret{
+									ObjectMeta: metav1.ObjectMeta{
+										Name: secretName, Namespace: namespace,
+									},
+									Type: corev1.SecretTypeTLS,
+								},
+							)
+						}
+						installer, clientset := newInstaller(t, objects...)
+
+						conflicts := 0
+						clientset.PrependReactor(
+							tt.verb, "secrets",
+							func(k8stesting.Action) (bool, runtime.Object, error) {
+								if conflicts == tt.conflicts {
+									return false, nil, nil
+								}
+								conflicts++
+								resource := schema.GroupResource{Resource: "secrets"}
+								if tt.verb == "create" {
+									return true, nil, apierrors.NewAlreadyExists(
+										resource, secretName,
+									)
+								}
+								return true, nil, apierrors.NewConflict(
+									resource, secretName,
+									fmt.Errorf("modified"),
+								)
+							},
+						)
+
+						err := installer.Install(ctx, chain)
+						if tt.wantErr {
+							assert.Error(t, err)
+							return
+						}
+						assert.Nil(t, err)
+						assert.Equal(t, chain, installed(t, clientset))
+					},
+				)
+			}
+		},
+	)
+}
@@ -2,15 +2,17 @@ package installer
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 
 	"github.com/figglewatts/certforgot/pkg/cert"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 type LocalInstaller struct {
@@ -25,11 +27,15 @@ const (
 
 	FilePermissions = 0755
 	DirPermissions  = 0755
+	// KeyFilePermissions is the mode of the files holding a private key.
+	KeyFilePermissions = 0600
 )
 
 type LocalInstallerConfig struct {
 	CertName string
 	KeyName  string
+	// Password encrypts pfx files, which hold the key as well as the chain.
+	Password string
 }
 
 func NewLocalInstaller(directory string, fileType cert.FileType, config *LocalInstallerConfig) (LocalInstaller, error) {
@@ -43,7 +49,12 @@ func NewLocalInstaller(directory string, fileType cert.FileType, config *LocalIn
 	return LocalInstaller{directory, fileType, config}, nil
 }
 
-func (installer LocalInstaller) Install(ctx context.Context, certificate *x509.Certificate, key *rsa.PrivateKey) (err error) {
+func (installer LocalInstaller) Install(ctx context.Context, certificate cert.Certificate) (err error) {
+	key := certificate.PrivateKey
+	if key == nil {
+		return errors.New("certificate has no private key")
+	}
+
 	err = installer.ensureCertDirExists()
 	if err != nil {
 		return err
@@ -54,15 +65,16 @@ func (installer LocalInstaller) Install(ctx context.Context, certificate *x509.C
 		certPath := path.Join(installer.directory, fmt.Sprintf("%s.der", installer.config.CertName))
 		keyPath := path.Join(installer.directory, fmt.Sprintf("%s.der", installer.config.KeyName))
 
-		err = ioutil.WriteFile(certPath, certificate.Raw, FilePermissions)
+		err = ioutil.WriteFile(certPath, certificate.Leaf.Raw, FilePermissions)
 		if err != nil {
 			return err
 		}
 
-		return ioutil.WriteFile(keyPath, x509.MarshalPKCS1PrivateKey(key), FilePermissions)
+		return writeKeyFile(keyPath, x509.MarshalPKCS1PrivateKey(key))
 	case cert.FileTypePem:
 		certPath := path.Join(installer.directory, fmt.Sprintf("%s.pem", installer.config.CertName))
-		f, err := os.OpenFile(certPath, os.O_WRONLY|os.O_CREATE, 0755)
+		// the key is written along with the chain
+		f, err := openKeyFile(certPath)
 		if err != nil {
 			return err
 		}
@@ -70,25 +82,63 @@ func (installer LocalInstaller) Install(ctx context.Context, certificate *x509.C
 			err = f.Close()
 		}(f)
 
-		certBlock := pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: certificate.Raw,
-		}
-		err = pem.Encode(f, &certBlock)
-		if err != nil {
-			return err
+		// the leaf comes first, followed by its intermediates
+		chain := append([]*x509.Certificate{certificate.Leaf}, certificate.Intermediates...)
+		for _, c := range chain {
+			certBlock := pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: c.Raw,
+			}
+			err = pem.Encode(f, &certBlock)
+			if err != nil {
+				return err
+			}
 		}
 
 		keyBlock := pem.Block{
-			Type:  "PRIVATE KEY",
+			Type:  "RSA PRIVATE KEY",
 			Bytes: x509.MarshalPKCS1PrivateKey(key),
 		}
 		return pem.Encode(f, &keyBlock)
+	case cert.FileTypePfx:
+		certPath := path.Join(installer.directory, fmt.Sprintf("%s.pfx", installer.config.CertName))
+
+		pfxData, err := pkcs12.Encode(rand.Reader, key, certificate.Leaf, certificate.Intermediates, installer.config.Password)
+		if err != nil {
+			return fmt.Errorf("encoding pfx: %v", err)
+		}
+		return writeKeyFile(certPath, pfxData)
 	}
 
 	return fmt.Errorf("unknown type '%v'", installer.fileType)
 }
 
+// openKeyFile truncates or creates a file to hold a private key, restricting
+// an existing file's mode before anything is written to it.
+func openKeyFile(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, KeyFilePermissions)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(KeyFilePermissions); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func writeKeyFile(name string, data []byte) error {
+	f, err := openKeyFile(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func (installer LocalInstaller) ensureCertDirExists() error {
 	if err := os.MkdirAll(installer.directory, DirPermissions); err != nil {
 		return fmt.Errorf("creating cert directory '%s': %v", installer.directory, err)
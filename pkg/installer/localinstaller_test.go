@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/figglewatts/certforgot/pkg/cert"
@@ -40,7 +41,7 @@ func TestLocalInstaller_Install(t *testing.T) {
 		{
 			"der", fields{
 			fileType: cert.FileTypeDer,
-			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName},
+			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName, ""},
 		}, args{
 			ctx:         context.Background(),
 			certificate: &x509.Certificate{},
@@ -50,13 +51,32 @@ func TestLocalInstaller_Install(t *testing.T) {
 		{
 			"pem", fields{
 			fileType: cert.FileTypePem,
-			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName},
+			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName, ""},
 		}, args{
 			ctx:         context.Background(),
 			certificate: &x509.Certificate{},
 			key:         privKey(t),
 		}, false,
 		},
+		{
+			"pfx", fields{
+			fileType: cert.FileTypePfx,
+			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName, "password"},
+		}, args{
+			ctx:         context.Background(),
+			certificate: &x509.Certificate{},
+			key:         privKey(t),
+		}, false,
+		},
+		{
+			"no key", fields{
+			fileType: cert.FileTypePem,
+			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName, ""},
+		}, args{
+			ctx:         context.Background(),
+			certificate: &x509.Certificate{},
+		}, true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
@@ -68,18 +88,26 @@ func TestLocalInstaller_Install(t *testing.T) {
 					config:    tt.fields.config,
 				}
 				if err := installer.Install(
-					tt.args.ctx, tt.args.certificate, tt.args.key,
+					tt.args.ctx, cert.Certificate{
+						Leaf:       tt.args.certificate,
+						PrivateKey: tt.args.key,
+					},
 				); (err != nil) != tt.wantErr {
 					t.Errorf(
 						"Install() error = %v, wantErr %v", err, tt.wantErr,
 					)
 				}
+				if tt.wantErr {
+					return
+				}
 
 				var fileExt string
 				if tt.fields.fileType == cert.FileTypeDer {
 					fileExt = ".der"
 				} else if tt.fields.fileType == cert.FileTypePem {
 					fileExt = ".pem"
+				} else if tt.fields.fileType == cert.FileTypePfx {
+					fileExt = ".pfx"
 				}
 
 				assert.FileExists(
@@ -97,6 +125,99 @@ func TestLocalInstaller_Install(t *testing.T) {
 	}
 }
 
+func TestLocalInstaller_Install_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	want := certChain(t)
+
+	tests := []struct {
+		name     string
+		fileType cert.FileType
+		fileName string
+		password string
+	}{
+		{"pem", cert.FileTypePem, "cert.pem", ""},
+		{"pfx", cert.FileTypePfx, "cert.pfx", "password"},
+		{"pfx without password", cert.FileTypePfx, "cert.pfx", ""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tempDir := setup(t)
+				installer, err := NewLocalInstaller(
+					tempDir, tt.fileType, &LocalInstallerConfig{
+						CertName: DefaultCertName,
+						KeyName:  DefaultKeyName,
+						Password: tt.password,
+					},
+				)
+				assert.NoError(t, err)
+				// installing twice checks the file is replaced, not appended to
+				assert.NoError(t, installer.Install(ctx, want))
+				assert.NoError(t, installer.Install(ctx, want))
+
+				source, err := cert.NewLocalSource(
+					path.Join(tempDir, tt.fileName), tt.fileType, tt.password,
+				)
+				assert.NoError(t, err)
+				got, err := source.Get(ctx)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got)
+
+				if tt.password != "" {
+					source, err = cert.NewLocalSource(
+						path.Join(tempDir, tt.fileName), tt.fileType, "wrong",
+					)
+					assert.NoError(t, err)
+					_, err = source.Get(ctx)
+					assert.Error(t, err)
+				}
+			},
+		)
+	}
+}
+
+func TestLocalInstaller_Install_KeyFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't enforced on windows")
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		fileType cert.FileType
+		keyFile  string
+		certFile string
+	}{
+		{"der", cert.FileTypeDer, "key.der", "cert.der"},
+		{"pem", cert.FileTypePem, "cert.pem", ""},
+		{"pfx", cert.FileTypePfx, "cert.pfx", ""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tempDir := setup(t)
+				// a key file left readable by an earlier install is restricted
+				keyPath := path.Join(tempDir, tt.keyFile)
+				assert.NoError(t, ioutil.WriteFile(keyPath, nil, 0755))
+				assert.NoError(t, os.Chmod(keyPath, 0755))
+
+				installer, err := NewLocalInstaller(tempDir, tt.fileType, nil)
+				assert.NoError(t, err)
+				assert.NoError(t, installer.Install(ctx, certChain(t)))
+
+				info, err := os.Stat(keyPath)
+				assert.NoError(t, err)
+				assert.Equal(t, os.FileMode(KeyFilePermissions), info.Mode().Perm())
+				if tt.certFile != "" {
+					info, err = os.Stat(path.Join(tempDir, tt.certFile))
+					assert.NoError(t, err)
+					assert.NotEqual(t, os.FileMode(KeyFilePermissions), info.Mode().Perm())
+				}
+			},
+		)
+	}
+}
+
 func TestNewLocalInstaller(t *testing.T) {
 	type args struct {
 		fileType cert.FileType
@@ -114,7 +235,7 @@ func TestNewLocalInstaller(t *testing.T) {
 			config:   nil,
 		}, LocalInstaller{
 			fileType: cert.FileTypePem,
-			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName},
+			config:   &LocalInstallerConfig{DefaultCertName, DefaultKeyName, ""},
 		}, false,
 		},
 		{
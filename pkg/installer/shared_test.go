@@ -3,8 +3,13 @@ package installer
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/figglewatts/certforgot/pkg/cert"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +18,49 @@ func privKey(t *testing.T) *rsa.PrivateKey {
 	assert.Nil(t, err)
 	return privateKey
 }
+
+// certChain creates a leaf certificate and key signed by an intermediate.
+func certChain(t *testing.T) cert.Certificate {
+	newCert := func(
+		template *x509.Certificate, parent *x509.Certificate,
+		key *rsa.PrivateKey, parentKey *rsa.PrivateKey,
+	) *x509.Certificate {
+		der, err := x509.CreateCertificate(
+			rand.Reader, template, parent, &key.PublicKey, parentKey,
+		)
+		assert.Nil(t, err)
+		parsed, err := x509.ParseCertificate(der)
+		assert.Nil(t, err)
+		return parsed
+	}
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	ca = newCert(ca, ca, caKey, caKey)
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	leaf := newCert(
+		&x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "example.com"},
+			DNSNames:     []string{"example.com"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}, ca, leafKey, caKey,
+	)
+	return cert.Certificate{
+		Leaf:          leaf,
+		Intermediates: []*x509.Certificate{ca},
+		PrivateKey:    leafKey,
+	}
+}
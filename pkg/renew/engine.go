@@ -0,0 +1,228 @@
+package renew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/figglewatts/certforgot/internal/app"
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/figglewatts/certforgot/pkg/installer"
+	"github.com/figglewatts/certforgot/pkg/state"
+)
+
+type Issuer interface {
+	Issue(
+		ctx context.Context, domains []string, solver acme.Solver,
+	) (acme.Certificate, error)
+}
+
+// LazyIssuer creates its issuer when it's first asked to issue, so nothing,
+// like an ACME account, is set up unless a certificate is renewed.
+type LazyIssuer struct {
+	create func(ctx context.Context) (Issuer, error)
+	once   sync.Once
+	issuer Issuer
+	err    error
+}
+
+func NewLazyIssuer(
+	create func(ctx context.Context) (Issuer, error),
+) (*LazyIssuer, error) {
+	return &LazyIssuer{create: create}, nil
+}
+
+// Issue fails without creating the issuer again if creating it failed.
+func (lazy *LazyIssuer) Issue(
+	ctx context.Context, domains []string, solver acme.Solver,
+) (acme.Certificate, error) {
+	lazy.once.Do(
+		func() {
+			lazy.issuer, lazy.err = lazy.create(ctx)
+		},
+	)
+	if lazy.err != nil {
+		return acme.Certificate{}, lazy.err
+	}
+	return lazy.issuer.Issue(ctx, domains, solver)
+}
+
+// Recorder keeps the history of issuances.
+type Recorder interface {
+	RecordIssuance(ctx context.Context, issuance state.Issuance) error
+}
+
+// Target is a configured certificate along with everything needed to check
+// and renew it.
+type Target struct {
+	Certificate app.Certificate
+	Source      cert.Source
+	Installer   installer.Installer
+	Solver      acme.Solver
+}
+
+type Engine struct {
+	issuer       Issuer
+	recorder     Recorder
+	globalPolicy app.CertificatePolicy
+	now          func() time.Time
+}
+
+// NewEngine creates an engine issuing through issuer and recording each
+// issuance with recorder, if it isn't nil. An engine with a nil issuer can
+// only check certificates.
+func NewEngine(
+	issuer Issuer, recorder Recorder, globalPolicy app.CertificatePolicy,
+) (Engine, error) {
+	return Engine{issuer, recorder, globalPolicy, time.Now}, nil
+}
+
+// Check reports whether each target is due for renewal without renewing.
+func (engine Engine) Check(ctx context.Context, targets []Target) Report {
+	report := Report{}
+	for _, target := range targets {
+		report = append(report, engine.check(ctx, target))
+	}
+	return report
+}
+
+// Renew renews every target that is due, or every target whatever its status
+// if force is set.
+func (engine Engine) Renew(
+	ctx context.Context, targets []Target, force bool,
+) Report {
+	report := Report{}
+	for _, target := range targets {
+		outcome := engine.check(ctx, target)
+		if outcome.Status == StatusDue || force {
+			outcome = engine.renew(ctx, target)
+		}
+		report = append(report, outcome)
+	}
+	return report
+}
+
+func (engine Engine) check(ctx context.Context, target Target) Outcome {
+	outcome := Outcome{Name: target.Certificate.Metadata.Name}
+
+	if target.Source == nil {
+		outcome.Status = StatusFailed
+		outcome.Err = errors.New("no certificate source")
+		return outcome
+	}
+
+	// a certificate that doesn't exist yet is issued for the first time
+	current, err := target.Source.Get(ctx)
+	if errors.Is(err, cert.ErrNotFound) {
+		outcome.Status = StatusDue
+		return outcome
+	} else if err != nil {
+		outcome.Status = StatusFailed
+		outcome.Err = fmt.Errorf("getting current certificate: %v", err)
+		return outcome
+	}
+
+	// a certificate failing verification is still checked by its expiry
+	if current.VerifyErr != nil {
+		outcome.Err = fmt.Errorf(
+			"verifying current certificate: %v", current.VerifyErr,
+		)
+	}
+	outcome.NotAfter = current.Leaf.NotAfter
+	outcome.RenewAt = current.Leaf.NotAfter.Add(-engine.renewBefore(target))
+	if engine.now().Before(outcome.RenewAt) {
+		outcome.Status = StatusValid
+	} else {
+		outcome.Status = StatusDue
+	}
+	return outcome
+}
+
+func (engine Engine) renew(ctx context.Context, target Target) Outcome {
+	outcome := Outcome{Name: target.Certificate.Metadata.Name}
+	fail := func(err error) Outcome {
+		outcome.Status = StatusFailed
+		outcome.Err = err
+		return outcome
+	}
+
+	if engine.issuer == nil {
+		return fail(errors.New("no certificate issuer"))
+	}
+	if target.Solver == nil {
+		return fail(errors.New("no challenge solver"))
+	}
+	if target.Installer == nil {
+		return fail(errors.New("no certificate installer"))
+	}
+
+	issuance := state.Issuance{
+		Name:    target.Certificate.Metadata.Name,
+		Domains: target.Certificate.Metadata.Domains,
+	}
+	failIssuance := func(err error) Outcome {
+		if recordErr := engine.record(ctx, issuance, err); recordErr != nil {
+			err = fmt.Errorf("%v (recording issuance: %v)", err, recordErr)
+		}
+		return fail(err)
+	}
+
+	issued, err := engine.issuer.Issue(
+		ctx, target.Certificate.Metadata.Domains, target.Solver,
+	)
+	if err != nil {
+		return failIssuance(fmt.Errorf("issuing certificate: %v", err))
+	}
+
+	issuance.Serial = fmt.Sprintf("%x", issued.Leaf.SerialNumber)
+	issuance.Domains = issued.Leaf.DNSNames
+	issuance.NotBefore = issued.Leaf.NotBefore
+	issuance.NotAfter = issued.Leaf.NotAfter
+	issuance.Issuer = issued.Leaf.Issuer.String()
+	err = target.Installer.Install(
+		ctx, cert.Certificate{
+			Leaf:          issued.Leaf,
+			Intermediates: issued.Intermediates,
+			PrivateKey:    issued.PrivateKey,
+		},
+	)
+	if err != nil {
+		return failIssuance(fmt.Errorf("installing certificate: %v", err))
+	}
+
+	outcome.Status = StatusRenewed
+	outcome.NotAfter = issued.Leaf.NotAfter
+	outcome.RenewAt = issued.Leaf.NotAfter.Add(-engine.renewBefore(target))
+	// the certificate is installed, so failing to record doesn't fail it
+	if err := engine.record(ctx, issuance, nil); err != nil {
+		outcome.Err = fmt.Errorf("recording issuance: %v", err)
+	}
+	return outcome
+}
+
+// record adds the issuance to the history, as failed if err isn't nil.
+func (engine Engine) record(
+	ctx context.Context, issuance state.Issuance, err error,
+) error {
+	if engine.recorder == nil {
+		return nil
+	}
+
+	issuance.Time = engine.now()
+	issuance.Outcome = state.OutcomeIssued
+	if err != nil {
+		issuance.Outcome = state.OutcomeFailed
+		issuance.Error = err.Error()
+	}
+	return engine.recorder.RecordIssuance(ctx, issuance)
+}
+
+func (engine Engine) renewBefore(target Target) time.Duration {
+	if target.Certificate.Policy != nil {
+		return target.Certificate.Policy.RenewBefore
+	}
+	return engine.globalPolicy.RenewBefore
+}
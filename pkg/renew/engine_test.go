@@ -0,0 +1,427 @@
+package renew
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/internal/app"
+	"github.com/figglewatts/certforgot/pkg/acme"
+	"github.com/figglewatts/certforgot/pkg/acme/acmetest"
+	"github.com/figglewatts/certforgot/pkg/cert"
+	"github.com/figglewatts/certforgot/pkg/state"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+
+type fakeSource struct {
+	cert      *x509.Certificate
+	verifyErr error
+	err       error
+}
+
+func (source fakeSource) Get(ctx context.Context) (cert.Certificate, error) {
+	return cert.Certificate{Leaf: source.cert, VerifyErr: source.verifyErr},
+		source.err
+}
+
+type fakeInstaller struct {
+	installed []*x509.Certificate
+	err       error
+}
+
+func (installer *fakeInstaller) Install(
+	ctx context.Context, certificate cert.Certificate,
+) error {
+	if installer.err != nil {
+		return installer.err
+	}
+	installer.installed = append(installer.installed, certificate.Leaf)
+	return nil
+}
+
+type fakeIssuer struct {
+	issued [][]string
+	err    error
+}
+
+func (issuer *fakeIssuer) Issue(
+	ctx context.Context, domains []string, solver acme.Solver,
+) (acme.Certificate, error) {
+	if issuer.err != nil {
+		return acme.Certificate{}, issuer.err
+	}
+	issuer.issued = append(issuer.issued, domains)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return acme.Certificate{}, err
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1a2b),
+		DNSNames:     domains,
+		NotBefore:    now,
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+	}
+	return acme.Certificate{Leaf: leaf, PrivateKey: key}, nil
+}
+
+type fakeRecorder struct {
+	recorded []state.Issuance
+	err      error
+}
+
+func (recorder *fakeRecorder) RecordIssuance(
+	ctx context.Context, issuance state.Issuance,
+) error {
+	recorder.recorded = append(recorder.recorded, issuance)
+	return recorder.err
+}
+
+type fakeSolver struct{}
+
+func (fakeSolver) Type() string { return "http-01" }
+
+func (fakeSolver) Present(ctx context.Context, _, _, _ string) error {
+	return nil
+}
+
+func (fakeSolver) CleanUp(ctx context.Context, _, _, _ string) error {
+	return nil
+}
+
+func target(
+	name string, notAfter time.Time, policy *app.CertificatePolicy,
+	installer *fakeInstaller,
+) Target {
+	return Target{
+		Certificate: app.Certificate{
+			Metadata: app.CertificateMetadata{
+				Name: name, Domains: []string{name + ".example.com"},
+			},
+			Policy: policy,
+		},
+		Source:    fakeSource{cert: &x509.Certificate{NotAfter: notAfter}},
+		Installer: installer,
+		Solver:    fakeSolver{},
+	}
+}
+
+func testEngine(t *testing.T, issuer Issuer) Engine {
+	engine, err := NewEngine(
+		issuer, nil, app.CertificatePolicy{RenewBefore: 30 * 24 * time.Hour},
+	)
+	assert.NoError(t, err)
+	engine.now = func() time.Time { return now }
+	return engine
+}
+
+func TestEngine_Renew_NoIssuer(t *testing.T) {
+	engine, err := NewEngine(nil, nil, app.CertificatePolicy{})
+	assert.NoError(t, err)
+
+	report := engine.Renew(
+		context.Background(),
+		[]Target{target("test", now, nil, &fakeInstaller{})}, true,
+	)
+	assert.True(t, report.Failed())
+}
+
+func TestLazyIssuer(t *testing.T) {
+	ctx := context.Background()
+	due := []Target{target("test", now, nil, &fakeInstaller{})}
+	valid := []Target{
+		target("test", now.Add(60*24*time.Hour), nil, &fakeInstaller{}),
+	}
+
+	t.Run(
+		"Created once when due", func(t *testing.T) {
+			issuer := &fakeIssuer{}
+			created := 0
+			lazy, err := NewLazyIssuer(
+				func(ctx context.Context) (Issuer, error) {
+					created++
+					return issuer, nil
+				},
+			)
+			assert.NoError(t, err)
+			engine := testEngine(t, lazy)
+
+			engine.Renew(ctx, valid, false)
+			assert.Equal(t, 0, created)
+
+			assert.False(t, engine.Renew(ctx, append(due, due...), false).Failed())
+			assert.Equal(t, 1, created)
+			assert.Len(t, issuer.issued, 2)
+		},
+	)
+
+	t.Run(
+		"Creation fails", func(t *testing.T) {
+			created := 0
+			lazy, err := NewLazyIssuer(
+				func(ctx context.Context) (Issuer, error) {
+					created++
+					return nil, errors.New("bootstrap failed")
+				},
+			)
+			assert.NoError(t, err)
+			engine := testEngine(t, lazy)
+
+			report := engine.Renew(ctx, append(due, due...), false)
+			assert.True(t, report.Failed())
+			assert.ErrorContains(t, report[1].Err, "bootstrap failed")
+			assert.Equal(t, 1, created)
+		},
+	)
+}
+
+func TestEngine_Check(t *testing.T) {
+	day := 24 * time.Hour
+	failing := target("failing", now, nil, &fakeInstaller{})
+	failing.Source = fakeSource{err: errors.New("unreachable")}
+	untrusted := target("untrusted", now.Add(60*day), nil, nil)
+	untrusted.Source = fakeSource{
+		cert:      &x509.Certificate{NotAfter: now.Add(60 * day)},
+		verifyErr: errors.New("x509: certificate signed by unknown authority"),
+	}
+	unverified := target("unverified", now.Add(-day), nil, nil)
+	unverified.Source = fakeSource{
+		cert:      &x509.Certificate{NotAfter: now.Add(-day)},
+		verifyErr: errors.New("x509: certificate has expired"),
+	}
+
+	tests := []struct {
+		name    string
+		target  Target
+		want    Status
+		wantErr bool
+	}{
+		{
+			"valid", target("valid", now.Add(60*day), nil, nil),
+			StatusValid, false,
+		},
+		{
+			"due by global policy", target("due", now.Add(20*day), nil, nil),
+			StatusDue, false,
+		},
+		{
+			"valid by cert policy", target(
+				"policy", now.Add(20*day),
+				&app.CertificatePolicy{RenewBefore: 15 * day}, nil,
+			), StatusValid, false,
+		},
+		{
+			"expired", target("expired", now.Add(-day), nil, nil),
+			StatusDue, false,
+		},
+		{"untrusted", untrusted, StatusValid, true},
+		{"expired and unverified", unverified, StatusDue, true},
+		{"source error", failing, StatusFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				issuer := &fakeIssuer{}
+				engine := testEngine(t, issuer)
+
+				report := engine.Check(context.Background(), []Target{tt.target})
+				assert.Len(t, report, 1)
+				assert.Equal(t, tt.want, report[0].Status)
+				assert.Equal(t, tt.wantErr, report[0].Err != nil)
+				assert.Empty(t, issuer.issued)
+			},
+		)
+	}
+}
+
+func TestEngine_Renew(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name         string
+		notAfter     time.Time
+		force        bool
+		issueErr     error
+		installErr   error
+		want         Status
+		wantInstalls int
+	}{
+		{"not due", now.Add(60 * day), false, nil, nil, StatusValid, 0},
+		{"forced", now.Add(60 * day), true, nil, nil, StatusRenewed, 1},
+		{"due", now.Add(10 * day), false, nil, nil, StatusRenewed, 1},
+		{
+			"issue failed", now.Add(10 * day), false,
+			errors.New("order failed"), nil, StatusFailed, 0,
+		},
+		{
+			"install failed", now.Add(10 * day), false, nil,
+			errors.New("disk full"), StatusFailed, 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				issuer := &fakeIssuer{err: tt.issueErr}
+				installer := &fakeInstaller{err: tt.installErr}
+				engine := testEngine(t, issuer)
+
+				report := engine.Renew(
+					context.Background(),
+					[]Target{target("test", tt.notAfter, nil, installer)},
+					tt.force,
+				)
+				assert.Len(t, report, 1)
+				assert.Equal(t, tt.want, report[0].Status)
+				assert.Equal(t, tt.want == StatusFailed, report.Failed())
+				assert.Len(t, installer.installed, tt.wantInstalls)
+				if tt.want == StatusRenewed {
+					assert.Equal(t, now.Add(90*day), report[0].NotAfter)
+				}
+			},
+		)
+	}
+}
+
+func TestEngine_Renew_FirstIssuance(t *testing.T) {
+	missing := fakeSource{err: fmt.Errorf("no file: %w", cert.ErrNotFound)}
+	failing := fakeSource{err: errors.New("unreachable")}
+	tests := []struct {
+		name         string
+		source       fakeSource
+		force        bool
+		wantCheck    Status
+		want         Status
+		wantInstalls int
+	}{
+		{"missing", missing, false, StatusDue, StatusRenewed, 1},
+		{"missing forced", missing, true, StatusDue, StatusRenewed, 1},
+		{"unreachable", failing, false, StatusFailed, StatusFailed, 0},
+		{"unreachable forced", failing, true, StatusFailed, StatusRenewed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				issuer := &fakeIssuer{}
+				installer := &fakeInstaller{}
+				engine := testEngine(t, issuer)
+				target := target("new", now, nil, installer)
+				target.Source = tt.source
+
+				report := engine.Check(context.Background(), []Target{target})
+				assert.Equal(t, tt.wantCheck, report[0].Status)
+
+				report = engine.Renew(
+					context.Background(), []Target{target}, tt.force,
+				)
+				assert.Len(t, report, 1)
+				assert.Equal(t, tt.want, report[0].Status)
+				assert.Len(t, installer.installed, tt.wantInstalls)
+			},
+		)
+	}
+}
+
+func TestEngine_Renew_Recorded(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name       string
+		issueErr   error
+		installErr error
+		recordErr  error
+		wantStatus Status
+		want       state.Issuance
+	}{
+		{
+			"issued", nil, nil, nil, StatusRenewed, state.Issuance{
+				Name: "test", Time: now, Outcome: state.OutcomeIssued,
+				Serial: "1a2b", Domains: []string{"test.example.com"},
+				NotBefore: now, NotAfter: now.Add(90 * day),
+			},
+		},
+		{
+			"issue failed", errors.New("order failed"), nil, nil,
+			StatusFailed, state.Issuance{
+				Name: "test", Time: now, Outcome: state.OutcomeFailed,
+				Domains: []string{"test.example.com"},
+				Error:   "issuing certificate: order failed",
+			},
+		},
+		{
+			"install failed", nil, errors.New("disk full"), nil, StatusFailed,
+			state.Issuance{
+				Name: "test", Time: now, Outcome: state.OutcomeFailed,
+				Serial: "1a2b", Domains: []string{"test.example.com"},
+				NotBefore: now, NotAfter: now.Add(90 * day),
+				Error: "installing certificate: disk full",
+			},
+		},
+		{
+			"record failed", nil, nil, errors.New("state unreachable"),
+			StatusRenewed, state.Issuance{
+				Name: "test", Time: now, Outcome: state.OutcomeIssued,
+				Serial: "1a2b", Domains: []string{"test.example.com"},
+				NotBefore: now, NotAfter: now.Add(90 * day),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				recorder := &fakeRecorder{err: tt.recordErr}
+				engine := testEngine(t, &fakeIssuer{err: tt.issueErr})
+				engine.recorder = recorder
+
+				report := engine.Renew(
+					context.Background(), []Target{
+						target(
+							"test", now, nil,
+							&fakeInstaller{err: tt.installErr},
+						),
+					}, false,
+				)
+				assert.Equal(t, tt.wantStatus, report[0].Status)
+				assert.Equal(t, []state.Issuance{tt.want}, recorder.recorded)
+				if tt.recordErr != nil {
+					assert.ErrorContains(t, report[0].Err, "state unreachable")
+				}
+			},
+		)
+	}
+}
+
+func TestEngine_Renew_Acme(t *testing.T) {
+	server := acmetest.NewServer(t)
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	key, err := jwk.New(privateKey)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	client, err := acme.NewClient(
+		ctx, server.DirectoryUrl(), key, server.Client(),
+	)
+	assert.NoError(t, err)
+	_, err = client.Register(ctx, nil)
+	assert.NoError(t, err)
+
+	engine := testEngine(t, client)
+	engine.now = time.Now
+	installer := &fakeInstaller{}
+
+	report := engine.Renew(
+		ctx, []Target{target("acme", time.Now(), nil, installer)}, false,
+	)
+	assert.False(t, report.Failed(), "%v", report)
+	assert.Len(t, installer.installed, 1)
+	assert.Equal(t, []string{"acme.example.com"}, installer.installed[0].DNSNames)
+}
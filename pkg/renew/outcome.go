@@ -0,0 +1,70 @@
+package renew
+
+import (
+	"fmt"
+	"time"
+)
+
+//go:generate go run github.com/abice/go-enum -f=$GOFILE --marshal --nocase
+
+// ENUM(valid, due, renewed, failed)
+type Status int
+
+type Outcome struct {
+	Name     string
+	Status   Status
+	NotAfter time.Time
+	RenewAt  time.Time
+	Err      error
+}
+
+func (outcome Outcome) String() string {
+	switch outcome.Status {
+	case StatusFailed:
+		return fmt.Sprintf("%s: %s: %v", outcome.Name, outcome.Status, outcome.Err)
+	case StatusRenewed:
+		renewed := fmt.Sprintf(
+			"%s: %s, now expires %s", outcome.Name, outcome.Status,
+			outcome.NotAfter.Format(time.RFC3339),
+		)
+		if outcome.Err != nil {
+			renewed += fmt.Sprintf(" (%v)", outcome.Err)
+		}
+		return renewed
+	case StatusDue:
+		if outcome.NotAfter.IsZero() {
+			return fmt.Sprintf(
+				"%s: %s, no current certificate", outcome.Name, outcome.Status,
+			)
+		}
+	}
+	checked := fmt.Sprintf(
+		"%s: %s, expires %s, renews from %s", outcome.Name, outcome.Status,
+		outcome.NotAfter.Format(time.RFC3339),
+		outcome.RenewAt.Format(time.RFC3339),
+	)
+	if outcome.Err != nil {
+		checked += fmt.Sprintf(" (%v)", outcome.Err)
+	}
+	return checked
+}
+
+type Report []Outcome
+
+func (report Report) Failed() bool {
+	for _, outcome := range report {
+		if outcome.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func (report Report) Due() bool {
+	for _, outcome := range report {
+		if outcome.Status == StatusDue {
+			return true
+		}
+	}
+	return false
+}
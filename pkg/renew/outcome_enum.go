@@ -0,0 +1,79 @@
+// Code generated by go-enum DO NOT EDIT.
+// Version:
+// Revision:
+// Build Date:
+// Built By:
+
+package renew
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// StatusValid is a Status of type Valid.
+	StatusValid Status = iota
+	// StatusDue is a Status of type Due.
+	StatusDue
+	// StatusRenewed is a Status of type Renewed.
+	StatusRenewed
+	// StatusFailed is a Status of type Failed.
+	StatusFailed
+)
+
+const _StatusName = "validduerenewedfailed"
+
+var _StatusMap = map[Status]string{
+	StatusValid:   _StatusName[0:5],
+	StatusDue:     _StatusName[5:8],
+	StatusRenewed: _StatusName[8:15],
+	StatusFailed:  _StatusName[15:21],
+}
+
+// String implements the Stringer interface.
+func (x Status) String() string {
+	if str, ok := _StatusMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("Status(%d)", x)
+}
+
+var _StatusValue = map[string]Status{
+	_StatusName[0:5]:                    StatusValid,
+	strings.ToLower(_StatusName[0:5]):   StatusValid,
+	_StatusName[5:8]:                    StatusDue,
+	strings.ToLower(_StatusName[5:8]):   StatusDue,
+	_StatusName[8:15]:                   StatusRenewed,
+	strings.ToLower(_StatusName[8:15]):  StatusRenewed,
+	_StatusName[15:21]:                  StatusFailed,
+	strings.ToLower(_StatusName[15:21]): StatusFailed,
+}
+
+// ParseStatus attempts to convert a string to a Status.
+func ParseStatus(name string) (Status, error) {
+	if x, ok := _StatusValue[name]; ok {
+		return x, nil
+	}
+	// Case insensitive parse, do a separate lookup to prevent unnecessary cost of lowercasing a string if we don't need to.
+	if x, ok := _StatusValue[strings.ToLower(name)]; ok {
+		return x, nil
+	}
+	return Status(0), fmt.Errorf("%s is not a valid Status", name)
+}
+
+// MarshalText implements the text marshaller method.
+func (x Status) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method.
+func (x *Status) UnmarshalText(text []byte) error {
+	name := string(text)
+	tmp, err := ParseStatus(name)
+	if err != nil {
+		return err
+	}
+	*x = tmp
+	return nil
+}
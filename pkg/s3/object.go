@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultRegion is used when no region is configured, as S3-compatible stores
+// like MinIO don't need one.
+const DefaultRegion = "us-east-1"
+
+// ErrModified is returned when uploading an object that has changed since it
+// was downloaded.
+var ErrModified = errors.New("object was modified")
+
+type ObjectClient interface {
+	// Upload replaces the object if its ETag is still etag, or creates it if
+	// etag is empty and the object doesn't exist, returning the new ETag. It
+	// returns ErrModified if neither is the case. Stores that don't support
+	// conditional writes replace the object regardless.
+	Upload(ctx context.Context, buffer []byte, etag string) (string, error)
+	// Download returns the object's contents and ETag.
+	Download(ctx context.Context) ([]byte, string, error)
+	Exists(ctx context.Context) (bool, error)
+}
+
+//go:generate mockery --name ObjectClient --filename objectclient_mock.go --with-expecter
+
+// Config locates an object. Endpoint is only needed for stores other than
+// AWS, many of which also need PathStyle addressing.
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Key       string
+	PathStyle bool
+}
+
+type objectClient struct {
+	s3     *awss3.S3
+	bucket string
+	key    string
+}
+
+// NewObjectClient creates a client for the object, with credentials from the
+// environment, shared config or instance role as the AWS CLI finds them.
+func NewObjectClient(config Config) (ObjectClient, error) {
+	awsConfig := aws.NewConfig().WithS3ForcePathStyle(config.PathStyle)
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+
+	sess, err := session.NewSessionWithOptions(
+		session.Options{
+			Config:            *awsConfig,
+			SharedConfigState: session.SharedConfigEnable,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating session: %v", err)
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(DefaultRegion)
+	}
+
+	return objectClient{awss3.New(sess), config.Bucket, config.Key}, nil
+}
+
+func (client objectClient) Upload(
+	ctx context.Context, buffer []byte, etag string,
+) (string, error) {
+	req, resp := client.s3.PutObjectRequest(
+		&awss3.PutObjectInput{
+			Bucket: &client.bucket,
+			Key:    &client.key,
+			Body:   bytes.NewReader(buffer),
+		},
+	)
+	req.SetContext(ctx)
+	// the sdk predates conditional writes, so the headers are set directly
+	if etag == "" {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	} else {
+		req.HTTPRequest.Header.Set("If-Match", etag)
+	}
+
+	err := req.Send()
+	if hasStatus(err, http.StatusPreconditionFailed) {
+		return "", ErrModified
+	} else if err != nil {
+		return "", fmt.Errorf("uploading: %v", err)
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+func (client objectClient) Download(ctx context.Context) ([]byte, string, error) {
+	resp, err := client.s3.GetObjectWithContext(
+		ctx, &awss3.GetObjectInput{Bucket: &client.bucket, Key: &client.key},
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading: %v", err)
+	}
+	defer resp.Body.Close()
+
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading: %v", err)
+	}
+	return buffer, aws.StringValue(resp.ETag), nil
+}
+
+func (client objectClient) Exists(ctx context.Context) (bool, error) {
+	_, err := client.s3.HeadObjectWithContext(
+		ctx, &awss3.HeadObjectInput{Bucket: &client.bucket, Key: &client.key},
+	)
+	if hasStatus(err, http.StatusNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("checking object: %v", err)
+	}
+	return true, nil
+}
+
+func hasStatus(err error, status int) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == status
+}
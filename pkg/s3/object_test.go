@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"context"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/s3/s3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, server *s3test.Server, key string) ObjectClient {
+	s3test.UseCredentials(t)
+	client, err := NewObjectClient(
+		Config{
+			Endpoint:  server.URL,
+			Bucket:    "bucket",
+			Key:       key,
+			PathStyle: true,
+		},
+	)
+	assert.Nil(t, err)
+	return client
+}
+
+func TestObjectClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(
+		"Upload", func(t *testing.T) {
+			server := s3test.NewServer(t, "bucket")
+			client := newTestClient(t, server, "state/certforgot.yaml")
+
+			etag, err := client.Upload(ctx, []byte("first"), "")
+			assert.Nil(t, err)
+			assert.NotEmpty(t, etag)
+
+			etag, err = client.Upload(ctx, []byte("second"), etag)
+			assert.Nil(t, err)
+
+			buffer, current, err := client.Download(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("second"), buffer)
+			assert.Equal(t, etag, current)
+			assert.Equal(
+				t, []byte("second"), server.Object("bucket", "state/certforgot.yaml"),
+			)
+		},
+	)
+
+	t.Run(
+		"Upload (modified)", func(t *testing.T) {
+			server := s3test.NewServer(t, "bucket")
+			client := newTestClient(t, server, "certforgot.yaml")
+
+			etag, err := client.Upload(ctx, []byte("first"), "")
+			assert.Nil(t, err)
+			server.PutObject("bucket", "certforgot.yaml", []byte("other"))
+
+			tests := []struct {
+				name string
+				etag string
+			}{
+				{"stale etag", etag},
+				{"already exists", ""},
+			}
+			for _, tt := range tests {
+				_, err := client.Upload(ctx, []byte("second"), tt.etag)
+				assert.ErrorIs(t, err, ErrModified, tt.name)
+			}
+			assert.Equal(
+				t, []byte("other"), server.Object("bucket", "certforgot.yaml"),
+			)
+		},
+	)
+
+	t.Run(
+		"Exists", func(t *testing.T) {
+			server := s3test.NewServer(t, "bucket")
+			client := newTestClient(t, server, "certforgot.yaml")
+
+			exists, err := client.Exists(ctx)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+
+			server.PutObject("bucket", "certforgot.yaml", []byte("state"))
+			exists, err = client.Exists(ctx)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+		},
+	)
+
+	t.Run(
+		"Download (missing bucket)", func(t *testing.T) {
+			server := s3test.NewServer(t)
+			client := newTestClient(t, server, "certforgot.yaml")
+
+			_, _, err := client.Download(ctx)
+			assert.Error(t, err)
+		},
+	)
+}
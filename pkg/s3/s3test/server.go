@@ -0,0 +1,135 @@
+// Package s3test provides an in-process fake S3-compatible store for tests,
+// serving path-style requests without checking signatures.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type object struct {
+	data []byte
+	etag string
+}
+
+type Server struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	buckets map[string]map[string]object
+}
+
+// NewServer creates a store with the given, empty, buckets.
+func NewServer(t testing.TB, buckets ...string) *Server {
+	server := &Server{buckets: map[string]map[string]object{}}
+	for _, bucket := range buckets {
+		server.buckets[bucket] = map[string]object{}
+	}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// UseCredentials points the AWS SDK at dummy credentials for the rest of the
+// test, as the server doesn't check them but the SDK needs some.
+func UseCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+}
+
+// Object returns the object's contents, or nil if it doesn't exist.
+func (server *Server) Object(bucket, key string) []byte {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.buckets[bucket][key].data
+}
+
+// PutObject writes the object, as if written by another client, returning
+// its ETag.
+func (server *Server) PutObject(bucket, key string, data []byte) string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.put(bucket, key, data)
+}
+
+func (server *Server) put(bucket, key string, data []byte) string {
+	digest := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(digest[:]) + `"`
+	server.buckets[bucket][key] = object{data, etag}
+	return etag
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+	bucket, key := parts[0], parts[1]
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	objects, ok := server.buckets[bucket]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	existing, exists := objects[key]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", existing.etag)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(existing.data)
+		}
+	case http.MethodPut:
+		ifMatch := r.Header.Get("If-Match")
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		if (ifMatch != "" && (!exists || ifMatch != existing.etag)) ||
+			(ifNoneMatch == "*" && exists) {
+			writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		w.Header().Set("ETag", server.put(bucket, key, data))
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.WriteHeader(status)
+	// responses to HEAD have no body
+	if r.Method == http.MethodHead {
+		return
+	}
+	xml.NewEncoder(w).Encode(
+		struct {
+			XMLName xml.Name `xml:"Error"`
+			Code    string   `xml:"Code"`
+			Message string   `xml:"Message"`
+		}{Code: code, Message: code},
+	)
+}
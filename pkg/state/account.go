@@ -0,0 +1,157 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoAccount = errors.New("no such account")
+
+// AccountKey identifies an account in the state by the ACME directory it's
+// registered with and, if several accounts share a directory, its email.
+type AccountKey struct {
+	Directory string `yaml:"directory" json:"directory"`
+	Email     string `yaml:"email,omitempty" json:"email,omitempty"`
+}
+
+// NewAccountKey creates a key for the account at directory. email may be nil
+// to key on the directory alone.
+func NewAccountKey(directory *url.URL, email *mail.Address) AccountKey {
+	key := AccountKey{Directory: directory.String()}
+	if email != nil {
+		key.Email = strings.ToLower(email.Address)
+	}
+	return key
+}
+
+func (key AccountKey) String() string {
+	directory := key.Directory
+	if directory == "" {
+		directory = "<unknown directory>"
+	}
+	if key.Email == "" {
+		return directory
+	}
+	return fmt.Sprintf("%s (%s)", directory, key.Email)
+}
+
+// LegacyKey is the key of the account kept from before the state held
+// multiple accounts, until it's adopted.
+var LegacyKey = AccountKey{}
+
+// LegacyAdopter is implemented by sources that may hold an account from
+// before the state held multiple accounts.
+type LegacyAdopter interface {
+	// AdoptLegacy moves the legacy account to key if key isn't stored yet,
+	// returning whether it did. It fails if the legacy account can't be moved.
+	AdoptLegacy(ctx context.Context, key AccountKey) (bool, error)
+}
+
+// Account is an account's state along with its key.
+type Account struct {
+	Key   AccountKey `yaml:",inline"`
+	State State      `yaml:",inline"`
+}
+
+// maxDocumentWrites is how many times a change is tried when a document
+// shared between instances keeps changing.
+const maxDocumentWrites = 3
+
+// document is the serialised state of the sources that store every account
+// in one file.
+type document struct {
+	Accounts []Account  `yaml:"accounts"`
+	History  []Issuance `yaml:"history,omitempty"`
+}
+
+func (doc *document) UnmarshalYAML(value *yaml.Node) error {
+	aux := &struct {
+		Accounts []Account  `yaml:"accounts"`
+		History  []Issuance `yaml:"history"`
+		// state from before multiple accounts were held, which is kept under
+		// LegacyKey
+		Legacy State `yaml:",inline"`
+	}{}
+	if err := value.Decode(aux); err != nil {
+		return err
+	}
+
+	doc.Accounts = aux.Accounts
+	doc.History = aux.History
+	if aux.Legacy.UserPrivateKey.Key != nil {
+		doc.Accounts = append(
+			doc.Accounts, Account{Key: LegacyKey, State: aux.Legacy},
+		)
+	}
+	return nil
+}
+
+func parseDocument(buf []byte) (document, error) {
+	doc := document{}
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return doc, fmt.Errorf("parsing state: %v", err)
+	}
+	return doc, nil
+}
+
+func (doc document) marshal() ([]byte, error) {
+	return yaml.Marshal(&doc)
+}
+
+func (doc document) get(key AccountKey) (State, bool) {
+	for _, account := range doc.Accounts {
+		if account.Key == key {
+			return account.State, true
+		}
+	}
+	return State{}, false
+}
+
+// hashVersion versions the account by a hash of its state, or is NoVersion
+// if the account isn't in the document.
+func (doc document) hashVersion(key AccountKey) (Version, error) {
+	s, ok := doc.get(key)
+	if !ok {
+		return NoVersion, nil
+	}
+	return hashVersion(s)
+}
+
+func (doc *document) set(key AccountKey, state State) {
+	for i := range doc.Accounts {
+		if doc.Accounts[i].Key == key {
+			doc.Accounts[i].State = state
+			return
+		}
+	}
+	doc.Accounts = append(doc.Accounts, Account{key, state})
+}
+
+// adoptLegacy rekeys the legacy account to key unless key is already stored,
+// returning whether the document changed.
+func (doc *document) adoptLegacy(key AccountKey) bool {
+	if _, ok := doc.get(key); ok {
+		return false
+	}
+	for i := range doc.Accounts {
+		if doc.Accounts[i].Key == LegacyKey {
+			doc.Accounts[i].Key = key
+			return true
+		}
+	}
+	return false
+}
+
+func (doc document) keys() []AccountKey {
+	var keys []AccountKey
+	for _, account := range doc.Accounts {
+		keys = append(keys, account.Key)
+	}
+	return keys
+}
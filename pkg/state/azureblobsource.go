@@ -2,44 +2,59 @@ package state
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/figglewatts/certforgot/pkg/azure"
-	"gopkg.in/yaml.v3"
 )
 
+// AzureBlobSource keeps the state in a blob. Accounts are versioned by the
+// blob's ETag, so updating any account or the history changes every account's
+// version.
 type AzureBlobSource struct {
-	client azure.BlobClient
+	documentSource
+	lockClient azure.BlobClient
 }
 
+// NewAzureBlobSource creates a source keeping the state in client's blob,
+// encrypted with cipher unless it's nil. The source is locked by leasing
+// lockClient's blob, so it can't be locked if lockClient is nil.
 func NewAzureBlobSource(
-	client azure.BlobClient,
+	client azure.BlobClient, lockClient azure.BlobClient, cipher Cipher,
 ) (AzureBlobSource, error) {
-	return AzureBlobSource{client}, nil
+	return AzureBlobSource{
+		documentSource{objectStore{client, cipher}, azure.ErrModified, etagVersion},
+		lockClient,
+	}, nil
 }
 
-func (source AzureBlobSource) Update(ctx context.Context, state State) error {
-	marshaledState, err := yaml.Marshal(state)
-	if err != nil {
-		return err
-	}
-
-	return source.client.Upload(ctx, marshaledState)
+type blobLock struct {
+	client  azure.BlobClient
+	leaseId string
 }
 
-func (source AzureBlobSource) Get(ctx context.Context) (State, error) {
-	s := State{}
-
-	blobBuf, err := source.client.Download(ctx)
-	if err != nil {
-		return s, err
+// TryLock leases the lock blob. Leases last between 15 and 60 seconds, so ttl
+// is clamped to that range.
+func (source AzureBlobSource) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	if source.lockClient == nil {
+		return nil, errors.New("no lock blob")
 	}
 
-	if err := yaml.Unmarshal(blobBuf, &s); err != nil {
-		return s, err
+	leaseId, err := source.lockClient.AcquireLease(ctx, ttl)
+	if errors.Is(err, azure.ErrLeased) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	return s, nil
+	return blobLock{source.lockClient, leaseId}, nil
+}
+
+func (lock blobLock) Renew(ctx context.Context) error {
+	return lock.client.RenewLease(ctx, lock.leaseId)
 }
 
-func (source AzureBlobSource) Exists(ctx context.Context) (bool, error) {
-	return source.client.Exists(ctx)
+func (lock blobLock) Unlock(ctx context.Context) error {
+	return lock.client.ReleaseLease(ctx, lock.leaseId)
 }
@@ -2,89 +2,157 @@ package state
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/mail"
 
 	"github.com/figglewatts/certforgot/pkg/azure"
 )
 
+// AzureKeyVaultSource stores each account's email and private key as secrets,
+// named after the configured names with a suffix for the account. The key
+// isn't stored as a Key Vault key, as those only give back their public half.
+// A further secret indexes the accounts, and another holds the most recent
+// issuances.
 type AzureKeyVaultSource struct {
 	client azure.KeyVaultClient
 	config *AzureKeyVaultSourceConfig
 }
 
 const (
-	DefaultEmailSecretName = "certforgot-useremail"
-	DefaultKeyName         = "certforgot-userkey"
+	DefaultEmailSecretName   = "certforgot-useremail"
+	DefaultKeyName           = "certforgot-userkey"
+	DefaultIndexSecretName   = "certforgot-accounts"
+	DefaultHistorySecretName = "certforgot-history"
+
+	// secrets hold at most 25KB, so only the most recent issuances are kept
+	maxVaultHistory = 50
 )
 
 type AzureKeyVaultSourceConfig struct {
-	EmailSecretName string
-	KeyName         string
+	EmailSecretName   string
+	KeyName           string
+	IndexSecretName   string
+	HistorySecretName string
 }
 
 func NewAzureKeyVaultSource(
 	client azure.KeyVaultClient, config *AzureKeyVaultSourceConfig,
 ) (AzureKeyVaultSource, error) {
 	if config == nil {
-		config = &AzureKeyVaultSourceConfig{
-			DefaultEmailSecretName, DefaultKeyName,
-		}
+		config = &AzureKeyVaultSourceConfig{}
+	}
+	if config.EmailSecretName == "" {
+		config.EmailSecretName = DefaultEmailSecretName
+	}
+	if config.KeyName == "" {
+		config.KeyName = DefaultKeyName
+	}
+	if config.IndexSecretName == "" {
+		config.IndexSecretName = DefaultIndexSecretName
+	}
+	if config.HistorySecretName == "" {
+		config.HistorySecretName = DefaultHistorySecretName
 	}
 
 	return AzureKeyVaultSource{client, config}, nil
 }
 
+// Update versions the account by its email secret's version. Key Vault can't
+// write conditionally, so a conflicting write landing between checking the
+// version and setting the secret isn't caught.
 func (source AzureKeyVaultSource) Update(
-	ctx context.Context, state State,
-) error {
-	// set the secret
-	value := state.UserEmail.String()
-	err := source.client.SetSecret(ctx, source.config.EmailSecretName, value)
+	ctx context.Context, key AccountKey, state State, expected Version,
+) (Version, error) {
+	current, err := source.client.GetSecret(ctx, source.emailSecretName(key), "")
 	if err != nil {
-		return err
+		return NoVersion, err
+	}
+	currentVersion := NoVersion
+	if current != nil {
+		currentVersion = Version(current.Version)
+	}
+	if currentVersion != expected {
+		return NoVersion, &ConflictError{key, expected}
 	}
 
-	err = source.client.ImportKey(
-		ctx, source.config.KeyName, state.UserPrivateKey.Key,
+	marshaledKey, err := state.MarshaledPrivateKey()
+	if err != nil {
+		return NoVersion, fmt.Errorf("marshaling account key: %v", err)
+	}
+	_, err = source.client.SetSecret(ctx, source.keyName(key), marshaledKey)
+	if err != nil {
+		return NoVersion, err
+	}
+
+	// the email is set last so its version only changes once the key has
+	version, err := source.client.SetSecret(
+		ctx, source.emailSecretName(key), state.UserEmail.String(),
 	)
 	if err != nil {
-		return err
+		return NoVersion, err
 	}
 
-	return nil
-}
+	keys, err := source.List(ctx)
+	if err != nil {
+		return NoVersion, err
+	}
+	for _, existing := range keys {
+		if existing == key {
+			return Version(version), nil
+		}
+	}
 
-func (source AzureKeyVaultSource) Get(ctx context.Context) (State, error) {
-	email, err := source.client.GetSecret(
-		ctx, source.config.EmailSecretName, "",
+	index, err := json.Marshal(append(keys, key))
+	if err != nil {
+		return NoVersion, fmt.Errorf("marshaling account index: %v", err)
+	}
+	_, err = source.client.SetSecret(
+		ctx, source.config.IndexSecretName, string(index),
 	)
 	if err != nil {
-		return State{}, err
+		return NoVersion, err
+	}
+	return Version(version), nil
+}
+
+func (source AzureKeyVaultSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	email, err := source.client.GetSecret(ctx, source.emailSecretName(key), "")
+	if err != nil {
+		return State{}, NoVersion, err
 	}
 	if email == nil {
-		return State{}, fmt.Errorf(
-			"no such secret '%s'", source.config.EmailSecretName,
-		)
+		return State{}, NoVersion, ErrNoAccount
 	}
 
-	mailAddr, err := mail.ParseAddress(*email)
+	mailAddr, err := mail.ParseAddress(email.Value)
 	if err != nil {
-		return State{}, err
+		return State{}, NoVersion, err
 	}
 
-	key, err := source.client.GetKey(ctx, source.config.KeyName, "")
+	marshaledKey, err := source.client.GetSecret(ctx, source.keyName(key), "")
 	if err != nil {
-		return State{}, err
+		return State{}, NoVersion, err
+	}
+	if marshaledKey == nil {
+		return State{}, NoVersion, ErrNoAccount
+	}
+	var userKey Jwk
+	if err := userKey.UnmarshalText([]byte(marshaledKey.Value)); err != nil {
+		return State{}, NoVersion, fmt.Errorf("parsing account key: %v", err)
 	}
 
-	return NewState(mailAddr, key), nil
+	return NewState(mailAddr, userKey.Key), Version(email.Version), nil
 }
 
-func (source AzureKeyVaultSource) Exists(ctx context.Context) (bool, error) {
-	email, err := source.client.GetSecret(
-		ctx, source.config.EmailSecretName, "",
-	)
+func (source AzureKeyVaultSource) Exists(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	email, err := source.client.GetSecret(ctx, source.emailSecretName(key), "")
 	if err != nil {
 		return false, err
 	}
@@ -92,13 +160,123 @@ func (source AzureKeyVaultSource) Exists(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 
-	key, err := source.client.GetKey(ctx, source.config.KeyName, "")
+	userKey, err := source.client.GetSecret(ctx, source.keyName(key), "")
 	if err != nil {
 		return false, err
 	}
-	if key == nil {
-		return false, nil
+	return userKey != nil, nil
+}
+
+func (source AzureKeyVaultSource) List(ctx context.Context) ([]AccountKey, error) {
+	index, err := source.client.GetSecret(
+		ctx, source.config.IndexSecretName, "",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return nil, nil
+	}
+
+	var keys []AccountKey
+	if err := json.Unmarshal([]byte(index.Value), &keys); err != nil {
+		return nil, fmt.Errorf("parsing account index: %v", err)
+	}
+	return keys, nil
+}
+
+// AdoptLegacy can't move the account stored under the configured names
+// before the vault held multiple accounts, as its key was imported as a Key
+// Vault key and can't be read back. It fails until that account's email
+// secret is deleted, so that a new account isn't registered unknowingly.
+func (source AzureKeyVaultSource) AdoptLegacy(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	index, err := source.client.GetSecret(
+		ctx, source.config.IndexSecretName, "",
+	)
+	if err != nil || index != nil {
+		return false, err
 	}
 
-	return true, nil
+	email, err := source.client.GetSecret(
+		ctx, source.config.EmailSecretName, "",
+	)
+	if err != nil || email == nil {
+		return false, err
+	}
+	return false, fmt.Errorf(
+		"the account in secret '%s' and key '%s' was stored by an earlier "+
+			"version and can't be moved to %s, as Key Vault won't give back "+
+			"its private key: delete secret '%s' to register a new account",
+		source.config.EmailSecretName, source.config.KeyName, key,
+		source.config.EmailSecretName,
+	)
+}
+
+func (source AzureKeyVaultSource) RecordIssuance(
+	ctx context.Context, issuance Issuance,
+) error {
+	history, err := source.history(ctx)
+	if err != nil {
+		return err
+	}
+	history = append(history, issuance)
+	if len(history) > maxVaultHistory {
+		history = history[len(history)-maxVaultHistory:]
+	}
+
+	marshaled, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("marshaling history: %v", err)
+	}
+	_, err = source.client.SetSecret(
+		ctx, source.config.HistorySecretName, string(marshaled),
+	)
+	return err
+}
+
+func (source AzureKeyVaultSource) History(
+	ctx context.Context, query HistoryQuery,
+) ([]Issuance, error) {
+	history, err := source.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return query.filter(history), nil
+}
+
+func (source AzureKeyVaultSource) history(
+	ctx context.Context,
+) ([]Issuance, error) {
+	marshaled, err := source.client.GetSecret(
+		ctx, source.config.HistorySecretName, "",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if marshaled == nil {
+		return nil, nil
+	}
+
+	var history []Issuance
+	if err := json.Unmarshal([]byte(marshaled.Value), &history); err != nil {
+		return nil, fmt.Errorf("parsing history: %v", err)
+	}
+	return history, nil
+}
+
+func (source AzureKeyVaultSource) emailSecretName(key AccountKey) string {
+	return source.config.EmailSecretName + "-" + vaultSuffix(key)
+}
+
+func (source AzureKeyVaultSource) keyName(key AccountKey) string {
+	return source.config.KeyName + "-" + vaultSuffix(key)
+}
+
+// vaultSuffix derives a name suffix for an account, as vault object names
+// may only contain alphanumerics and dashes.
+func vaultSuffix(key AccountKey) string {
+	digest := sha256.Sum256([]byte(key.Directory + "\n" + key.Email))
+	return hex.EncodeToString(digest[:8])
 }
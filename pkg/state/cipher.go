@@ -0,0 +1,140 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ErrDecrypt is returned when the state can't be decrypted with the
+// configured passphrase or identity.
+var ErrDecrypt = errors.New(
+	"unable to decrypt state, the passphrase or identity is wrong",
+)
+
+// ErrEncrypted is returned when reading encrypted state without a cipher.
+var ErrEncrypted = errors.New("state is encrypted but no encryption is configured")
+
+// ageHeader starts every age encrypted file.
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// Cipher encrypts the state at rest.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+type ageCipher struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewPassphraseCipher creates a cipher encrypting with a key derived from
+// passphrase using scrypt.
+func NewPassphraseCipher(passphrase string) (Cipher, error) {
+	return newScryptCipher(passphrase, 0)
+}
+
+// newScryptCipher uses age's default scrypt work factor if workFactor is 0.
+func newScryptCipher(passphrase string, workFactor int) (Cipher, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if workFactor != 0 {
+		recipient.SetWorkFactor(workFactor)
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ageCipher{[]age.Recipient{recipient}, []age.Identity{identity}}, nil
+}
+
+// NewAgeCipher creates a cipher decrypting with the X25519 identities in
+// identities, as in an age identity file, and encrypting to their recipients
+// along with any other recipients given.
+func NewAgeCipher(identities string, recipients ...string) (Cipher, error) {
+	parsedIdentities, err := age.ParseIdentities(strings.NewReader(identities))
+	if err != nil {
+		return nil, fmt.Errorf("parsing identities: %v", err)
+	}
+
+	cipher := ageCipher{identities: parsedIdentities}
+	for _, identity := range parsedIdentities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			cipher.recipients = append(cipher.recipients, x25519.Recipient())
+		}
+	}
+	for _, recipient := range recipients {
+		parsed, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("parsing recipient: %v", err)
+		}
+		cipher.recipients = append(cipher.recipients, parsed)
+	}
+	return cipher, nil
+}
+
+func (cipher ageCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer, err := age.Encrypt(buf, cipher.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cipher ageCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	reader, err := age.Decrypt(bytes.NewReader(ciphertext), cipher.identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrDecrypt
+	} else if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// openDocument parses buf, decrypting it if it's encrypted. Unencrypted state
+// is still read with a cipher, so it's encrypted when next written.
+func openDocument(buf []byte, cipher Cipher) (document, error) {
+	if bytes.HasPrefix(buf, ageHeader) {
+		if cipher == nil {
+			return document{}, ErrEncrypted
+		}
+		plaintext, err := cipher.Decrypt(buf)
+		if err != nil {
+			return document{}, fmt.Errorf("decrypting state: %w", err)
+		}
+		buf = plaintext
+	}
+	return parseDocument(buf)
+}
+
+// sealDocument marshals doc, encrypting it if there's a cipher.
+func sealDocument(doc document, cipher Cipher) ([]byte, error) {
+	marshaled, err := doc.marshal()
+	if err != nil || cipher == nil {
+		return marshaled, err
+	}
+
+	ciphertext, err := cipher.Encrypt(marshaled)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting state: %v", err)
+	}
+	return ciphertext, nil
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+)
+
+// testWorkFactor keeps scrypt fast in tests.
+const testWorkFactor = 10
+
+func TestAgeCipher(t *testing.T) {
+	plaintext := []byte("accounts: []\n")
+
+	passphraseCipher := func(t *testing.T, passphrase string) Cipher {
+		cipher, err := newScryptCipher(passphrase, testWorkFactor)
+		assert.Nil(t, err)
+		return cipher
+	}
+
+	identityCipher := func(t *testing.T, recipients ...string) (Cipher, *age.X25519Identity) {
+		identity, err := age.GenerateX25519Identity()
+		assert.Nil(t, err)
+		cipher, err := NewAgeCipher(identity.String(), recipients...)
+		assert.Nil(t, err)
+		return cipher, identity
+	}
+
+	t.Run(
+		"Passphrase", func(t *testing.T) {
+			cipher := passphraseCipher(t, "correct horse")
+			ciphertext, err := cipher.Encrypt(plaintext)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(ciphertext), string(plaintext))
+
+			result, err := cipher.Decrypt(ciphertext)
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, result)
+
+			_, err = passphraseCipher(t, "battery staple").Decrypt(ciphertext)
+			assert.ErrorIs(t, err, ErrDecrypt)
+		},
+	)
+
+	t.Run(
+		"Passphrase (empty)", func(t *testing.T) {
+			_, err := NewPassphraseCipher("")
+			assert.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"Identity", func(t *testing.T) {
+			cipher, _ := identityCipher(t)
+			ciphertext, err := cipher.Encrypt(plaintext)
+			assert.Nil(t, err)
+
+			result, err := cipher.Decrypt(ciphertext)
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, result)
+
+			other, _ := identityCipher(t)
+			_, err = other.Decrypt(ciphertext)
+			assert.ErrorIs(t, err, ErrDecrypt)
+		},
+	)
+
+	t.Run(
+		"Identity (extra recipient)", func(t *testing.T) {
+			backup, backupIdentity := identityCipher(t)
+			cipher, _ := identityCipher(t, backupIdentity.Recipient().String())
+			ciphertext, err := cipher.Encrypt(plaintext)
+			assert.Nil(t, err)
+
+			result, err := backup.Decrypt(ciphertext)
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, result)
+		},
+	)
+
+	t.Run(
+		"Identity (bad)", func(t *testing.T) {
+			_, err := NewAgeCipher("AGE-SECRET-KEY-NOPE")
+			assert.Error(t, err)
+
+			identity, err := age.GenerateX25519Identity()
+			assert.Nil(t, err)
+			_, err = NewAgeCipher(identity.String(), "age1nope")
+			assert.Error(t, err)
+		},
+	)
+}
+
+func TestOpenDocument(t *testing.T) {
+	cipher, err := newScryptCipher("correct horse", testWorkFactor)
+	assert.Nil(t, err)
+	doc := document{Accounts: []Account{{testKey, existingState(t)}}}
+
+	t.Run(
+		"Encrypted", func(t *testing.T) {
+			sealed, err := sealDocument(doc, cipher)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(sealed), "test@example.com")
+
+			result, err := openDocument(sealed, cipher)
+			assert.Nil(t, err)
+			assert.Equal(t, doc, result)
+
+			_, err = openDocument(sealed, nil)
+			assert.ErrorIs(t, err, ErrEncrypted)
+		},
+	)
+
+	t.Run(
+		"Unencrypted", func(t *testing.T) {
+			sealed, err := sealDocument(doc, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, existingDocument(t), sealed)
+
+			// plain state is read with a cipher, to be encrypted on write
+			result, err := openDocument(sealed, cipher)
+			assert.Nil(t, err)
+			assert.Equal(t, doc, result)
+		},
+	)
+}
+
+func TestLocalSource_Encrypted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cipher, err := newScryptCipher("correct horse", testWorkFactor)
+	assert.Nil(t, err)
+
+	source, err := NewLocalSource(dir, cipher)
+	assert.Nil(t, err)
+	_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+	assert.Nil(t, err)
+
+	result, _, err := source.Get(ctx, testKey)
+	assert.Nil(t, err)
+	assert.Equal(t, existingState(t), result)
+
+	wrong, err := newScryptCipher("battery staple", testWorkFactor)
+	assert.Nil(t, err)
+	_, _, err = LocalSource{dir, wrong}.Get(ctx, testKey)
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	_, _, err = LocalSource{dir, nil}.Get(ctx, testKey)
+	assert.ErrorIs(t, err, ErrEncrypted)
+}
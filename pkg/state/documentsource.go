@@ -0,0 +1,215 @@
+package state
+
+import (
+	"context"
+	"errors"
+)
+
+// documentStore keeps a document where it can be written conditionally, so
+// instances sharing it don't overwrite each other's changes.
+type documentStore interface {
+	// readDocument returns the document and whatever the store versions it
+	// by, or an empty document and a nil version if there's none yet.
+	readDocument(ctx context.Context) (document, interface{}, error)
+	// writeDocument replaces the document if it's still at version, or
+	// creates it if version is nil, returning the new version.
+	writeDocument(
+		ctx context.Context, doc document, version interface{},
+	) (interface{}, error)
+}
+
+// documentSource is a source keeping every account in one document, for the
+// sources that differ only in where the document is kept.
+type documentSource struct {
+	store documentStore
+	// modified is what the store returns when writing a document that
+	// changed since it was read.
+	modified error
+	// accountVersion versions an account in the document, which the store
+	// has at docVersion.
+	accountVersion func(
+		doc document, key AccountKey, docVersion interface{},
+	) (Version, error)
+}
+
+// etagVersion versions accounts by the ETag of the object holding them, so
+// updating any account or the history changes every account's version.
+func etagVersion(
+	doc document, key AccountKey, docVersion interface{},
+) (Version, error) {
+	if _, ok := doc.get(key); !ok {
+		return NoVersion, nil
+	}
+	etag, _ := docVersion.(string)
+	return Version(etag), nil
+}
+
+// accountHashVersion versions accounts by a hash of their state.
+func accountHashVersion(
+	doc document, key AccountKey, _ interface{},
+) (Version, error) {
+	return doc.hashVersion(key)
+}
+
+// Update only writes the document if it hasn't changed since it was read.
+func (source documentSource) Update(
+	ctx context.Context, key AccountKey, state State, expected Version,
+) (Version, error) {
+	doc, docVersion, err := source.store.readDocument(ctx)
+	if err != nil {
+		return NoVersion, err
+	}
+
+	current, err := source.accountVersion(doc, key, docVersion)
+	if err != nil {
+		return NoVersion, err
+	}
+	if current != expected {
+		return NoVersion, &ConflictError{key, expected}
+	}
+
+	doc.set(key, state)
+	docVersion, err = source.store.writeDocument(ctx, doc, docVersion)
+	if errors.Is(err, source.modified) {
+		return NoVersion, &ConflictError{key, expected}
+	} else if err != nil {
+		return NoVersion, err
+	}
+	return source.accountVersion(doc, key, docVersion)
+}
+
+func (source documentSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	doc, docVersion, err := source.store.readDocument(ctx)
+	if err != nil {
+		return State{}, NoVersion, err
+	}
+
+	s, ok := doc.get(key)
+	if !ok {
+		return State{}, NoVersion, ErrNoAccount
+	}
+	version, err := source.accountVersion(doc, key, docVersion)
+	return s, version, err
+}
+
+func (source documentSource) Exists(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	doc, _, err := source.store.readDocument(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := doc.get(key)
+	return ok, nil
+}
+
+func (source documentSource) List(ctx context.Context) ([]AccountKey, error) {
+	doc, _, err := source.store.readDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doc.keys(), nil
+}
+
+func (source documentSource) AdoptLegacy(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	return source.change(
+		ctx, func(doc *document) bool {
+			return doc.adoptLegacy(key)
+		},
+	)
+}
+
+func (source documentSource) RecordIssuance(
+	ctx context.Context, issuance Issuance,
+) error {
+	_, err := source.change(
+		ctx, func(doc *document) bool {
+			doc.History = append(doc.History, issuance)
+			return true
+		},
+	)
+	return err
+}
+
+func (source documentSource) History(
+	ctx context.Context, query HistoryQuery,
+) ([]Issuance, error) {
+	doc, _, err := source.store.readDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return query.filter(doc.History), nil
+}
+
+// change applies apply to the document and writes it, unless apply reports
+// that nothing changed, retrying if the document changes in the meantime. It
+// returns whether the document was written.
+func (source documentSource) change(
+	ctx context.Context, apply func(doc *document) bool,
+) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		doc, docVersion, err := source.store.readDocument(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !apply(&doc) {
+			return false, nil
+		}
+
+		_, err = source.store.writeDocument(ctx, doc, docVersion)
+		if !errors.Is(err, source.modified) || attempt == maxDocumentWrites {
+			return err == nil, err
+		}
+	}
+}
+
+// objectClient is what blob and S3 clients have in common.
+type objectClient interface {
+	Upload(ctx context.Context, buffer []byte, etag string) (string, error)
+	Download(ctx context.Context) ([]byte, string, error)
+	Exists(ctx context.Context) (bool, error)
+}
+
+// objectStore keeps the document in a blob or object, versioned by its ETag.
+type objectStore struct {
+	client objectClient
+	cipher Cipher
+}
+
+func (store objectStore) readDocument(
+	ctx context.Context,
+) (document, interface{}, error) {
+	exists, err := store.client.Exists(ctx)
+	if err != nil {
+		return document{}, nil, err
+	}
+	if !exists {
+		return document{}, nil, nil
+	}
+
+	objectBuf, etag, err := store.client.Download(ctx)
+	if err != nil {
+		return document{}, nil, err
+	}
+	doc, err := openDocument(objectBuf, store.cipher)
+	return doc, etag, err
+}
+
+func (store objectStore) writeDocument(
+	ctx context.Context, doc document, version interface{},
+) (interface{}, error) {
+	marshaledState, err := sealDocument(doc, store.cipher)
+	if err != nil {
+		return nil, err
+	}
+	etag, _ := version.(string)
+	etag, err = store.client.Upload(ctx, marshaledState, etag)
+	if err != nil {
+		return nil, err
+	}
+	return etag, nil
+}
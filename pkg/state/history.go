@@ -0,0 +1,104 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type IssuanceOutcome string
+
+const (
+	OutcomeIssued IssuanceOutcome = "issued"
+	OutcomeFailed IssuanceOutcome = "failed"
+)
+
+// Issuance records an attempt to issue a certificate. The certificate's
+// details are empty if it failed before a certificate was issued.
+type Issuance struct {
+	Name      string          `yaml:"name" json:"name"`
+	Time      time.Time       `yaml:"time" json:"time"`
+	Outcome   IssuanceOutcome `yaml:"outcome" json:"outcome"`
+	Serial    string          `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Domains   []string        `yaml:"domains,omitempty" json:"domains,omitempty"`
+	NotBefore time.Time       `yaml:"notBefore,omitempty" json:"notBefore,omitempty"`
+	NotAfter  time.Time       `yaml:"notAfter,omitempty" json:"notAfter,omitempty"`
+	Issuer    string          `yaml:"issuer,omitempty" json:"issuer,omitempty"`
+	Error     string          `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+func (issuance Issuance) String() string {
+	var b strings.Builder
+	fmt.Fprintf(
+		&b, "%s %s: %s", issuance.Time.Format(time.RFC3339), issuance.Name,
+		issuance.Outcome,
+	)
+	if issuance.Serial != "" {
+		fmt.Fprintf(
+			&b, ", serial %s for %s, valid %s to %s, issued by %s",
+			issuance.Serial, strings.Join(issuance.Domains, ","),
+			issuance.NotBefore.Format(time.RFC3339),
+			issuance.NotAfter.Format(time.RFC3339), issuance.Issuer,
+		)
+	} else if len(issuance.Domains) > 0 {
+		fmt.Fprintf(&b, " for %s", strings.Join(issuance.Domains, ","))
+	}
+	if issuance.Error != "" {
+		fmt.Fprintf(&b, ": %s", issuance.Error)
+	}
+	return b.String()
+}
+
+// HistoryQuery selects issuances from the history, which are returned oldest
+// first.
+type HistoryQuery struct {
+	// Names selects the certificates with these names, or every certificate
+	// if empty.
+	Names []string
+	// Since selects issuances at or after this time, if set.
+	Since time.Time
+	// Until selects issuances before this time, if set.
+	Until time.Time
+	// Limit selects only the most recent issuances, if positive.
+	Limit int
+}
+
+func (query HistoryQuery) matches(issuance Issuance) bool {
+	if len(query.Names) > 0 {
+		found := false
+		for _, name := range query.Names {
+			found = found || name == issuance.Name
+		}
+		if !found {
+			return false
+		}
+	}
+	if !query.Since.IsZero() && issuance.Time.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !issuance.Time.Before(query.Until) {
+		return false
+	}
+	return true
+}
+
+// filter selects the issuances matching query from history, which is in the
+// order the issuances were recorded.
+func (query HistoryQuery) filter(history []Issuance) []Issuance {
+	var matched []Issuance
+	for _, issuance := range history {
+		if query.matches(issuance) {
+			matched = append(matched, issuance)
+		}
+	}
+	sort.SliceStable(
+		matched, func(i, j int) bool {
+			return matched[i].Time.Before(matched[j].Time)
+		},
+	)
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[len(matched)-query.Limit:]
+	}
+	return matched
+}
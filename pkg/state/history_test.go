@@ -0,0 +1,290 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/azure"
+	"github.com/figglewatts/certforgot/pkg/azure/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var historyStart = time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+
+func testIssuances() []Issuance {
+	return []Issuance{
+		{
+			Name: "web", Time: historyStart, Outcome: OutcomeIssued,
+			Serial: "1a2b", Domains: []string{"example.com", "www.example.com"},
+			NotBefore: historyStart, NotAfter: historyStart.Add(90 * 24 * time.Hour),
+			Issuer: "CN=R3,O=Let's Encrypt,C=US",
+		},
+		{
+			Name: "mail", Time: historyStart.Add(time.Hour),
+			Outcome: OutcomeFailed, Domains: []string{"mail.example.com"},
+			Error: "issuing certificate: order failed",
+		},
+		{
+			Name: "web", Time: historyStart.Add(2 * time.Hour),
+			Outcome: OutcomeFailed, Serial: "3c4d",
+			Domains:   []string{"example.com"},
+			NotBefore: historyStart, NotAfter: historyStart.Add(90 * 24 * time.Hour),
+			Issuer: "CN=R3,O=Let's Encrypt,C=US",
+			Error:  "installing certificate: disk full",
+		},
+	}
+}
+
+func TestHistoryQuery_filter(t *testing.T) {
+	issuances := testIssuances()
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		want  []Issuance
+	}{
+		{"all", HistoryQuery{}, issuances},
+		{
+			"by name", HistoryQuery{Names: []string{"web"}},
+			[]Issuance{issuances[0], issuances[2]},
+		},
+		{
+			"by names", HistoryQuery{Names: []string{"web", "mail"}}, issuances,
+		},
+		{
+			"since", HistoryQuery{Since: historyStart.Add(time.Hour)},
+			issuances[1:],
+		},
+		{
+			"until", HistoryQuery{Until: historyStart.Add(time.Hour)},
+			issuances[:1],
+		},
+		{"limit", HistoryQuery{Limit: 2}, issuances[1:]},
+		{"none", HistoryQuery{Names: []string{"other"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, tt.query.filter(issuances))
+			},
+		)
+	}
+
+	t.Run(
+		"out of order", func(t *testing.T) {
+			reversed := []Issuance{issuances[2], issuances[1], issuances[0]}
+			assert.Equal(t, issuances, HistoryQuery{}.filter(reversed))
+		},
+	)
+}
+
+func TestIssuance_String(t *testing.T) {
+	issuances := testIssuances()
+	assert.Equal(
+		t,
+		"2022-08-01T00:00:00Z web: issued, serial 1a2b for example.com,www.example.com, "+
+			"valid 2022-08-01T00:00:00Z to 2022-10-30T00:00:00Z, issued by CN=R3,O=Let's Encrypt,C=US",
+		issuances[0].String(),
+	)
+	assert.Equal(
+		t,
+		"2022-08-01T01:00:00Z mail: failed for mail.example.com: issuing certificate: order failed",
+		issuances[1].String(),
+	)
+}
+
+// testSourceHistory records the test issuances in source and queries them.
+func testSourceHistory(t *testing.T, source Source) {
+	ctx := context.Background()
+	issuances := testIssuances()
+
+	history, err := source.History(ctx, HistoryQuery{})
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+
+	for _, issuance := range issuances {
+		assert.Nil(t, source.RecordIssuance(ctx, issuance))
+	}
+
+	history, err = source.History(ctx, HistoryQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, issuances, history)
+
+	history, err = source.History(
+		ctx, HistoryQuery{
+			Names: []string{"web"}, Since: historyStart.Add(time.Minute),
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, issuances[2:], history)
+
+	history, err = source.History(ctx, HistoryQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, issuances[2:], history)
+}
+
+func TestSource_History(t *testing.T) {
+	t.Run(
+		"LocalSource", func(t *testing.T) {
+			source, err := NewLocalSource(t.TempDir(), nil)
+			assert.Nil(t, err)
+			testSourceHistory(t, source)
+
+			// history is kept alongside accounts
+			_, err = source.Update(
+				context.Background(), testKey, existingState(t), NoVersion,
+			)
+			assert.Nil(t, err)
+			history, err := source.History(context.Background(), HistoryQuery{})
+			assert.Nil(t, err)
+			assert.Len(t, history, 3)
+		},
+	)
+
+	t.Run(
+		"SqlSource", func(t *testing.T) {
+			ctx := context.Background()
+			db, err := sql.Open(DriverSqlite, path.Join(t.TempDir(), "state.db"))
+			assert.Nil(t, err)
+			t.Cleanup(func() { db.Close() })
+			source, err := NewSqlSource(ctx, DriverSqlite, db)
+			assert.Nil(t, err)
+			testSourceHistory(t, source)
+		},
+	)
+
+	t.Run(
+		"AzureBlobSource", func(t *testing.T) {
+			client := mocks.NewBlobClient(t)
+			source, err := NewAzureBlobSource(client, nil, nil)
+			assert.Nil(t, err)
+
+			// the mock blob holds the document
+			var blob []byte
+			client.On("Exists", mock.Anything).Return(
+				func(context.Context) bool { return blob != nil }, nil,
+			)
+			client.On("Download", mock.Anything).Return(
+				func(context.Context) []byte { return blob }, "etag", nil,
+			)
+			client.EXPECT().
+				Upload(mock.Anything, mock.Anything, mock.Anything).
+				Run(
+					func(_ context.Context, buffer []byte, _ string) {
+						blob = buffer
+					},
+				).
+				Return("etag", nil)
+
+			testSourceHistory(t, source)
+		},
+	)
+
+	t.Run(
+		"AzureKeyVaultSource", func(t *testing.T) {
+			client := mocks.NewKeyVaultClient(t)
+			source, err := NewAzureKeyVaultSource(client, nil)
+			assert.Nil(t, err)
+
+			// the mock vault holds the history secret
+			var secret *azure.Secret
+			client.
+				On("GetSecret", mock.Anything, DefaultHistorySecretName, "").
+				Return(
+					func(context.Context, string, string) *azure.Secret {
+						return secret
+					}, nil,
+				).Maybe()
+			client.EXPECT().
+				SetSecret(mock.Anything, DefaultHistorySecretName, mock.Anything).
+				Run(
+					func(_ context.Context, _ string, value string) {
+						secret = &azure.Secret{Value: value}
+					},
+				).Return("v1", nil).Maybe()
+
+			testSourceHistory(t, source)
+		},
+	)
+}
+
+func TestAzureKeyVaultSource_RecordIssuance(t *testing.T) {
+	ctx := context.Background()
+	client := mocks.NewKeyVaultClient(t)
+	source, err := NewAzureKeyVaultSource(client, nil)
+	assert.Nil(t, err)
+
+	var full []Issuance
+	for i := 0; i < maxVaultHistory; i++ {
+		full = append(
+			full, Issuance{
+				Name: fmt.Sprintf("cert%d", i), Outcome: OutcomeIssued,
+				Time: historyStart.Add(time.Duration(i) * time.Hour),
+			},
+		)
+	}
+	marshaled, err := json.Marshal(full)
+	assert.Nil(t, err)
+	secret := azure.Secret{Value: string(marshaled)}
+
+	// the oldest issuance is dropped to make room
+	next := Issuance{
+		Name: "next", Outcome: OutcomeIssued,
+		Time: historyStart.Add(maxVaultHistory * time.Hour),
+	}
+	expected, err := json.Marshal(append(full[1:], next))
+	assert.Nil(t, err)
+	client.EXPECT().
+		GetSecret(ctx, DefaultHistorySecretName, "").
+		Return(&secret, nil)
+	client.EXPECT().
+		SetSecret(ctx, DefaultHistorySecretName, string(expected)).
+		Return("v1", nil)
+
+	assert.Nil(t, source.RecordIssuance(ctx, next))
+}
+
+func TestAzureBlobSource_RecordIssuance(t *testing.T) {
+	ctx := context.Background()
+	issuance := testIssuances()[0]
+	expected, err := document{History: []Issuance{issuance}}.marshal()
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		uploadErrs []error
+		wantErr    error
+	}{
+		{"first try", []error{nil}, nil},
+		{"modified once", []error{azure.ErrModified, nil}, nil},
+		{
+			"always modified",
+			[]error{azure.ErrModified, azure.ErrModified, azure.ErrModified},
+			azure.ErrModified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := mocks.NewBlobClient(t)
+				source, err := NewAzureBlobSource(client, nil, nil)
+				assert.Nil(t, err)
+
+				client.EXPECT().Exists(ctx).Return(false, nil)
+				for _, uploadErr := range tt.uploadErrs {
+					client.EXPECT().
+						Upload(ctx, expected, "").
+						Return("", uploadErr).Once()
+				}
+
+				err = source.RecordIssuance(ctx, issuance)
+				assert.ErrorIs(t, err, tt.wantErr)
+			},
+		)
+	}
+}
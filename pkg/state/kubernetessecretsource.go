@@ -0,0 +1,210 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcoordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// SecretKey is the key in the secret's data holding the state.
+const SecretKey = FileName
+
+// errSecretModified is returned when writing a secret that was changed or
+// created since it was read.
+var errSecretModified = errors.New("secret was modified")
+
+// KubernetesSecretSource keeps the state in a Secret, laid out as the local
+// state file is. Accounts are versioned by a hash of their state, and the
+// secret is only replaced if its resource version hasn't changed since it was
+// read.
+type KubernetesSecretSource struct {
+	documentSource
+	leases typedcoordinationv1.LeaseInterface
+	name   string
+}
+
+// NewKubernetesSecretSource creates a source keeping the state in the secret
+// called name, encrypted with cipher unless it's nil. The secret is created
+// on first write. The source is locked by a lease of the same name in leases,
+// so it can't be locked if leases is nil.
+func NewKubernetesSecretSource(
+	secrets typedcorev1.SecretInterface,
+	leases typedcoordinationv1.LeaseInterface,
+	name string,
+	cipher Cipher,
+) (KubernetesSecretSource, error) {
+	if name == "" {
+		return KubernetesSecretSource{}, errors.New("secret name is empty")
+	}
+	return KubernetesSecretSource{
+		documentSource{
+			secretStore{secrets, name, cipher}, errSecretModified,
+			accountHashVersion,
+		},
+		leases, name,
+	}, nil
+}
+
+// secretStore keeps the document in a secret, versioned by the secret as it
+// was read.
+type secretStore struct {
+	secrets typedcorev1.SecretInterface
+	name    string
+	cipher  Cipher
+}
+
+func (store secretStore) readDocument(
+	ctx context.Context,
+) (document, interface{}, error) {
+	secret, err := store.secrets.Get(ctx, store.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return document{}, nil, nil
+	} else if err != nil {
+		return document{}, nil, err
+	}
+
+	marshaled, ok := secret.Data[SecretKey]
+	if !ok {
+		return document{}, secret, nil
+	}
+	doc, err := openDocument(marshaled, store.cipher)
+	return doc, secret, err
+}
+
+func (store secretStore) writeDocument(
+	ctx context.Context, doc document, version interface{},
+) (interface{}, error) {
+	marshaled, err := sealDocument(doc, store.cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, _ := version.(*corev1.Secret)
+	if secret == nil {
+		created, err := store.secrets.Create(
+			ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: store.name,
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "certforgot",
+					},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: map[string][]byte{SecretKey: marshaled},
+			}, metav1.CreateOptions{},
+		)
+		if apierrors.IsAlreadyExists(err) {
+			return nil, errSecretModified
+		} else if err != nil {
+			return nil, err
+		}
+		return created, nil
+	}
+
+	// other keys in the secret are left alone
+	updated := secret.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string][]byte{}
+	}
+	updated.Data[SecretKey] = marshaled
+	updated, err = store.secrets.Update(ctx, updated, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return nil, errSecretModified
+	} else if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// TryLock takes the lease named after the secret.
+func (source KubernetesSecretSource) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	if source.leases == nil {
+		return nil, errors.New("no lease client")
+	}
+	return tryLease(ctx, kubernetesLeaseStore{source.leases, source.name}, ttl)
+}
+
+type kubernetesLeaseStore struct {
+	leases typedcoordinationv1.LeaseInterface
+	name   string
+}
+
+// readLease versions the lease by the Lease itself, which is only replaced if
+// its resource version hasn't changed since it was read.
+func (store kubernetesLeaseStore) readLease(
+	ctx context.Context,
+) (lease, interface{}, error) {
+	current, err := store.leases.Get(ctx, store.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return lease{}, nil, nil
+	} else if err != nil {
+		return lease{}, nil, err
+	}
+
+	var l lease
+	spec := current.Spec
+	if spec.HolderIdentity != nil && spec.RenewTime != nil &&
+		spec.LeaseDurationSeconds != nil {
+		l.Owner = *spec.HolderIdentity
+		l.ExpiresAt = spec.RenewTime.Add(
+			time.Duration(*spec.LeaseDurationSeconds) * time.Second,
+		)
+	}
+	return l, current, nil
+}
+
+func (store kubernetesLeaseStore) writeLease(
+	ctx context.Context, l lease, version interface{},
+) (interface{}, error) {
+	var spec coordinationv1.LeaseSpec
+	if l.Owner != "" {
+		now := time.Now()
+		seconds := int32(math.Ceil(l.ExpiresAt.Sub(now).Seconds()))
+		spec = coordinationv1.LeaseSpec{
+			HolderIdentity:       &l.Owner,
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &metav1.MicroTime{Time: now},
+		}
+	}
+
+	current, _ := version.(*coordinationv1.Lease)
+	if current == nil {
+		created, err := store.leases.Create(
+			ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: store.name,
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "certforgot",
+					},
+				},
+				Spec: spec,
+			}, metav1.CreateOptions{},
+		)
+		if apierrors.IsAlreadyExists(err) {
+			return nil, errLeaseModified
+		} else if err != nil {
+			return nil, err
+		}
+		return created, nil
+	}
+
+	updated := current.DeepCopy()
+	updated.Spec = spec
+	updated, err := store.leases.Update(ctx, updated, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return nil, errLeaseModified
+	} else if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,295 @@
+package state
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesSecretSource(t *testing.T) {
+	const (
+		namespace  = "certforgot"
+		secretName = "certforgot-state"
+	)
+	ctx := context.Background()
+	newSource := func(t *testing.T, objects ...runtime.Object) (
+		KubernetesSecretSource, *fake.Clientset,
+	) {
+		clientset := fake.NewSimpleClientset(objects...)
+		source, err := NewKubernetesSecretSource(
+			clientset.CoreV1().Secrets(namespace),
+			clientset.CoordinationV1().Leases(namespace), secretName, nil,
+		)
+		assert.Nil(t, err)
+		return source, clientset
+	}
+	existingSecret := func(t *testing.T) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+			Data: map[string][]byte{
+				SecretKey: existingDocument(t),
+				"other":   []byte("kept"),
+			},
+		}
+	}
+	conflictOn := func(clientset *fake.Clientset, verb string) {
+		clientset.PrependReactor(
+			verb, "secrets",
+			func(k8stesting.Action) (bool, runtime.Object, error) {
+				resource := schema.GroupResource{Resource: "secrets"}
+				if verb == "create" {
+					return true, nil, apierrors.NewAlreadyExists(resource, secretName)
+				}
+				return true, nil, apierrors.NewConflict(
+					resource, secretName, nil,
+				)
+			},
+		)
+	}
+
+	t.Run(
+		"NewKubernetesSecretSource", func(t *testing.T) {
+			assert.Implements(t, (*Source)(nil), new(KubernetesSecretSource))
+			assert.Implements(t, (*Locker)(nil), new(KubernetesSecretSource))
+
+			_, err := NewKubernetesSecretSource(
+				fake.NewSimpleClientset().CoreV1().Secrets(namespace), nil, "",
+				nil,
+			)
+			assert.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy", func(t *testing.T) {
+			legacy := existingSecret(t)
+			legacy.Data[SecretKey] = legacyDocument(t)
+			source, clientset := newSource(t, legacy)
+			assert.Implements(t, (*LegacyAdopter)(nil), source)
+
+			adopted, err := source.AdoptLegacy(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, adopted)
+			secret, err := clientset.CoreV1().
+				Secrets(namespace).
+				Get(ctx, secretName, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.Equal(t, existingDocument(t), secret.Data[SecretKey])
+
+			adopted, err = source.AdoptLegacy(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, adopted)
+		},
+	)
+
+	t.Run(
+		"Exists", func(t *testing.T) {
+			source, _ := newSource(t)
+			exists, err := source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+
+			source, _ = newSource(t, existingSecret(t))
+			exists, err = source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+			exists, err = source.Exists(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+		},
+	)
+
+	t.Run(
+		"Update (new)", func(t *testing.T) {
+			source, clientset := newSource(t)
+
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			expected, err := hashVersion(existingState(t))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, version)
+
+			secret, err := clientset.CoreV1().
+				Secrets(namespace).
+				Get(ctx, secretName, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.Equal(t, existingDocument(t), secret.Data[SecretKey])
+			assert.Equal(
+				t, "certforgot", secret.Labels["app.kubernetes.io/managed-by"],
+			)
+		},
+	)
+
+	t.Run(
+		"Update (existing)", func(t *testing.T) {
+			source, clientset := newSource(t, existingSecret(t))
+			_, version, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+
+			other := existingState(t)
+			other.UserEmail.Address = &mail.Address{Address: "other@example.com"}
+			_, err = source.Update(ctx, otherKey, other, NoVersion)
+			assert.Nil(t, err)
+			changed := existingState(t)
+			changed.UserEmail.Name = "Firstname Lastname"
+			_, err = source.Update(ctx, testKey, changed, version)
+			assert.Nil(t, err)
+
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey, otherKey}, keys)
+			result, _, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, changed, result)
+
+			secret, err := clientset.CoreV1().
+				Secrets(namespace).
+				Get(ctx, secretName, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("kept"), secret.Data["other"])
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			changed := existingState(t)
+			changed.UserEmail.Name = "Firstname Lastname"
+			stale, err := hashVersion(changed)
+			assert.Nil(t, err)
+			current, err := hashVersion(existingState(t))
+			assert.Nil(t, err)
+
+			tests := []struct {
+				name     string
+				key      AccountKey
+				expected Version
+				conflict string
+			}{
+				{"stale version", testKey, stale, ""},
+				{"already stored", testKey, NoVersion, ""},
+				{"not stored", otherKey, stale, ""},
+				{"modified while writing", testKey, current, "update"},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						source, clientset := newSource(t, existingSecret(t))
+						if tt.conflict != "" {
+							conflictOn(clientset, tt.conflict)
+						}
+
+						_, err := source.Update(ctx, tt.key, changed, tt.expected)
+						var conflict *ConflictError
+						assert.ErrorAs(t, err, &conflict)
+					},
+				)
+			}
+
+			// the secret was created by something else first
+			source, clientset := newSource(t)
+			conflictOn(clientset, "create")
+			_, err = source.Update(ctx, testKey, changed, NoVersion)
+			var conflict *ConflictError
+			assert.ErrorAs(t, err, &conflict)
+		},
+	)
+
+	t.Run(
+		"Get", func(t *testing.T) {
+			source, _ := newSource(t, existingSecret(t))
+
+			result, version, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+			assert.NotEqual(t, NoVersion, version)
+
+			_, _, err = source.Get(ctx, otherKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
+		},
+	)
+
+	t.Run(
+		"Encrypted", func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			cipher, err := newScryptCipher("correct horse", testWorkFactor)
+			assert.Nil(t, err)
+			source, err := NewKubernetesSecretSource(
+				clientset.CoreV1().Secrets(namespace),
+				clientset.CoordinationV1().Leases(namespace), secretName, cipher,
+			)
+			assert.Nil(t, err)
+
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			secret, err := clientset.CoreV1().
+				Secrets(namespace).
+				Get(ctx, secretName, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.NotContains(t, string(secret.Data[SecretKey]), "test@example.com")
+
+			result, _, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+		},
+	)
+
+	t.Run(
+		"History", func(t *testing.T) {
+			source, _ := newSource(t)
+			testSourceHistory(t, source)
+		},
+	)
+
+	t.Run(
+		"TryLock", func(t *testing.T) {
+			source, clientset := newSource(t)
+			testLeaseLocker(t, source)
+
+			lease, err := clientset.CoordinationV1().
+				Leases(namespace).
+				Get(ctx, secretName, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.Nil(t, lease.Spec.HolderIdentity)
+		},
+	)
+
+	t.Run(
+		"TryLock (taken while locking)", func(t *testing.T) {
+			source, clientset := newSource(t)
+			clientset.PrependReactor(
+				"create", "leases",
+				func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewAlreadyExists(
+						schema.GroupResource{Resource: "leases"}, secretName,
+					)
+				},
+			)
+
+			lock, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, lock)
+		},
+	)
+
+	t.Run(
+		"TryLock (no lease client)", func(t *testing.T) {
+			source, err := NewKubernetesSecretSource(
+				fake.NewSimpleClientset().CoreV1().Secrets(namespace), nil,
+				secretName, nil,
+			)
+			assert.Nil(t, err)
+			_, err = source.TryLock(ctx, time.Minute)
+			assert.Error(t, err)
+		},
+	)
+}
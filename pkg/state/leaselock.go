@@ -0,0 +1,101 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// lease is a lock that's held by its owner until it expires. A lease with no
+// owner is free.
+type lease struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// errLeaseModified is returned when writing a lease that was changed since
+// it was read.
+var errLeaseModified = errors.New("lease was modified")
+
+// leaseStore keeps a lease in something that can be written conditionally,
+// for sources with no locks of their own.
+type leaseStore interface {
+	// readLease returns the lease and whatever the store versions it by, or a
+	// free lease and a nil version if there's none yet.
+	readLease(ctx context.Context) (lease, interface{}, error)
+	// writeLease replaces the lease if it's still at version, or creates it
+	// if version is nil, returning the new version. It returns
+	// errLeaseModified if neither is the case.
+	writeLease(
+		ctx context.Context, l lease, version interface{},
+	) (interface{}, error)
+}
+
+// newLockOwner identifies a lock's holder.
+func newLockOwner() (string, error) {
+	ownerBytes := make([]byte, 16)
+	if _, err := rand.Read(ownerBytes); err != nil {
+		return "", fmt.Errorf("generating lock owner: %v", err)
+	}
+	return hex.EncodeToString(ownerBytes), nil
+}
+
+// tryLease takes the lease in store if it's free or has expired.
+func tryLease(
+	ctx context.Context, store leaseStore, ttl time.Duration,
+) (Lock, error) {
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	current, version, err := store.readLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current.Owner != "" && time.Now().Before(current.ExpiresAt) {
+		return nil, nil
+	}
+
+	version, err = store.writeLease(
+		ctx, lease{owner, time.Now().Add(ttl)}, version,
+	)
+	if errors.Is(err, errLeaseModified) {
+		// someone else took it first
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &storedLease{store, owner, ttl, version}, nil
+}
+
+// storedLease is a held lease. Nothing else writes the lease while it's
+// held, so it's been lost if it was modified.
+type storedLease struct {
+	store   leaseStore
+	owner   string
+	ttl     time.Duration
+	version interface{}
+}
+
+func (held *storedLease) Renew(ctx context.Context) error {
+	return held.write(ctx, lease{held.owner, time.Now().Add(held.ttl)})
+}
+
+func (held *storedLease) Unlock(ctx context.Context) error {
+	return held.write(ctx, lease{})
+}
+
+func (held *storedLease) write(ctx context.Context, l lease) error {
+	version, err := held.store.writeLease(ctx, l, held.version)
+	if errors.Is(err, errLeaseModified) {
+		return errors.New("lease expired and was taken")
+	} else if err != nil {
+		return err
+	}
+	held.version = version
+	return nil
+}
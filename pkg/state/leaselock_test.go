@@ -0,0 +1,104 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryLeaseStore versions its lease by the number of times it's been
+// written.
+type memoryLeaseStore struct {
+	lease   lease
+	version int
+}
+
+func (store *memoryLeaseStore) readLease(
+	ctx context.Context,
+) (lease, interface{}, error) {
+	if store.version == 0 {
+		return lease{}, nil, nil
+	}
+	return store.lease, store.version, nil
+}
+
+func (store *memoryLeaseStore) writeLease(
+	ctx context.Context, l lease, version interface{},
+) (interface{}, error) {
+	current, _ := version.(int)
+	if current != store.version {
+		return nil, errLeaseModified
+	}
+	store.lease = l
+	store.version++
+	return store.version, nil
+}
+
+func TestTryLease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run(
+		"Held", func(t *testing.T) {
+			store := &memoryLeaseStore{}
+			lock, err := tryLease(ctx, store, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, lock)
+
+			other, err := tryLease(ctx, store, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, other)
+
+			assert.Nil(t, lock.Renew(ctx))
+			assert.Nil(t, lock.Unlock(ctx))
+			assert.Equal(t, lease{}, store.lease)
+		},
+	)
+
+	t.Run(
+		"Expired", func(t *testing.T) {
+			store := &memoryLeaseStore{}
+			lock, err := tryLease(ctx, store, -time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, lock)
+
+			other, err := tryLease(ctx, store, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, other)
+
+			// the expired lease was taken
+			assert.Error(t, lock.Renew(ctx))
+			assert.Error(t, lock.Unlock(ctx))
+			assert.Nil(t, other.Renew(ctx))
+		},
+	)
+}
+
+// testLeaseLocker checks locker's lease can only be held once at a time.
+func testLeaseLocker(t *testing.T, locker Locker) {
+	ctx := context.Background()
+	lock, err := locker.TryLock(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, lock)
+
+	other, err := locker.TryLock(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, other)
+
+	assert.Nil(t, lock.Renew(ctx))
+	assert.Nil(t, lock.Unlock(ctx))
+
+	other, err = locker.TryLock(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, other)
+	assert.Nil(t, other.Unlock(ctx))
+
+	expired, err := locker.TryLock(ctx, -time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, expired)
+	other, err = locker.TryLock(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, other)
+	assert.Nil(t, other.Unlock(ctx))
+}
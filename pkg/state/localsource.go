@@ -6,60 +6,195 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 type LocalSource struct {
 	directory string
+	cipher    Cipher
 }
 
 const (
 	FileName = "certforgot_state.yaml"
 )
 
-func NewLocalSource(directory string) (LocalSource, error) {
+// NewLocalSource creates a source keeping the state in directory, encrypted
+// with cipher unless it's nil.
+func NewLocalSource(directory string, cipher Cipher) (LocalSource, error) {
 	if err := os.MkdirAll(directory, 0755); err != nil {
 		return LocalSource{}, err
 	}
-	return LocalSource{directory}, nil
+	return LocalSource{directory, cipher}, nil
 }
 
-func (source LocalSource) Update(ctx context.Context, state State) error {
-	marshaledState, err := yaml.Marshal(&state)
+// Update versions the account by a hash of its state.
+func (source LocalSource) Update(
+	ctx context.Context, key AccountKey, state State, expected Version,
+) (Version, error) {
+	doc, err := source.read()
 	if err != nil {
-		return err
+		return NoVersion, err
+	}
+
+	current, err := doc.hashVersion(key)
+	if err != nil {
+		return NoVersion, err
+	}
+	if current != expected {
+		return NoVersion, &ConflictError{key, expected}
 	}
 
-	return ioutil.WriteFile(source.statePath(), marshaledState, 0644)
+	doc.set(key, state)
+	if err := source.write(doc); err != nil {
+		return NoVersion, err
+	}
+	return hashVersion(state)
 }
 
-func (source LocalSource) Get(ctx context.Context) (State, error) {
-	s := State{}
+func (source LocalSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	doc, err := source.read()
+	if err != nil {
+		return State{}, NoVersion, err
+	}
+
+	s, ok := doc.get(key)
+	if !ok {
+		return State{}, NoVersion, ErrNoAccount
+	}
+	version, err := hashVersion(s)
+	return s, version, err
+}
 
-	marshaledState, err := ioutil.ReadFile(source.statePath())
+func (source LocalSource) Exists(ctx context.Context, key AccountKey) (bool, error) {
+	doc, err := source.read()
+	if err != nil {
+		return false, err
+	}
+	_, ok := doc.get(key)
+	return ok, nil
+}
+
+func (source LocalSource) List(ctx context.Context) ([]AccountKey, error) {
+	doc, err := source.read()
+	if err != nil {
+		return nil, err
+	}
+	return doc.keys(), nil
+}
+
+func (source LocalSource) AdoptLegacy(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	doc, err := source.read()
 	if err != nil {
-		return s, err
+		return false, err
+	}
+	if !doc.adoptLegacy(key) {
+		return false, nil
 	}
+	return true, source.write(doc)
+}
 
-	if err := yaml.Unmarshal(marshaledState, &s); err != nil {
-		return s, err
+func (source LocalSource) RecordIssuance(
+	ctx context.Context, issuance Issuance,
+) error {
+	doc, err := source.read()
+	if err != nil {
+		return err
 	}
+	doc.History = append(doc.History, issuance)
+	return source.write(doc)
+}
 
-	return s, nil
+func (source LocalSource) History(
+	ctx context.Context, query HistoryQuery,
+) ([]Issuance, error) {
+	doc, err := source.read()
+	if err != nil {
+		return nil, err
+	}
+	return query.filter(doc.History), nil
 }
 
-func (source LocalSource) Exists(ctx context.Context) (bool, error) {
-	_, err := os.Stat(source.statePath())
+// read returns the state file's contents, or an empty document if there's no
+// state file yet.
+func (source LocalSource) read() (document, error) {
+	marshaledState, err := ioutil.ReadFile(source.statePath())
 	if errors.Is(err, os.ErrNotExist) {
-		return false, nil
+		return document{}, nil
 	} else if err != nil {
-		return false, err
-	} else {
-		return true, nil
+		return document{}, err
 	}
+	return openDocument(marshaledState, source.cipher)
+}
+
+// write renames a new state file over the old one, so it's never left half
+// written. Temp files are only readable by their owner, as the state holds
+// the account keys.
+func (source LocalSource) write(doc document) error {
+	marshaledState, err := sealDocument(doc, source.cipher)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(source.directory, FileName+".*")
+	if err != nil {
+		return err
+	}
+	// fails harmlessly once the file is renamed
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(marshaledState); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), source.statePath())
 }
 
 func (source LocalSource) statePath() string {
 	return path.Join(source.directory, FileName)
 }
+
+const LockFileName = "certforgot.lock"
+
+type localLock struct {
+	file *os.File
+}
+
+// TryLock takes an flock on a lock file next to the state. The lock is held
+// until unlocked or the process exits, so ttl isn't needed.
+func (source LocalSource) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	file, err := os.OpenFile(
+		path.Join(source.directory, LockFileName), os.O_CREATE|os.O_RDWR, 0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryFlock(file)
+	if err != nil || !locked {
+		file.Close()
+		return nil, err
+	}
+	return localLock{file}, nil
+}
+
+func (lock localLock) Renew(ctx context.Context) error {
+	return nil
+}
+
+func (lock localLock) Unlock(ctx context.Context) error {
+	defer lock.file.Close()
+	return unflock(lock.file)
+}
@@ -0,0 +1,119 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	LockName = "certforgot"
+
+	DefaultLockTtl = time.Minute
+
+	lockRetryInterval = time.Second
+	// some backends cap the ttl, so locks are renewed at least this often
+	maxRenewInterval = 15 * time.Second
+)
+
+// ErrLockLost is returned when releasing a held lock that could not be
+// renewed.
+var ErrLockLost = errors.New("lock lost")
+
+// Lock is a held lock.
+type Lock interface {
+	// Renew extends the lock by its ttl, failing if it has been lost.
+	Renew(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+// Locker is implemented by sources that can lock their state against every
+// other certforgot instance using it.
+type Locker interface {
+	// TryLock acquires the lock if it's free, returning nil if it isn't. The
+	// lock expires after ttl unless renewed, or sooner if its holder dies.
+	TryLock(ctx context.Context, ttl time.Duration) (Lock, error)
+}
+
+// Hold waits until locker's lock is acquired and renews it until release is
+// called. The returned context is cancelled if the lock is lost.
+func Hold(
+	ctx context.Context, locker Locker, ttl time.Duration,
+) (context.Context, func() error, error) {
+	lock, err := acquire(ctx, locker, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	heldCtx, cancel := context.WithCancel(ctx)
+	lost := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		interval := ttl / 3
+		if interval > maxRenewInterval {
+			interval = maxRenewInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heldCtx.Done():
+				return
+			case <-ticker.C:
+				if err := lock.Renew(heldCtx); err != nil {
+					if heldCtx.Err() == nil {
+						lost <- err
+						cancel()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	release := func() error {
+		cancel()
+		<-stopped
+		select {
+		case err := <-lost:
+			return fmt.Errorf("%w: %v", ErrLockLost, err)
+		default:
+		}
+
+		// the caller's context may be done, but the lock should still go
+		unlockCtx, unlockCancel := context.WithTimeout(
+			context.Background(), ttl,
+		)
+		defer unlockCancel()
+		if err := lock.Unlock(unlockCtx); err != nil {
+			return fmt.Errorf("unlocking: %v", err)
+		}
+		return nil
+	}
+	return heldCtx, release, nil
+}
+
+func acquire(
+	ctx context.Context, locker Locker, ttl time.Duration,
+) (Lock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid lock ttl %v", ttl)
+	}
+	for {
+		lock, err := locker.TryLock(ctx, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("acquiring lock: %v", err)
+		}
+		if lock != nil {
+			return lock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for lock: %v", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
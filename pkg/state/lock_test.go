@@ -0,0 +1,118 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLocker struct {
+	mutex    sync.Mutex
+	held     bool
+	attempts int
+	renewErr error
+}
+
+func (locker *fakeLocker) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+	locker.attempts++
+	if locker.held {
+		return nil, nil
+	}
+	locker.held = true
+	return fakeLock{locker}, nil
+}
+
+type fakeLock struct {
+	locker *fakeLocker
+}
+
+func (lock fakeLock) Renew(ctx context.Context) error {
+	lock.locker.mutex.Lock()
+	defer lock.locker.mutex.Unlock()
+	return lock.locker.renewErr
+}
+
+func (lock fakeLock) Unlock(ctx context.Context) error {
+	lock.locker.mutex.Lock()
+	defer lock.locker.mutex.Unlock()
+	lock.locker.held = false
+	return nil
+}
+
+func TestHold(t *testing.T) {
+	t.Run(
+		"Acquires and releases", func(t *testing.T) {
+			locker := &fakeLocker{}
+			ctx, release, err := Hold(context.Background(), locker, time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, locker.held)
+			assert.Nil(t, ctx.Err())
+
+			assert.Nil(t, release())
+			assert.False(t, locker.held)
+			assert.Error(t, ctx.Err())
+		},
+	)
+
+	t.Run(
+		"Waits for release", func(t *testing.T) {
+			locker := &fakeLocker{held: true}
+			go func() {
+				time.Sleep(lockRetryInterval / 2)
+				locker.mutex.Lock()
+				locker.held = false
+				locker.mutex.Unlock()
+			}()
+
+			_, release, err := Hold(context.Background(), locker, time.Minute)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, locker.attempts)
+			assert.Nil(t, release())
+		},
+	)
+
+	t.Run(
+		"Cancelled while waiting", func(t *testing.T) {
+			locker := &fakeLocker{held: true}
+			ctx, cancel := context.WithTimeout(
+				context.Background(), lockRetryInterval/2,
+			)
+			defer cancel()
+
+			_, _, err := Hold(ctx, locker, time.Minute)
+			assert.Error(t, err)
+		},
+	)
+
+	t.Run(
+		"Lost", func(t *testing.T) {
+			locker := &fakeLocker{renewErr: errors.New("expired")}
+			ctx, release, err := Hold(
+				context.Background(), locker, 30*time.Millisecond,
+			)
+			assert.Nil(t, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("context not cancelled when lock lost")
+			}
+			assert.ErrorIs(t, release(), ErrLockLost)
+		},
+	)
+
+	t.Run(
+		"Invalid ttl", func(t *testing.T) {
+			_, _, err := Hold(context.Background(), &fakeLocker{}, 0)
+			assert.Error(t, err)
+		},
+	)
+}
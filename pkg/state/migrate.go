@@ -0,0 +1,146 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// ErrMigrationConflict is returned when migrating an account to a source that
+// already holds a different state for it.
+var ErrMigrationConflict = errors.New("destination holds a different account")
+
+// MigrationResult is what was copied by Migrate.
+type MigrationResult struct {
+	Accounts []AccountKey
+	// Issuances is how many issuances were copied, which is none if the
+	// destination already had a history.
+	Issuances int
+}
+
+// Migrate copies every account from one source to another, reading each back
+// to check it round trips, then copies the history if the destination has
+// none. Accounts the destination already holds are left as they are if they
+// match, and are a conflict if not.
+func Migrate(ctx context.Context, from, to Source) (MigrationResult, error) {
+	result := MigrationResult{}
+	keys, err := from.List(ctx)
+	if err != nil {
+		return result, fmt.Errorf("listing accounts: %v", err)
+	}
+
+	for _, key := range keys {
+		if err := migrateAccount(ctx, from, to, key); err != nil {
+			return result, fmt.Errorf("account %s: %w", key, err)
+		}
+		result.Accounts = append(result.Accounts, key)
+	}
+
+	existing, err := to.History(ctx, HistoryQuery{Limit: 1})
+	if err != nil {
+		return result, fmt.Errorf("reading destination history: %v", err)
+	}
+	if len(existing) > 0 {
+		return result, nil
+	}
+	history, err := from.History(ctx, HistoryQuery{})
+	if err != nil {
+		return result, fmt.Errorf("reading history: %v", err)
+	}
+	for _, issuance := range history {
+		if err := to.RecordIssuance(ctx, issuance); err != nil {
+			return result, fmt.Errorf("recording issuance: %v", err)
+		}
+		result.Issuances++
+	}
+	return result, nil
+}
+
+func migrateAccount(ctx context.Context, from, to Source, key AccountKey) error {
+	state, _, err := from.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("reading: %v", err)
+	}
+
+	exists, err := to.Exists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("checking destination: %v", err)
+	}
+	if exists {
+		existing, _, err := to.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reading destination: %v", err)
+		}
+		if err := verifyState(state, existing); err != nil {
+			return fmt.Errorf("%w: %v", ErrMigrationConflict, err)
+		}
+		return nil
+	}
+
+	if _, err := to.Update(ctx, key, state, NoVersion); err != nil {
+		return fmt.Errorf("writing: %v", err)
+	}
+	written, _, err := to.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("reading back: %v", err)
+	}
+	if err := verifyState(state, written); err != nil {
+		return fmt.Errorf("verifying: %v", err)
+	}
+	return nil
+}
+
+// verifyState checks that actual has the same email and key as expected. The
+// private key must come back whole, as an account can't be used without it.
+func verifyState(expected, actual State) error {
+	if expected.UserEmail.String() != actual.UserEmail.String() {
+		return fmt.Errorf(
+			"email is %s, expected %s", actual.UserEmail, expected.UserEmail,
+		)
+	}
+
+	expectedKey, actualKey := expected.UserPrivateKey.Key, actual.UserPrivateKey.Key
+	if actualKey == nil {
+		return errors.New("key is missing")
+	}
+	expectedThumbprint, err := expectedKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	actualThumbprint, err := actualKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expectedThumbprint, actualThumbprint) {
+		return errors.New("key differs")
+	}
+	if isPublicKey(actualKey) && !isPublicKey(expectedKey) {
+		return errors.New("only the public key was given back")
+	}
+
+	expectedJson, err := json.Marshal(expectedKey)
+	if err != nil {
+		return err
+	}
+	actualJson, err := json.Marshal(actualKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expectedJson, actualJson) {
+		return errors.New("private key differs")
+	}
+	return nil
+}
+
+func isPublicKey(key jwk.Key) bool {
+	switch key.(type) {
+	case jwk.RSAPublicKey, jwk.ECDSAPublicKey, jwk.OKPPublicKey:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"net/mail"
+	"path"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+// publicSource gives back only the public part of keys, as Key Vault keys do.
+type publicSource struct {
+	Source
+}
+
+func (source publicSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	state, version, err := source.Source.Get(ctx, key)
+	if err != nil {
+		return state, version, err
+	}
+	publicKey, err := jwk.PublicKeyOf(state.UserPrivateKey.Key)
+	if err != nil {
+		return state, version, err
+	}
+	state.UserPrivateKey = Jwk{publicKey}
+	return state, version, nil
+}
+
+func rsaState(t *testing.T, email string) State {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	key, err := jwk.New(privateKey)
+	assert.Nil(t, err)
+	return NewState(&mail.Address{Address: email}, key)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	newLocal := func(t *testing.T) LocalSource {
+		source, err := NewLocalSource(t.TempDir(), nil)
+		assert.Nil(t, err)
+		return source
+	}
+	newSql := func(t *testing.T) SqlSource {
+		db, err := sql.Open(DriverSqlite, path.Join(t.TempDir(), "state.db"))
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		source, err := NewSqlSource(ctx, DriverSqlite, db)
+		assert.Nil(t, err)
+		return source
+	}
+	populated := func(t *testing.T) (LocalSource, map[AccountKey]State) {
+		source := newLocal(t)
+		states := map[AccountKey]State{
+			testKey:  rsaState(t, "test@example.com"),
+			otherKey: existingState(t),
+		}
+		for key, state := range states {
+			_, err := source.Update(ctx, key, state, NoVersion)
+			assert.Nil(t, err)
+		}
+		for _, issuance := range testIssuances() {
+			assert.Nil(t, source.RecordIssuance(ctx, issuance))
+		}
+		return source, states
+	}
+
+	t.Run(
+		"Local to sql", func(t *testing.T) {
+			from, states := populated(t)
+			to := newSql(t)
+
+			result, err := Migrate(ctx, from, to)
+			assert.Nil(t, err)
+			assert.Len(t, result.Accounts, 2)
+			assert.Equal(t, 3, result.Issuances)
+
+			for key, expected := range states {
+				state, _, err := to.Get(ctx, key)
+				assert.Nil(t, err)
+				assert.Nil(t, verifyState(expected, state))
+			}
+			history, err := to.History(ctx, HistoryQuery{})
+			assert.Nil(t, err)
+			assert.Equal(t, testIssuances(), history)
+
+			// migrating again changes nothing
+			result, err = Migrate(ctx, from, to)
+			assert.Nil(t, err)
+			assert.Len(t, result.Accounts, 2)
+			assert.Equal(t, 0, result.Issuances)
+			history, err = to.History(ctx, HistoryQuery{})
+			assert.Nil(t, err)
+			assert.Len(t, history, 3)
+		},
+	)
+
+	t.Run(
+		"Public key destination", func(t *testing.T) {
+			from := newLocal(t)
+			_, err := from.Update(
+				ctx, testKey, rsaState(t, "test@example.com"), NoVersion,
+			)
+			assert.Nil(t, err)
+
+			result, err := Migrate(ctx, from, publicSource{newLocal(t)})
+			assert.ErrorContains(t, err, "public key")
+			assert.Empty(t, result.Accounts)
+		},
+	)
+
+	t.Run(
+		"Conflict", func(t *testing.T) {
+			from, _ := populated(t)
+			to := newLocal(t)
+			_, err := to.Update(
+				ctx, testKey, rsaState(t, "test@example.com"), NoVersion,
+			)
+			assert.Nil(t, err)
+
+			_, err = Migrate(ctx, from, to)
+			assert.ErrorIs(t, err, ErrMigrationConflict)
+		},
+	)
+
+	t.Run(
+		"Empty", func(t *testing.T) {
+			result, err := Migrate(ctx, newLocal(t), newLocal(t))
+			assert.Nil(t, err)
+			assert.Empty(t, result.Accounts)
+		},
+	)
+}
+
+func TestVerifyState(t *testing.T) {
+	state := rsaState(t, "test@example.com")
+	publicKey, err := jwk.PublicKeyOf(state.UserPrivateKey.Key)
+	assert.Nil(t, err)
+
+	otherEmail := state
+	otherEmail.UserEmail = Email{&mail.Address{Address: "other@example.com"}}
+
+	otherPrivate, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	otherKey, err := jwk.New(otherPrivate)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		actual  State
+		wantErr bool
+	}{
+		{"same", state, false},
+		{"public key", State{state.UserEmail, Jwk{publicKey}}, true},
+		{"other email", otherEmail, true},
+		{"other key", State{state.UserEmail, Jwk{otherKey}}, true},
+		{"missing key", State{state.UserEmail, Jwk{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := verifyState(state, tt.actual)
+				if tt.wantErr {
+					assert.Error(t, err)
+				} else {
+					assert.Nil(t, err)
+				}
+			},
+		)
+	}
+}
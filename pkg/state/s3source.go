@@ -0,0 +1,86 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/s3"
+)
+
+// S3Source keeps the state in an object in an S3-compatible store. Accounts
+// are versioned by the object's ETag, which stores without conditional writes
+// don't check.
+type S3Source struct {
+	documentSource
+	lockClient s3.ObjectClient
+}
+
+// NewS3Source creates a source keeping the state in client's object, encrypted
+// with cipher unless it's nil. The source is locked by a lease kept in
+// lockClient's object, so it can't be locked if lockClient is nil.
+func NewS3Source(
+	client s3.ObjectClient, lockClient s3.ObjectClient, cipher Cipher,
+) (S3Source, error) {
+	return S3Source{
+		documentSource{objectStore{client, cipher}, s3.ErrModified, etagVersion},
+		lockClient,
+	}, nil
+}
+
+// TryLock takes the lease in the lock object. The lease is only safe on stores
+// supporting conditional writes, as the state is.
+func (source S3Source) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	if source.lockClient == nil {
+		return nil, errors.New("no lock object")
+	}
+	return tryLease(ctx, s3LeaseStore{source.lockClient}, ttl)
+}
+
+type s3LeaseStore struct {
+	client s3.ObjectClient
+}
+
+// readLease versions the lease by the object's ETag.
+func (store s3LeaseStore) readLease(
+	ctx context.Context,
+) (lease, interface{}, error) {
+	exists, err := store.client.Exists(ctx)
+	if err != nil {
+		return lease{}, nil, err
+	}
+	if !exists {
+		return lease{}, nil, nil
+	}
+
+	objectBuf, etag, err := store.client.Download(ctx)
+	if err != nil {
+		return lease{}, nil, err
+	}
+	var l lease
+	if err := json.Unmarshal(objectBuf, &l); err != nil {
+		return lease{}, nil, fmt.Errorf("unmarshaling lease: %v", err)
+	}
+	return l, etag, nil
+}
+
+func (store s3LeaseStore) writeLease(
+	ctx context.Context, l lease, version interface{},
+) (interface{}, error) {
+	marshaled, err := json.Marshal(l)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling lease: %v", err)
+	}
+	etag, _ := version.(string)
+	etag, err = store.client.Upload(ctx, marshaled, etag)
+	if errors.Is(err, s3.ErrModified) {
+		return nil, errLeaseModified
+	} else if err != nil {
+		return nil, err
+	}
+	return etag, nil
+}
@@ -0,0 +1,226 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/s3"
+	"github.com/figglewatts/certforgot/pkg/s3/mocks"
+	"github.com/figglewatts/certforgot/pkg/s3/s3test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestS3Source(t *testing.T) {
+	ctx := context.Background()
+	newSource := func(t *testing.T, cipher Cipher) (S3Source, *s3test.Server) {
+		server := s3test.NewServer(t, "bucket")
+		s3test.UseCredentials(t)
+		objectClient := func(key string) s3.ObjectClient {
+			client, err := s3.NewObjectClient(
+				s3.Config{
+					Endpoint:  server.URL,
+					Bucket:    "bucket",
+					Key:       key,
+					PathStyle: true,
+				},
+			)
+			assert.Nil(t, err)
+			return client
+		}
+		source, err := NewS3Source(
+			objectClient(FileName), objectClient(FileName+".lock"), cipher,
+		)
+		assert.Nil(t, err)
+		return source, server
+	}
+
+	t.Run(
+		"NewS3Source", func(t *testing.T) {
+			assert.Implements(t, (*Source)(nil), new(S3Source))
+			assert.Implements(t, (*Locker)(nil), new(S3Source))
+		},
+	)
+
+	t.Run(
+		"Update", func(t *testing.T) {
+			source, server := newSource(t, nil)
+
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			assert.NotEqual(t, NoVersion, version)
+			assert.Equal(t, existingDocument(t), server.Object("bucket", FileName))
+
+			changed := existingState(t)
+			changed.UserEmail.Name = "Firstname Lastname"
+			version, err = source.Update(ctx, testKey, changed, version)
+			assert.Nil(t, err)
+
+			result, current, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, changed, result)
+			assert.Equal(t, version, current)
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			source, _ := newSource(t, nil)
+			first, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, otherKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+
+			tests := []struct {
+				name     string
+				key      AccountKey
+				expected Version
+			}{
+				{"stale version", testKey, first},
+				{"already stored", testKey, NoVersion},
+				{"not stored", AccountKey{Directory: "other"}, first},
+			}
+			for _, tt := range tests {
+				_, err := source.Update(ctx, tt.key, existingState(t), tt.expected)
+				var conflict *ConflictError
+				assert.ErrorAs(t, err, &conflict, tt.name)
+			}
+		},
+	)
+
+	t.Run(
+		"Update (modified while writing)", func(t *testing.T) {
+			client := mocks.NewObjectClient(t)
+			source, err := NewS3Source(client, nil, nil)
+			assert.Nil(t, err)
+
+			client.EXPECT().Exists(ctx).Return(true, nil)
+			client.EXPECT().Download(ctx).Return(existingDocument(t), "etag", nil)
+			client.EXPECT().
+				Upload(ctx, mock.Anything, "etag").
+				Return("", s3.ErrModified)
+
+			_, err = source.Update(ctx, testKey, existingState(t), "etag")
+			var conflict *ConflictError
+			assert.ErrorAs(t, err, &conflict)
+			assert.Equal(t, testKey, conflict.Key)
+		},
+	)
+
+	t.Run(
+		"Update (encrypted)", func(t *testing.T) {
+			cipher, err := newScryptCipher("correct horse", testWorkFactor)
+			assert.Nil(t, err)
+			source, server := newSource(t, cipher)
+
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			assert.NotContains(
+				t, string(server.Object("bucket", FileName)), "test@example.com",
+			)
+
+			result, _, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+		},
+	)
+
+	t.Run(
+		"Get", func(t *testing.T) {
+			source, server := newSource(t, nil)
+			_, _, err := source.Get(ctx, testKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
+
+			etag := server.PutObject("bucket", FileName, existingDocument(t))
+			result, version, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+			assert.Equal(t, Version(etag), version)
+		},
+	)
+
+	t.Run(
+		"Exists", func(t *testing.T) {
+			source, server := newSource(t, nil)
+			exists, err := source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+
+			server.PutObject("bucket", FileName, existingDocument(t))
+			exists, err = source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+		},
+	)
+
+	t.Run(
+		"List", func(t *testing.T) {
+			source, server := newSource(t, nil)
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Empty(t, keys)
+
+			server.PutObject("bucket", FileName, existingDocument(t))
+			keys, err = source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey}, keys)
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy", func(t *testing.T) {
+			source, server := newSource(t, nil)
+			assert.Implements(t, (*LegacyAdopter)(nil), source)
+			server.PutObject("bucket", FileName, legacyDocument(t))
+
+			adopted, err := source.AdoptLegacy(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, adopted)
+			assert.Equal(t, existingDocument(t), server.Object("bucket", FileName))
+
+			adopted, err = source.AdoptLegacy(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, adopted)
+		},
+	)
+
+	t.Run(
+		"History", func(t *testing.T) {
+			source, _ := newSource(t, nil)
+			testSourceHistory(t, source)
+		},
+	)
+
+	t.Run(
+		"RecordIssuance (retries)", func(t *testing.T) {
+			client := mocks.NewObjectClient(t)
+			source, err := NewS3Source(client, nil, nil)
+			assert.Nil(t, err)
+
+			client.EXPECT().Exists(ctx).Return(false, nil)
+			client.EXPECT().
+				Upload(ctx, mock.Anything, "").
+				Return("", s3.ErrModified).Times(maxDocumentWrites - 1)
+			client.EXPECT().Upload(ctx, mock.Anything, "").Return("etag", nil).Once()
+
+			assert.Nil(t, source.RecordIssuance(ctx, testIssuances()[0]))
+		},
+	)
+
+	t.Run(
+		"TryLock", func(t *testing.T) {
+			source, _ := newSource(t, nil)
+			testLeaseLocker(t, source)
+		},
+	)
+
+	t.Run(
+		"TryLock (no lock object)", func(t *testing.T) {
+			source, err := NewS3Source(mocks.NewObjectClient(t), nil, nil)
+			assert.Nil(t, err)
+			_, err = source.TryLock(ctx, time.Minute)
+			assert.Error(t, err)
+		},
+	)
+}
@@ -4,8 +4,20 @@ import (
 	"context"
 )
 
+// Source stores the state of any number of accounts, each under its own key,
+// and the history of issued certificates.
 type Source interface {
-	Update(ctx context.Context, state State) error
-	Get(ctx context.Context) (State, error)
-	Exists(ctx context.Context) (bool, error)
+	// Update stores state for key if what's stored is still at expected,
+	// which is NoVersion if the account isn't stored yet, returning the new
+	// version. It returns a *ConflictError if what's stored has changed.
+	Update(
+		ctx context.Context, key AccountKey, state State, expected Version,
+	) (Version, error)
+	// Get returns ErrNoAccount if the account isn't stored.
+	Get(ctx context.Context, key AccountKey) (State, Version, error)
+	Exists(ctx context.Context, key AccountKey) (bool, error)
+	List(ctx context.Context) ([]AccountKey, error)
+
+	RecordIssuance(ctx context.Context, issuance Issuance) error
+	History(ctx context.Context, query HistoryQuery) ([]Issuance, error)
 }
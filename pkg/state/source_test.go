@@ -8,12 +8,15 @@ import (
 	"net/mail"
 	"os"
 	"path"
+	"runtime"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/figglewatts/certforgot/pkg/azure"
 	"github.com/figglewatts/certforgot/pkg/azure/mocks"
 	"github.com/lestrrat-go/jwx/jwk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,28 +29,181 @@ func existingState(t *testing.T) State {
 	}
 }
 
+var (
+	testKey  = AccountKey{Directory: "https://acme.example.com/directory"}
+	otherKey = AccountKey{
+		Directory: "https://acme.example.com/directory",
+		Email:     "other@example.com",
+	}
+)
+
+func existingDocument(t *testing.T) []byte {
+	doc := document{Accounts: []Account{{testKey, existingState(t)}}}
+	marshaled, err := doc.marshal()
+	assert.Nil(t, err)
+	return marshaled
+}
+
+// legacyDocument is state from before multiple accounts were held.
+func legacyDocument(t *testing.T) []byte {
+	marshaled, err := yaml.Marshal(existingState(t))
+	assert.Nil(t, err)
+	return marshaled
+}
+
 func TestAzureBlobSource(t *testing.T) {
 	mockSource := func(t *testing.T) (*AzureBlobSource, *mocks.BlobClient) {
 		client := mocks.NewBlobClient(t)
-		src, err := NewAzureBlobSource(client)
+		src, err := NewAzureBlobSource(client, nil, nil)
 		assert.Nil(t, err)
 		return &src, client
 	}
 
 	t.Run(
-		"Update", func(t *testing.T) {
+		"Update (new)", func(t *testing.T) {
+			src, client := mockSource(t)
+			ctx := context.Background()
+			state := existingState(t)
+
+			client.EXPECT().Exists(ctx).Return(false, nil)
+			client.EXPECT().
+				Upload(ctx, existingDocument(t), "").
+				Return("etag", nil)
+
+			version, err := src.Update(ctx, testKey, state, NoVersion)
+			assert.Nil(t, err)
+			assert.Equal(t, Version("etag"), version)
+		},
+	)
+
+	t.Run(
+		"Update (other account)", func(t *testing.T) {
 			src, client := mockSource(t)
 			ctx := context.Background()
 			state := existingState(t)
 
-			expectedContents, err := yaml.Marshal(state)
+			expected := document{
+				Accounts: []Account{{testKey, state}, {otherKey, state}},
+			}
+			expectedContents, err := expected.marshal()
 			assert.Nil(t, err)
+			client.EXPECT().Exists(ctx).Return(true, nil)
 			client.EXPECT().
-				Upload(ctx, expectedContents).
-				Return(nil)
+				Download(ctx).
+				Return(existingDocument(t), "etag", nil)
+			client.EXPECT().
+				Upload(ctx, expectedContents, "etag").
+				Return("new-etag", nil)
 
-			err = src.Update(ctx, state)
+			version, err := src.Update(ctx, otherKey, state, NoVersion)
 			assert.Nil(t, err)
+			assert.Equal(t, Version("new-etag"), version)
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy", func(t *testing.T) {
+			src, client := mockSource(t)
+			ctx := context.Background()
+			assert.Implements(t, (*LegacyAdopter)(nil), src)
+
+			client.EXPECT().Exists(ctx).Return(true, nil)
+			client.EXPECT().
+				Download(ctx).
+				Return(legacyDocument(t), "etag", nil)
+			client.EXPECT().
+				Upload(ctx, existingDocument(t), "etag").
+				Return("new-etag", nil)
+
+			adopted, err := src.AdoptLegacy(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, adopted)
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy (no legacy state)", func(t *testing.T) {
+			src, client := mockSource(t)
+			ctx := context.Background()
+
+			client.EXPECT().Exists(ctx).Return(true, nil)
+			client.EXPECT().
+				Download(ctx).
+				Return(existingDocument(t), "etag", nil)
+
+			adopted, err := src.AdoptLegacy(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, adopted)
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			tests := []struct {
+				name      string
+				key       AccountKey
+				expected  Version
+				uploadErr error
+			}{
+				{"stale version", testKey, "old-etag", nil},
+				{"already stored", testKey, NoVersion, nil},
+				{"not stored", otherKey, "etag", nil},
+				{"modified while writing", testKey, "etag", azure.ErrModified},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						src, client := mockSource(t)
+						ctx := context.Background()
+
+						client.EXPECT().Exists(ctx).Return(true, nil)
+						client.EXPECT().
+							Download(ctx).
+							Return(existingDocument(t), "etag", nil)
+						if tt.uploadErr != nil {
+							client.EXPECT().
+								Upload(ctx, mock.Anything, "etag").
+								Return("", tt.uploadErr)
+						}
+
+						_, err := src.Update(ctx, tt.key, existingState(t), tt.expected)
+						var conflict *ConflictError
+						assert.ErrorAs(t, err, &conflict)
+						assert.Equal(t, tt.key, conflict.Key)
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"Update (encrypted)", func(t *testing.T) {
+			client := mocks.NewBlobClient(t)
+			cipher, err := newScryptCipher("correct horse", testWorkFactor)
+			assert.Nil(t, err)
+			src, err := NewAzureBlobSource(client, nil, cipher)
+			assert.Nil(t, err)
+			ctx := context.Background()
+
+			var uploaded []byte
+			client.EXPECT().Exists(ctx).Return(false, nil).Once()
+			client.EXPECT().
+				Upload(ctx, mock.Anything, "").
+				Run(
+					func(ctx context.Context, buffer []byte, etag string) {
+						uploaded = buffer
+					},
+				).
+				Return("etag", nil)
+			_, err = src.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			assert.NotContains(t, string(uploaded), "test@example.com")
+
+			client.EXPECT().Exists(ctx).Return(true, nil)
+			client.EXPECT().Download(ctx).Return(uploaded, "etag", nil)
+			result, _, err := src.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
 		},
 	)
 
@@ -56,16 +212,67 @@ func TestAzureBlobSource(t *testing.T) {
 			src, client := mockSource(t)
 			ctx := context.Background()
 			state := existingState(t)
-			marshaledState, err := yaml.Marshal(state)
-			assert.Nil(t, err)
 
+			client.EXPECT().Exists(ctx).Return(true, nil)
 			client.EXPECT().
 				Download(ctx).
-				Return(marshaledState, nil)
+				Return(existingDocument(t), "etag", nil)
 
-			result, err := src.Get(ctx)
+			result, version, err := src.Get(ctx, testKey)
 			assert.Nil(t, err)
 			assert.Equal(t, state, result)
+			assert.Equal(t, Version("etag"), version)
+
+			_, _, err = src.Get(ctx, otherKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
+		},
+	)
+
+	t.Run(
+		"List", func(t *testing.T) {
+			src, client := mockSource(t)
+			ctx := context.Background()
+
+			client.EXPECT().Exists(ctx).Return(true, nil)
+			client.EXPECT().Download(ctx).Return(existingDocument(t), "etag", nil)
+
+			result, err := src.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey}, result)
+		},
+	)
+	t.Run(
+		"TryLock", func(t *testing.T) {
+			lockClient := mocks.NewBlobClient(t)
+			src, err := NewAzureBlobSource(mocks.NewBlobClient(t), lockClient, nil)
+			assert.Nil(t, err)
+			ctx := context.Background()
+
+			lockClient.EXPECT().
+				AcquireLease(ctx, time.Minute).
+				Return("lease", nil).Once()
+			lockClient.EXPECT().RenewLease(ctx, "lease").Return(nil)
+			lockClient.EXPECT().ReleaseLease(ctx, "lease").Return(nil)
+
+			lock, err := src.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, lock.Renew(ctx))
+			assert.Nil(t, lock.Unlock(ctx))
+
+			lockClient.EXPECT().
+				AcquireLease(ctx, time.Minute).
+				Return("", fmt.Errorf("wrapped: %w", azure.ErrLeased)).Once()
+			lock, err = src.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, lock)
+		},
+	)
+
+	t.Run(
+		"TryLock (no lock blob)", func(t *testing.T) {
+			src, _ := mockSource(t)
+			_, err := src.TryLock(context.Background(), time.Minute)
+			assert.Error(t, err)
 		},
 	)
 }
@@ -79,6 +286,8 @@ func TestAzureKeyVaultSource(t *testing.T) {
 		assert.Nil(t, err)
 		return &src, client
 	}
+	emailSecretName := DefaultEmailSecretName + "-" + vaultSuffix(testKey)
+	keyName := DefaultKeyName + "-" + vaultSuffix(testKey)
 
 	t.Run(
 		"NewAzureKeyVaultSource", func(t *testing.T) {
@@ -86,67 +295,213 @@ func TestAzureKeyVaultSource(t *testing.T) {
 
 			assert.Equal(t, DefaultEmailSecretName, src.config.EmailSecretName)
 			assert.Equal(t, DefaultKeyName, src.config.KeyName)
+			assert.Equal(t, DefaultIndexSecretName, src.config.IndexSecretName)
+		},
+	)
+
+	t.Run(
+		"vaultSuffix", func(t *testing.T) {
+			assert.Regexp(t, "^[0-9a-f]{16}$", vaultSuffix(testKey))
+			assert.NotEqual(t, vaultSuffix(testKey), vaultSuffix(otherKey))
 		},
 	)
 
 	t.Run(
 		"Update", func(t *testing.T) {
+			tests := []struct {
+				name      string
+				current   *azure.Secret
+				expected  Version
+				index     *azure.Secret
+				wantIndex string
+			}{
+				{
+					"new index", nil, NoVersion, nil,
+					`[{"directory":"https://acme.example.com/directory"}]`,
+				},
+				{
+					"already indexed",
+					&azure.Secret{Value: "test@example.com", Version: "v1"}, "v1",
+					&azure.Secret{
+						Value: `[{"directory":"https://acme.example.com/directory"}]`,
+					}, "",
+				},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						src, client := mockSource(t)
+						state := existingState(t)
+						ctx := context.Background()
+
+						client.EXPECT().
+							GetSecret(ctx, emailSecretName, "").
+							Return(tt.current, nil)
+						marshaledKey, err := state.MarshaledPrivateKey()
+						assert.Nil(t, err)
+						client.EXPECT().
+							SetSecret(ctx, keyName, marshaledKey).
+							Return("v1", nil)
+						client.EXPECT().
+							SetSecret(
+								ctx, emailSecretName, state.UserEmail.String(),
+							).
+							Return("v2", nil)
+						client.EXPECT().
+							GetSecret(ctx, DefaultIndexSecretName, "").
+							Return(tt.index, nil)
+						if tt.wantIndex != "" {
+							client.EXPECT().
+								SetSecret(
+									ctx, DefaultIndexSecretName, tt.wantIndex,
+								).
+								Return("v1", nil)
+						}
+
+						version, err := src.Update(ctx, testKey, state, tt.expected)
+						assert.Nil(t, err)
+						assert.Equal(t, Version("v2"), version)
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy", func(t *testing.T) {
+			legacyEmail := &azure.Secret{Value: "<test@example.com>", Version: "v1"}
+			index := &azure.Secret{
+				Value: `[{"directory":"https://acme.example.com/directory"}]`,
+			}
+			tests := []struct {
+				name    string
+				index   *azure.Secret
+				email   *azure.Secret
+				wantErr bool
+			}{
+				{"empty vault", nil, nil, false},
+				// the legacy key can't be read back, so it can't be moved
+				{"legacy account", nil, legacyEmail, true},
+				{"already indexed", index, legacyEmail, false},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						src, client := mockSource(t)
+						ctx := context.Background()
+						assert.Implements(t, (*LegacyAdopter)(nil), src)
+
+						client.EXPECT().
+							GetSecret(ctx, DefaultIndexSecretName, "").
+							Return(tt.index, nil)
+						if tt.index == nil {
+							client.EXPECT().
+								GetSecret(ctx, DefaultEmailSecretName, "").
+								Return(tt.email, nil)
+						}
+
+						adopted, err := src.AdoptLegacy(ctx, testKey)
+						assert.False(t, adopted)
+						if tt.wantErr {
+							assert.ErrorContains(t, err, DefaultEmailSecretName)
+							return
+						}
+						assert.Nil(t, err)
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			tests := []struct {
+				name     string
+				current  *azure.Secret
+				expected Version
+			}{
+				{"stale version", &azure.Secret{Version: "v2"}, "v1"},
+				{"already stored", &azure.Secret{Version: "v1"}, NoVersion},
+				{"not stored", nil, "v1"},
+			}
+			for _, tt := range tests {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						src, client := mockSource(t)
+						ctx := context.Background()
+
+						client.EXPECT().
+							GetSecret(ctx, emailSecretName, "").
+							Return(tt.current, nil)
+
+						_, err := src.Update(ctx, testKey, existingState(t), tt.expected)
+						var conflict *ConflictError
+						assert.ErrorAs(t, err, &conflict)
+						assert.Equal(t, tt.expected, conflict.Expected)
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"Get", func(t *testing.T) {
 			src, client := mockSource(t)
 			state := existingState(t)
 			ctx := context.Background()
 
 			client.EXPECT().
-				SetSecret(
-					ctx, DefaultEmailSecretName, state.UserEmail.String(),
-				).
-				Return(nil)
+				GetSecret(ctx, emailSecretName, "").
+				Return(
+					&azure.Secret{Value: state.UserEmail.String(), Version: "v1"},
+					nil,
+				)
+			marshaledKey, err := state.MarshaledPrivateKey()
+			assert.Nil(t, err)
 			client.EXPECT().
-				ImportKey(ctx, DefaultKeyName, state.UserPrivateKey.Key).
-				Return(nil)
+				GetSecret(ctx, keyName, "").
+				Return(&azure.Secret{Value: marshaledKey}, nil)
+
+			result, version, err := src.Get(ctx, testKey)
 
-			err := src.Update(ctx, state)
 			assert.Nil(t, err)
+			assert.Equal(t, state, result)
+			assert.Equal(t, Version("v1"), version)
 		},
 	)
 
 	t.Run(
-		"Get", func(t *testing.T) {
+		"List", func(t *testing.T) {
 			src, client := mockSource(t)
-			state := existingState(t)
 			ctx := context.Background()
 
-			expectedEmail := state.UserEmail.String()
+			index := `[{"directory":"https://acme.example.com/directory"},` +
+				`{"directory":"https://acme.example.com/directory",` +
+				`"email":"other@example.com"}]`
 			client.EXPECT().
-				GetSecret(ctx, DefaultEmailSecretName, "").
-				Return(&expectedEmail, nil)
-			client.EXPECT().
-				GetKey(ctx, DefaultKeyName, "").
-				Return(state.UserPrivateKey.Key, nil)
-
-			result, err := src.Get(ctx)
+				GetSecret(ctx, DefaultIndexSecretName, "").
+				Return(&azure.Secret{Value: index}, nil)
 
+			result, err := src.List(ctx)
 			assert.Nil(t, err)
-			assert.Equal(t, state, result)
+			assert.Equal(t, []AccountKey{testKey, otherKey}, result)
 		},
 	)
 
 	t.Run(
 		"Exists", func(t *testing.T) {
-			expectedSecret := "secret"
-			expectedKey, err := jwk.New([]byte("test"))
-			if err != nil {
-				t.Fatalf("unexpected error setting up test: %v", err)
-			}
+			expectedSecret := azure.Secret{Value: "secret"}
+			expectedKey := azure.Secret{Value: "key"}
 
 			tests := []struct {
 				name            string
-				getKeyResult    jwk.Key
-				getSecretResult *string
+				getKeyResult    *azure.Secret
+				getSecretResult *azure.Secret
 				expected        bool
 			}{
 				{"no-secret", nil, nil, false},
 				{"no-key", nil, &expectedSecret, false},
-				{"exists", expectedKey, &expectedSecret, true},
+				{"exists", &expectedKey, &expectedSecret, true},
 			}
 
 			for _, test := range tests {
@@ -157,15 +512,15 @@ func TestAzureKeyVaultSource(t *testing.T) {
 						ctx := context.Background()
 
 						client.EXPECT().
-							GetSecret(ctx, DefaultEmailSecretName, "").
+							GetSecret(ctx, emailSecretName, "").
 							Return(test.getSecretResult, nil)
 						if test.getSecretResult != nil {
 							client.EXPECT().
-								GetKey(ctx, DefaultKeyName, "").
+								GetSecret(ctx, keyName, "").
 								Return(test.getKeyResult, nil)
 						}
 
-						result, err := src.Exists(ctx)
+						result, err := src.Exists(ctx, testKey)
 
 						assert.Nil(t, err)
 						assert.Equal(t, test.expected, result)
@@ -177,160 +532,281 @@ func TestAzureKeyVaultSource(t *testing.T) {
 }
 
 func TestSqlSource(t *testing.T) {
-	mockDB := func(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
-		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
-		mock.ExpectPing()
+	openDB := func(t *testing.T, dir string) *sql.DB {
+		db, err := sql.Open(DriverSqlite, path.Join(dir, "state.db"))
 		assert.Nil(t, err)
-		return db, mock
+		t.Cleanup(func() { db.Close() })
+		return db
 	}
 
-	sourceFromDB := func(
-		t *testing.T, db *sql.DB, mock sqlmock.Sqlmock,
-	) SqlSource {
+	newSource := func(t *testing.T) SqlSource {
 		source, err := NewSqlSource(
-			context.Background(), "sqlmock", db,
+			context.Background(), DriverSqlite, openDB(t, t.TempDir()),
 		)
 		assert.Nil(t, err)
 		return source
 	}
 
-	mockExists := func(mock sqlmock.Sqlmock, exists bool) sqlmock.Sqlmock {
-		countResult := 0
-		if exists {
-			countResult = 1
-		}
-		resultRows := sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(countResult)
-		mock.ExpectQuery("SELECT COUNT(.*) FROM certforgot.state").
-			WillReturnRows(resultRows).
-			RowsWillBeClosed()
-		return mock
-	}
-
 	t.Run(
 		"NewSqlSource", func(t *testing.T) {
-			db, mock := mockDB(t)
+			dir := t.TempDir()
+			ctx := context.Background()
 
-			_, err := NewSqlSource(
-				context.Background(), "sqlmock", db,
-			)
+			source, err := NewSqlSource(ctx, DriverSqlite, openDB(t, dir))
 			assert.Nil(t, err)
 			assert.Implements(t, (*Source)(nil), new(SqlSource))
 
-			err = mock.ExpectationsWereMet()
+			version, err := source.Version(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, len(sqlDialects[DriverSqlite].migrations), version)
+
+			// migrating an up to date database does nothing
+			source, err = NewSqlSource(ctx, DriverSqlite, openDB(t, dir))
 			assert.Nil(t, err)
+			again, err := source.Version(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, version, again)
+
+			// as does a migration applied since the version was read, which
+			// would fail if it was run again
+			migrations := sqlDialects[DriverSqlite].migrations
+			assert.Nil(
+				t, source.applyMigration(ctx, version, migrations[version-1]),
+			)
 		},
 	)
 
 	t.Run(
-		"Update (new)", func(t *testing.T) {
-			db, mock := mockDB(t)
-			defer db.Close()
+		"NewSqlSource (unsupported driver)", func(t *testing.T) {
+			_, err := NewSqlSource(
+				context.Background(), "oracle", openDB(t, t.TempDir()),
+			)
+			assert.Error(t, err)
+		},
+	)
 
-			source := sourceFromDB(t, db, mock)
+	t.Run(
+		"Dialects", func(t *testing.T) {
+			for driver, dialect := range sqlDialects {
+				assert.NotEmpty(t, dialect.migrations, driver)
+				assert.NotEmpty(t, dialect.historyTable, driver)
+				// locks are either advisory or leased from a table
+				assert.NotEqual(
+					t, dialect.tryLock == "", dialect.lockTable == "", driver,
+				)
+			}
+			// mysql can't roll back DDL, so a migration can't hold several,
+			// and only a lock keeps instances from migrating at once
+			for _, migration := range sqlDialects[DriverMysql].migrations {
+				assert.Len(t, migration, 1)
+			}
+			assert.NotEmpty(t, sqlDialects[DriverMysql].lockMigrations)
+		},
+	)
 
-			mock = mockExists(mock, false)
+	t.Run(
+		"Migrate (legacy table)", func(t *testing.T) {
+			db := openDB(t, t.TempDir())
+			ctx := context.Background()
 
-			state := existingState(t)
-			mock.ExpectExec("INSERT INTO certforgot.state VALUES (.*)").
-				WithArgs(
-					1, state.UserEmail.String(),
-					"{\"k\":\"dGVzdA\",\"kty\":\"oct\"}",
-				).
-				WillReturnResult(sqlmock.NewResult(1, 1))
+			// a table from before the schema was migrated
+			_, err := db.Exec(
+				"CREATE TABLE certforgot_state (id INTEGER PRIMARY KEY, useremail TEXT, userprivatekey TEXT)",
+			)
+			assert.Nil(t, err)
+			_, err = db.Exec(
+				"INSERT INTO certforgot_state VALUES (1, ?, ?)",
+				"<test@example.com>", "{\"k\":\"dGVzdA\",\"kty\":\"oct\"}",
+			)
+			assert.Nil(t, err)
+
+			source, err := NewSqlSource(ctx, DriverSqlite, db)
+			assert.Nil(t, err)
 
-			err := source.Update(context.Background(), state)
+			keys, err := source.List(ctx)
 			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{{}}, keys)
 
-			err = mock.ExpectationsWereMet()
+			state, version, err := source.Get(ctx, AccountKey{})
 			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), state)
+			assert.Equal(t, Version("1"), version)
+
+			assert.Implements(t, (*LegacyAdopter)(nil), source)
+			adopted, err := source.AdoptLegacy(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, adopted)
+
+			keys, err = source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey}, keys)
+			state, version, err = source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), state)
+			assert.Equal(t, Version("1"), version)
+
+			adopted, err = source.AdoptLegacy(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, adopted)
 		},
 	)
 
 	t.Run(
-		"Update (existing)", func(t *testing.T) {
-			db, mock := mockDB(t)
-			defer db.Close()
-
-			source := sourceFromDB(t, db, mock)
-
-			mock = mockExists(mock, true)
+		"Update (new)", func(t *testing.T) {
+			source := newSource(t)
+			ctx := context.Background()
 
-			state := existingState(t)
-			mock.ExpectExec("UPDATE certforgot.state SET .* WHERE id = ?").
-				WithArgs(
-					state.UserEmail.String(),
-					"{\"k\":\"dGVzdA\",\"kty\":\"oct\"}",
-					1,
-				).
-				WillReturnResult(sqlmock.NewResult(1, 1))
+			exists, err := source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.False(t, exists)
 
-			err := source.Update(context.Background(), state)
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
 			assert.Nil(t, err)
+			assert.Equal(t, Version("1"), version)
 
-			err = mock.ExpectationsWereMet()
+			exists, err = source.Exists(ctx, testKey)
 			assert.Nil(t, err)
+			assert.True(t, exists)
 		},
 	)
 
 	t.Run(
-		"Get", func(t *testing.T) {
-			db, mock := mockDB(t)
-			defer db.Close()
+		"Update (existing)", func(t *testing.T) {
+			source := newSource(t)
+			ctx := context.Background()
 
-			rowResult := sqlmock.NewRows(
-				[]string{
-					"useremail", "userprivatekey",
-				},
-			).AddRow(
-				"<test@example.com>",
-				[]byte("{\"k\":\"dGVzdA\",\"kty\":\"oct\"}"),
-			)
-			mock.ExpectQuery("SELECT .* FROM certforgot.state LIMIT 1").
-				WillReturnRows(rowResult).RowsWillBeClosed()
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
 
 			expected := existingState(t)
-			source := sourceFromDB(t, db, mock)
+			expected.UserEmail.Name = "Firstname Lastname"
+			updated, err := source.Update(ctx, testKey, expected, version)
+			assert.Nil(t, err)
+			assert.NotEqual(t, version, updated)
 
-			state, err := source.Get(context.Background())
+			state, current, err := source.Get(ctx, testKey)
 			assert.Nil(t, err)
 			assert.Equal(t, expected, state)
+			assert.Equal(t, updated, current)
 
-			err = mock.ExpectationsWereMet()
+			keys, err := source.List(ctx)
 			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey}, keys)
 		},
 	)
 
 	t.Run(
-		"Exists", func(t *testing.T) {
-			db, mock := mockDB(t)
-			defer db.Close()
+		"Update (conflict)", func(t *testing.T) {
+			source := newSource(t)
+			ctx := context.Background()
 
-			mock = mockExists(mock, true)
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, testKey, existingState(t), version)
+			assert.Nil(t, err)
 
-			source := sourceFromDB(t, db, mock)
-			result, err := source.Exists(context.Background())
+			tests := []struct {
+				name     string
+				key      AccountKey
+				expected Version
+			}{
+				{"stale version", testKey, version},
+				{"already stored", testKey, NoVersion},
+				{"not stored", otherKey, version},
+			}
+			for _, tt := range tests {
+				_, err := source.Update(ctx, tt.key, existingState(t), tt.expected)
+				var conflict *ConflictError
+				assert.ErrorAs(t, err, &conflict, tt.name)
+			}
+		},
+	)
+
+	t.Run(
+		"Get", func(t *testing.T) {
+			source := newSource(t)
+			ctx := context.Background()
+
+			_, err := source.Update(ctx, testKey, existingState(t), NoVersion)
 			assert.Nil(t, err)
-			assert.Equal(t, true, result)
 
-			err = mock.ExpectationsWereMet()
+			state, _, err := source.Get(ctx, testKey)
 			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), state)
+
+			_, _, err = source.Get(ctx, otherKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
 		},
 	)
 
 	t.Run(
-		"Not exists", func(t *testing.T) {
-			db, mock := mockDB(t)
-			defer db.Close()
+		"Multiple accounts", func(t *testing.T) {
+			source := newSource(t)
+			ctx := context.Background()
+			other := existingState(t)
+			other.UserEmail.Address = &mail.Address{Address: "other@example.com"}
 
-			mock = mockExists(mock, false)
+			_, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, otherKey, other, NoVersion)
+			assert.Nil(t, err)
 
-			source := sourceFromDB(t, db, mock)
-			result, err := source.Exists(context.Background())
+			keys, err := source.List(ctx)
 			assert.Nil(t, err)
-			assert.Equal(t, false, result)
+			assert.Equal(t, []AccountKey{testKey, otherKey}, keys)
 
-			err = mock.ExpectationsWereMet()
+			state, _, err := source.Get(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.Equal(t, other, state)
+			state, _, err = source.Get(ctx, testKey)
 			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), state)
+		},
+	)
+}
+
+func TestSqlSource_TryLock(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open(DriverSqlite, path.Join(t.TempDir(), "state.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	source, err := NewSqlSource(ctx, DriverSqlite, db)
+	assert.Nil(t, err)
+	assert.Implements(t, (*Locker)(nil), new(SqlSource))
+
+	t.Run(
+		"Held", func(t *testing.T) {
+			lock, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, lock)
+
+			other, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, other)
+
+			assert.Nil(t, lock.Renew(ctx))
+			assert.Nil(t, lock.Unlock(ctx))
+
+			other, err = source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, other)
+			assert.Nil(t, other.Unlock(ctx))
+		},
+	)
+
+	t.Run(
+		"Expired", func(t *testing.T) {
+			lock, err := source.TryLock(ctx, -time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, lock)
+
+			other, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, other)
+
+			// the expired lock was taken
+			assert.Error(t, lock.Renew(ctx))
+			assert.Nil(t, other.Unlock(ctx))
 		},
 	)
 }
@@ -347,21 +823,18 @@ func TestLocalSource(t *testing.T) {
 	}
 
 	existingStateMarshaled := func() []byte {
-		existing := existingState(t)
-		marshaled, err := yaml.Marshal(existing)
-		assert.Nil(t, err)
-		return marshaled
+		return existingDocument(t)
 	}
 
 	unmarshalState := func(statePath string) State {
-		s := State{}
-
 		marshaledState, err := ioutil.ReadFile(statePath)
 		assert.Nil(t, err)
 
-		err = yaml.Unmarshal(marshaledState, &s)
+		doc, err := parseDocument(marshaledState)
 		assert.Nil(t, err)
 
+		s, ok := doc.get(testKey)
+		assert.True(t, ok)
 		return s
 	}
 
@@ -373,10 +846,10 @@ func TestLocalSource(t *testing.T) {
 
 	t.Run(
 		"NewLocalSource", func(t *testing.T) {
-			source, err := NewLocalSource(tempDir)
+			source, err := NewLocalSource(tempDir, nil)
 			assert.Nil(t, err)
 
-			expectedSource := LocalSource{tempDir}
+			expectedSource := LocalSource{tempDir, nil}
 			assert.Equal(t, expectedSource, source)
 			assert.Implements(t, (*Source)(nil), new(LocalSource))
 		},
@@ -384,20 +857,24 @@ func TestLocalSource(t *testing.T) {
 
 	t.Run(
 		"Update (new)", func(t *testing.T) {
-			source := LocalSource{tempDir}
+			source := LocalSource{tempDir, nil}
 			state := existingState(t)
 			ctx := context.Background()
 
 			assert.NoFileExists(t, statePath)
-			err = source.Update(ctx, state)
+			version, err := source.Update(ctx, testKey, state, NoVersion)
 			assert.Nil(t, err)
 			assert.FileExists(t, statePath)
+
+			expected, err := hashVersion(state)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, version)
 		},
 	)
 
 	t.Run(
 		"Update (existing)", func(t *testing.T) {
-			source := LocalSource{tempDir}
+			source := LocalSource{tempDir, nil}
 			marshaledExisting := existingStateMarshaled()
 			err := ioutil.WriteFile(
 				statePath, marshaledExisting, 0655,
@@ -411,7 +888,9 @@ func TestLocalSource(t *testing.T) {
 
 			// update the state
 			ctx := context.Background()
-			err = source.Update(ctx, expected)
+			version, err := hashVersion(existingState(t))
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, testKey, expected, version)
 			assert.Nil(t, err)
 
 			// now check that it's been updated
@@ -422,7 +901,7 @@ func TestLocalSource(t *testing.T) {
 
 	t.Run(
 		"Get", func(t *testing.T) {
-			source := LocalSource{tempDir}
+			source := LocalSource{tempDir, nil}
 			marshaledExisting := existingStateMarshaled()
 			err := ioutil.WriteFile(
 				statePath, marshaledExisting, 0655,
@@ -432,15 +911,51 @@ func TestLocalSource(t *testing.T) {
 
 			expected := existingState(t)
 			ctx := context.Background()
-			result, err := source.Get(ctx)
+			result, version, err := source.Get(ctx, testKey)
 			assert.Nil(t, err)
 			assert.Equal(t, expected, result)
+			assert.NotEqual(t, NoVersion, version)
+
+			_, _, err = source.Get(ctx, otherKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			source := LocalSource{tempDir, nil}
+			err := ioutil.WriteFile(statePath, existingStateMarshaled(), 0655)
+			assert.Nil(t, err)
+			ctx := context.Background()
+
+			changed := existingState(t)
+			changed.UserEmail.Name = "Firstname Lastname"
+			stale, err := hashVersion(changed)
+			assert.Nil(t, err)
+
+			tests := []struct {
+				name     string
+				key      AccountKey
+				expected Version
+			}{
+				{"stale version", testKey, stale},
+				{"already stored", testKey, NoVersion},
+				{"not stored", otherKey, stale},
+			}
+			for _, tt := range tests {
+				_, err := source.Update(ctx, tt.key, changed, tt.expected)
+				var conflict *ConflictError
+				assert.ErrorAs(t, err, &conflict, tt.name)
+			}
+
+			// the stored state is left alone
+			assert.Equal(t, existingState(t), unmarshalState(statePath))
 		},
 	)
 
 	t.Run(
 		"Exists", func(t *testing.T) {
-			source := LocalSource{tempDir}
+			source := LocalSource{tempDir, nil}
 			marshaledExisting := existingStateMarshaled()
 			err := ioutil.WriteFile(
 				statePath, marshaledExisting, 0655,
@@ -450,21 +965,155 @@ func TestLocalSource(t *testing.T) {
 
 			// try with existing state
 			ctx := context.Background()
-			result, err := source.Exists(ctx)
+			result, err := source.Exists(ctx, testKey)
 			assert.Nil(t, err)
 			assert.Equal(t, true, result)
+			result, err = source.Exists(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.Equal(t, false, result)
 
 			// now remove state and try with non-existing
 			err = os.Remove(statePath)
 			assert.Nil(t, err)
-			result, err = source.Exists(ctx)
+			result, err = source.Exists(ctx, testKey)
 			assert.Nil(t, err)
 			assert.Equal(t, false, result)
 		},
 	)
 
+	t.Run(
+		"Multiple accounts", func(t *testing.T) {
+			source := LocalSource{tempDir, nil}
+			ctx := context.Background()
+			other := existingState(t)
+			other.UserEmail.Address = &mail.Address{Address: "other@example.com"}
+
+			os.Remove(statePath)
+			_, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, otherKey, other, NoVersion)
+			assert.Nil(t, err)
+
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey, otherKey}, keys)
+
+			result, _, err := source.Get(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.Equal(t, other, result)
+			result, _, err = source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+		},
+	)
+
+	t.Run(
+		"Legacy state", func(t *testing.T) {
+			source := LocalSource{tempDir, nil}
+			ctx := context.Background()
+
+			// state from before multiple accounts is kept under an empty key
+			legacy, err := yaml.Marshal(existingState(t))
+			assert.Nil(t, err)
+			err = ioutil.WriteFile(statePath, legacy, 0655)
+			assert.Nil(t, err)
+
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{{}}, keys)
+
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			result, _, err := source.Get(ctx, AccountKey{})
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+		},
+	)
+
+	t.Run(
+		"AdoptLegacy", func(t *testing.T) {
+			source := LocalSource{t.TempDir(), nil}
+			ctx := context.Background()
+			assert.Implements(t, (*LegacyAdopter)(nil), source)
+
+			err := ioutil.WriteFile(
+				path.Join(source.directory, FileName), legacyDocument(t), 0644,
+			)
+			assert.Nil(t, err)
+
+			adopted, err := source.AdoptLegacy(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, adopted)
+
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, []AccountKey{testKey}, keys)
+			result, _, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+
+			// there's nothing left to adopt
+			adopted, err = source.AdoptLegacy(ctx, otherKey)
+			assert.Nil(t, err)
+			assert.False(t, adopted)
+		},
+	)
+
+	t.Run(
+		"TryLock", func(t *testing.T) {
+			source := LocalSource{tempDir, nil}
+			ctx := context.Background()
+			assert.Implements(t, (*Locker)(nil), new(LocalSource))
+
+			lock, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, lock)
+
+			other, err := source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.Nil(t, other)
+
+			assert.Nil(t, lock.Renew(ctx))
+			assert.Nil(t, lock.Unlock(ctx))
+
+			other, err = source.TryLock(ctx, time.Minute)
+			assert.Nil(t, err)
+			assert.NotNil(t, other)
+			assert.Nil(t, other.Unlock(ctx))
+		},
+	)
+
 	err = teardown(tempDir)
 	if err != nil {
 		t.Errorf("error in teardown(): %v", err)
 	}
 }
+
+func TestLocalSource_Write(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't enforced on windows")
+	}
+	dir := t.TempDir()
+	source, err := NewLocalSource(dir, nil)
+	assert.Nil(t, err)
+	// a state file left readable by an earlier version is replaced
+	statePath := path.Join(dir, FileName)
+	err = ioutil.WriteFile(statePath, existingDocument(t), 0644)
+	assert.Nil(t, err)
+
+	changed := existingState(t)
+	changed.UserEmail.Name = "Firstname Lastname"
+	version, err := hashVersion(existingState(t))
+	assert.Nil(t, err)
+	_, err = source.Update(context.Background(), testKey, changed, version)
+	assert.Nil(t, err)
+
+	info, err := os.Stat(statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the temp file was renamed into place
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
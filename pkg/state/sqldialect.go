@@ -0,0 +1,168 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverMysql    = "mysql"
+	DriverSqlite   = "sqlite"
+)
+
+func init() {
+	// sqlx only knows the cgo driver's name for sqlite
+	sqlx.BindDriver(DriverSqlite, sqlx.QUESTION)
+}
+
+// advisoryLockKey identifies certforgot's lock for postgres, it's "certforg"
+// in ASCII.
+const advisoryLockKey int64 = 0x63657274666f7267
+
+// migrationLockKey identifies the lock held while migrating, it's "certmigr"
+// in ASCII.
+const migrationLockKey int64 = 0x636572746d696772
+
+// sqlDialect holds what differs between databases: where the tables live,
+// the statements making up each schema migration and how to lock.
+type sqlDialect struct {
+	stateTable      string
+	historyTable    string
+	migrationsTable string
+	// tryLock and unlock take and release a session advisory lock named by
+	// lockArg, tryLock selecting 1 if it was taken. Databases without
+	// advisory locks lease a row in lockTable instead.
+	tryLock   string
+	unlock    string
+	lockArg   interface{}
+	lockTable string
+	// lockMigrations and unlockMigrations take and release a session
+	// advisory lock named by migrationLockArg, waiting for it, so instances
+	// don't migrate at once. Without them, each migration checks it's still
+	// unapplied in its transaction.
+	lockMigrations   string
+	unlockMigrations string
+	migrationLockArg interface{}
+	// createMigrations creates the migrations table, and anything it needs.
+	createMigrations []string
+	// migrations are applied in order, each migration's version being its
+	// index plus one. MySQL commits each DDL statement as it's run, so its
+	// migrations hold one statement each to never be left half applied.
+	migrations [][]string
+}
+
+var sqlDialects = map[string]sqlDialect{
+	DriverPostgres: {
+		stateTable:       "certforgot.state",
+		historyTable:     "certforgot.history",
+		migrationsTable:  "certforgot.schema_migrations",
+		tryLock:          "SELECT CASE WHEN pg_try_advisory_lock(?) THEN 1 ELSE 0 END",
+		unlock:           "SELECT pg_advisory_unlock(?)",
+		lockArg:          advisoryLockKey,
+		lockMigrations:   "SELECT 1 FROM pg_advisory_lock(?)",
+		unlockMigrations: "SELECT pg_advisory_unlock(?)",
+		migrationLockArg: migrationLockKey,
+		createMigrations: []string{
+			"CREATE SCHEMA IF NOT EXISTS certforgot",
+			"CREATE TABLE IF NOT EXISTS certforgot.schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)",
+		},
+		migrations: [][]string{
+			{
+				"CREATE TABLE IF NOT EXISTS certforgot.state (id SERIAL PRIMARY KEY, useremail TEXT NOT NULL, userprivatekey TEXT NOT NULL)",
+			},
+			{
+				"ALTER TABLE certforgot.state ADD COLUMN IF NOT EXISTS directory TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE certforgot.state ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT ''",
+				"CREATE UNIQUE INDEX IF NOT EXISTS state_account ON certforgot.state (directory, email)",
+				// tables made before migrations may not generate their ids
+				"CREATE SEQUENCE IF NOT EXISTS certforgot.state_id_seq OWNED BY certforgot.state.id",
+				"ALTER TABLE certforgot.state ALTER COLUMN id SET DEFAULT nextval('certforgot.state_id_seq')",
+				"SELECT setval('certforgot.state_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM certforgot.state",
+			},
+			{
+				"CREATE TABLE IF NOT EXISTS certforgot.history (id BIGSERIAL PRIMARY KEY, name TEXT NOT NULL, issued_at BIGINT NOT NULL, outcome TEXT NOT NULL, serial TEXT NOT NULL, domains TEXT NOT NULL, not_before BIGINT NOT NULL, not_after BIGINT NOT NULL, issuer TEXT NOT NULL, error TEXT NOT NULL)",
+				"CREATE INDEX IF NOT EXISTS history_name ON certforgot.history (name, issued_at)",
+			},
+			{
+				"ALTER TABLE certforgot.state ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1",
+			},
+		},
+	},
+	DriverMysql: {
+		stateTable:      "certforgot_state",
+		historyTable:    "certforgot_history",
+		migrationsTable: "certforgot_schema_migrations",
+		tryLock:         "SELECT COALESCE(GET_LOCK(?, 0), 0)",
+		unlock:          "SELECT RELEASE_LOCK(?)",
+		lockArg:         LockName,
+		// a negative timeout waits forever
+		lockMigrations:   "SELECT COALESCE(GET_LOCK(?, -1), 0)",
+		unlockMigrations: "SELECT RELEASE_LOCK(?)",
+		migrationLockArg: "certforgot_migrations",
+		createMigrations: []string{
+			"CREATE TABLE IF NOT EXISTS certforgot_schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)",
+		},
+		migrations: [][]string{
+			{
+				"CREATE TABLE IF NOT EXISTS certforgot_state (id BIGINT AUTO_INCREMENT PRIMARY KEY, useremail TEXT NOT NULL, userprivatekey TEXT NOT NULL)",
+			},
+			{
+				"ALTER TABLE certforgot_state ADD COLUMN directory VARCHAR(255) NOT NULL DEFAULT '', ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT ''",
+			},
+			{
+				"CREATE UNIQUE INDEX state_account ON certforgot_state (directory, email)",
+			},
+			{
+				"CREATE TABLE IF NOT EXISTS certforgot_history (id BIGINT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255) NOT NULL, issued_at BIGINT NOT NULL, outcome VARCHAR(16) NOT NULL, serial VARCHAR(64) NOT NULL, domains TEXT NOT NULL, not_before BIGINT NOT NULL, not_after BIGINT NOT NULL, issuer TEXT NOT NULL, error TEXT NOT NULL)",
+			},
+			{
+				"CREATE INDEX history_name ON certforgot_history (name, issued_at)",
+			},
+			{
+				"ALTER TABLE certforgot_state ADD COLUMN version BIGINT NOT NULL DEFAULT 1",
+			},
+		},
+	},
+	DriverSqlite: {
+		stateTable:      "certforgot_state",
+		historyTable:    "certforgot_history",
+		migrationsTable: "certforgot_schema_migrations",
+		lockTable:       "certforgot_lock",
+		createMigrations: []string{
+			"CREATE TABLE IF NOT EXISTS certforgot_schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)",
+		},
+		migrations: [][]string{
+			{
+				"CREATE TABLE IF NOT EXISTS certforgot_state (id INTEGER PRIMARY KEY AUTOINCREMENT, useremail TEXT NOT NULL, userprivatekey TEXT NOT NULL)",
+			},
+			{
+				"ALTER TABLE certforgot_state ADD COLUMN directory TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE certforgot_state ADD COLUMN email TEXT NOT NULL DEFAULT ''",
+				"CREATE UNIQUE INDEX state_account ON certforgot_state (directory, email)",
+			},
+			{
+				"CREATE TABLE certforgot_lock (name TEXT PRIMARY KEY, owner TEXT NOT NULL, expires_at INTEGER NOT NULL)",
+			},
+			{
+				"CREATE TABLE certforgot_history (id INTEGER PRIMARY KEY, name TEXT NOT NULL, issued_at INTEGER NOT NULL, outcome TEXT NOT NULL, serial TEXT NOT NULL, domains TEXT NOT NULL, not_before INTEGER NOT NULL, not_after INTEGER NOT NULL, issuer TEXT NOT NULL, error TEXT NOT NULL)",
+				"CREATE INDEX history_name ON certforgot_history (name, issued_at)",
+			},
+			{
+				"ALTER TABLE certforgot_state ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			},
+		},
+	},
+}
+
+func dialectFor(driver string) (sqlDialect, error) {
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return sqlDialect{}, fmt.Errorf(
+			"unsupported sql driver '%s', expected one of %s, %s or %s",
+			driver, DriverPostgres, DriverMysql, DriverSqlite,
+		)
+	}
+	return dialect, nil
+}
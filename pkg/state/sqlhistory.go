@@ -0,0 +1,124 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// historyRow is an issuance as stored, with times as unix seconds, 0 if unset,
+// and domains comma separated.
+type historyRow struct {
+	Name      string `db:"name"`
+	IssuedAt  int64  `db:"issued_at"`
+	Outcome   string `db:"outcome"`
+	Serial    string `db:"serial"`
+	Domains   string `db:"domains"`
+	NotBefore int64  `db:"not_before"`
+	NotAfter  int64  `db:"not_after"`
+	Issuer    string `db:"issuer"`
+	Error     string `db:"error"`
+}
+
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromUnix(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+func (row historyRow) issuance() Issuance {
+	issuance := Issuance{
+		Name:      row.Name,
+		Time:      fromUnix(row.IssuedAt),
+		Outcome:   IssuanceOutcome(row.Outcome),
+		Serial:    row.Serial,
+		NotBefore: fromUnix(row.NotBefore),
+		NotAfter:  fromUnix(row.NotAfter),
+		Issuer:    row.Issuer,
+		Error:     row.Error,
+	}
+	if row.Domains != "" {
+		issuance.Domains = strings.Split(row.Domains, ",")
+	}
+	return issuance
+}
+
+const insertHistoryQuery = "INSERT INTO %s (name, issued_at, outcome, serial, domains, not_before, not_after, issuer, error) VALUES (:name, :issued_at, :outcome, :serial, :domains, :not_before, :not_after, :issuer, :error)"
+
+// RecordIssuance stores the issuance, with its times to the second.
+func (p SqlSource) RecordIssuance(ctx context.Context, issuance Issuance) error {
+	row := historyRow{
+		Name:      issuance.Name,
+		IssuedAt:  toUnix(issuance.Time),
+		Outcome:   string(issuance.Outcome),
+		Serial:    issuance.Serial,
+		Domains:   strings.Join(issuance.Domains, ","),
+		NotBefore: toUnix(issuance.NotBefore),
+		NotAfter:  toUnix(issuance.NotAfter),
+		Issuer:    issuance.Issuer,
+		Error:     issuance.Error,
+	}
+	_, err := p.driver.NamedExecContext(
+		ctx, fmt.Sprintf(insertHistoryQuery, p.dialect.historyTable), row,
+	)
+	return err
+}
+
+const historyQuery = "SELECT name, issued_at, outcome, serial, domains, not_before, not_after, issuer, error FROM %s"
+
+func (p SqlSource) History(
+	ctx context.Context, query HistoryQuery,
+) ([]Issuance, error) {
+	var where []string
+	var args []interface{}
+	if len(query.Names) > 0 {
+		where = append(where, "name IN (?)")
+		args = append(args, query.Names)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "issued_at >= ?")
+		args = append(args, query.Since.Unix())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "issued_at < ?")
+		args = append(args, query.Until.Unix())
+	}
+
+	statement := fmt.Sprintf(historyQuery, p.dialect.historyTable)
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	// the most recent are selected first so that they can be limited
+	statement += " ORDER BY issued_at DESC, id DESC"
+	if query.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	statement, args, err := sqlx.In(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []historyRow
+	err = p.driver.SelectContext(ctx, &rows, p.driver.Rebind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Issuance
+	for i := len(rows) - 1; i >= 0; i-- {
+		history = append(history, rows[i].issuance())
+	}
+	return history, nil
+}
@@ -0,0 +1,140 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TryLock takes an advisory lock held by a dedicated connection, which the
+// database releases if the connection dies, so ttl isn't needed. Databases
+// without advisory locks lease a row expiring after ttl instead.
+func (p SqlSource) TryLock(ctx context.Context, ttl time.Duration) (Lock, error) {
+	if p.dialect.tryLock == "" {
+		return p.tryLease(ctx, ttl)
+	}
+
+	conn, err := p.driver.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked int
+	err = conn.GetContext(
+		ctx, &locked, p.driver.Rebind(p.dialect.tryLock), p.dialect.lockArg,
+	)
+	if err != nil || locked != 1 {
+		conn.Close()
+		return nil, err
+	}
+	return advisoryLock{conn, p.driver.Rebind(p.dialect.unlock), p.dialect.lockArg}, nil
+}
+
+type advisoryLock struct {
+	conn   *sqlx.Conn
+	unlock string
+	arg    interface{}
+}
+
+// Renew checks that the connection holding the lock is still alive.
+func (lock advisoryLock) Renew(ctx context.Context) error {
+	return lock.conn.PingContext(ctx)
+}
+
+func (lock advisoryLock) Unlock(ctx context.Context) error {
+	defer lock.conn.Close()
+	_, err := lock.conn.ExecContext(ctx, lock.unlock, lock.arg)
+	return err
+}
+
+type leaseLock struct {
+	source SqlSource
+	owner  string
+	ttl    time.Duration
+}
+
+func (p SqlSource) tryLease(ctx context.Context, ttl time.Duration) (Lock, error) {
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := p.driver.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(
+		ctx, p.lockQuery("DELETE FROM %s WHERE name = ? AND expires_at < ?"),
+		LockName, now.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var holders int
+	err = tx.GetContext(
+		ctx, &holders, p.lockQuery("SELECT COUNT(*) FROM %s WHERE name = ?"),
+		LockName,
+	)
+	if err != nil || holders > 0 {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		p.lockQuery("INSERT INTO %s (name, owner, expires_at) VALUES (?, ?, ?)"),
+		LockName, owner, now.Add(ttl).Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return leaseLock{p, owner, ttl}, nil
+}
+
+func (lock leaseLock) Renew(ctx context.Context) error {
+	result, err := lock.source.driver.ExecContext(
+		ctx, lock.source.lockQuery(
+			"UPDATE %s SET expires_at = ? WHERE name = ? AND owner = ?",
+		), time.Now().Add(lock.ttl).Unix(), LockName, lock.owner,
+	)
+	if err != nil {
+		return err
+	}
+	return checkLeaseHeld(result)
+}
+
+func (lock leaseLock) Unlock(ctx context.Context) error {
+	result, err := lock.source.driver.ExecContext(
+		ctx, lock.source.lockQuery("DELETE FROM %s WHERE name = ? AND owner = ?"),
+		LockName, lock.owner,
+	)
+	if err != nil {
+		return err
+	}
+	return checkLeaseHeld(result)
+}
+
+func checkLeaseHeld(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("lease expired and was taken")
+	}
+	return nil
+}
+
+func (p SqlSource) lockQuery(query string) string {
+	return p.driver.Rebind(fmt.Sprintf(query, p.dialect.lockTable))
+}
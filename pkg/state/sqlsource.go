@@ -3,77 +3,305 @@ package state
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
+// SqlSource stores one row per account, keyed by the directory and email
+// columns. The schema is created and migrated when the source is created.
 type SqlSource struct {
-	driver *sqlx.DB
+	driver  *sqlx.DB
+	dialect sqlDialect
 }
 
 type stateRow struct {
+	AccountKey
 	State
-	ID uint64
 }
 
+type versionedRow struct {
+	stateRow
+	Version  int64
+	Expected int64
+}
+
+func sqlVersion(version int64) Version {
+	return Version(strconv.FormatInt(version, 10))
+}
+
+// NewSqlSource creates a source for db, which was opened with driver, one of
+// postgres, mysql or sqlite.
 func NewSqlSource(
 	ctx context.Context, driver string, db *sql.DB,
 ) (SqlSource, error) {
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return SqlSource{}, err
+	}
 	sqlxDriver := sqlx.NewDb(db, driver)
 
-	err := sqlxDriver.PingContext(ctx)
+	err = sqlxDriver.PingContext(ctx)
 	if err != nil {
 		return SqlSource{}, err
 	}
 
-	return SqlSource{sqlxDriver}, nil
+	source := SqlSource{sqlxDriver, dialect}
+	if err := source.migrate(ctx); err != nil {
+		return SqlSource{}, fmt.Errorf("migrating schema: %v", err)
+	}
+	return source, nil
 }
 
-const InsertQuery = "INSERT INTO certforgot.state VALUES (:id, :useremail, :userprivatekey);"
-const UpdateQuery = "UPDATE certforgot.state SET useremail = :useremail, userprivatekey = :userprivatekey WHERE id = :id"
+// Version returns the schema version of the database.
+func (p SqlSource) Version(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := p.driver.GetContext(
+		ctx, &version,
+		fmt.Sprintf("SELECT MAX(version) FROM %s", p.dialect.migrationsTable),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
 
-func (p SqlSource) Update(ctx context.Context, state State) error {
-	stateRow := stateRow{State: state, ID: 1}
+func (p SqlSource) migrate(ctx context.Context) error {
+	unlock, err := p.lockMigrations(ctx)
+	if err != nil {
+		return fmt.Errorf("locking migrations: %v", err)
+	}
+	defer unlock()
+
+	for _, statement := range p.dialect.createMigrations {
+		if _, err := p.driver.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("creating migrations table: %v", err)
+		}
+	}
 
-	exists, err := p.Exists(ctx)
+	current, err := p.Version(ctx)
 	if err != nil {
-		return fmt.Errorf("checking if state existed: %v", err)
+		return fmt.Errorf("getting schema version: %v", err)
 	}
 
-	var query string
-	if exists {
-		query = UpdateQuery
-	} else {
-		query = InsertQuery
+	for i := current; i < len(p.dialect.migrations); i++ {
+		if err := p.applyMigration(ctx, i+1, p.dialect.migrations[i]); err != nil {
+			return fmt.Errorf("applying migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// lockMigrations holds the dialect's migration lock, if it has one, on a
+// dedicated connection until unlock is called.
+func (p SqlSource) lockMigrations(ctx context.Context) (func(), error) {
+	if p.dialect.lockMigrations == "" {
+		return func() {}, nil
 	}
 
-	_, err = p.driver.NamedExecContext(ctx, query, stateRow)
+	conn, err := p.driver.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked int
+	err = conn.GetContext(
+		ctx, &locked, p.driver.Rebind(p.dialect.lockMigrations),
+		p.dialect.migrationLockArg,
+	)
+	if err == nil && locked != 1 {
+		err = errors.New("lock wasn't taken")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		// closing the connection releases the lock anyway
+		defer conn.Close()
+		conn.ExecContext(
+			context.Background(), p.driver.Rebind(p.dialect.unlockMigrations),
+			p.dialect.migrationLockArg,
+		)
+	}, nil
+}
+
+// applyMigration skips the migration if another instance applied it since
+// the version was read.
+func (p SqlSource) applyMigration(
+	ctx context.Context, version int, statements []string,
+) error {
+	tx, err := p.driver.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	var applied int
+	err = tx.GetContext(
+		ctx, &applied, p.driver.Rebind(
+			fmt.Sprintf(
+				"SELECT COUNT(*) FROM %s WHERE version = ?",
+				p.dialect.migrationsTable,
+			),
+		), version,
+	)
+	if err != nil || applied > 0 {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(
+		ctx, p.driver.Rebind(
+			fmt.Sprintf(
+				"INSERT INTO %s (version, applied_at) VALUES (?, ?)",
+				p.dialect.migrationsTable,
+			),
+		), version, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-const GetQuery = "SELECT * FROM certforgot.state LIMIT 1"
+const insertQuery = "INSERT INTO %s (directory, email, useremail, userprivatekey, version) VALUES (:directory, :email, :useremail, :userprivatekey, :version)"
+const updateQuery = "UPDATE %s SET useremail = :useremail, userprivatekey = :userprivatekey, version = :version WHERE directory = :directory AND email = :email AND version = :expected"
 
-func (p SqlSource) Get(ctx context.Context) (State, error) {
-	state := stateRow{ID: 1}
-	err := p.driver.GetContext(ctx, &state, GetQuery)
+// Update versions the account by a counter column, incremented by each update.
+func (p SqlSource) Update(
+	ctx context.Context, key AccountKey, state State, expected Version,
+) (Version, error) {
+	if expected == NoVersion {
+		return p.insert(ctx, key, state)
+	}
+
+	current, err := strconv.ParseInt(string(expected), 10, 64)
+	if err != nil {
+		return NoVersion, &ConflictError{key, expected}
+	}
+	row := versionedRow{
+		stateRow: stateRow{AccountKey: key, State: state},
+		Version:  current + 1,
+		Expected: current,
+	}
+	result, err := p.driver.NamedExecContext(
+		ctx, fmt.Sprintf(updateQuery, p.dialect.stateTable), row,
+	)
+	if err != nil {
+		return NoVersion, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return NoVersion, err
+	}
+	if updated == 0 {
+		return NoVersion, &ConflictError{key, expected}
+	}
+	return sqlVersion(row.Version), nil
+}
+
+// insert stores a new account, relying on the unique index over its key to
+// reject it if the account was stored concurrently.
+func (p SqlSource) insert(
+	ctx context.Context, key AccountKey, state State,
+) (Version, error) {
+	row := versionedRow{
+		stateRow: stateRow{AccountKey: key, State: state},
+		Version:  1,
+	}
+	_, err := p.driver.NamedExecContext(
+		ctx, fmt.Sprintf(insertQuery, p.dialect.stateTable), row,
+	)
 	if err != nil {
-		return state.State, err
+		// drivers don't agree on how to report a duplicate key
+		exists, existsErr := p.Exists(ctx, key)
+		if existsErr == nil && exists {
+			return NoVersion, &ConflictError{key, NoVersion}
+		}
+		return NoVersion, err
 	}
-	return state.State, nil
+	return sqlVersion(row.Version), nil
 }
 
-const ExistsQuery = "SELECT COUNT(*) FROM certforgot.state"
+const getQuery = "SELECT useremail, userprivatekey, version FROM %s WHERE directory = ? AND email = ?"
 
-func (p SqlSource) Exists(ctx context.Context) (bool, error) {
+func (p SqlSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	row := versionedRow{}
+	err := p.driver.GetContext(
+		ctx, &row, p.query(getQuery), key.Directory, key.Email,
+	)
+	if err == sql.ErrNoRows {
+		return State{}, NoVersion, ErrNoAccount
+	} else if err != nil {
+		return State{}, NoVersion, err
+	}
+	return row.State, sqlVersion(row.Version), nil
+}
+
+const existsQuery = "SELECT COUNT(*) FROM %s WHERE directory = ? AND email = ?"
+
+func (p SqlSource) Exists(ctx context.Context, key AccountKey) (bool, error) {
 	var count int
-	err := p.driver.GetContext(ctx, &count, ExistsQuery)
+	err := p.driver.GetContext(
+		ctx, &count, p.query(existsQuery), key.Directory, key.Email,
+	)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
+
+const listQuery = "SELECT directory, email FROM %s ORDER BY id"
+
+func (p SqlSource) List(ctx context.Context) ([]AccountKey, error) {
+	var keys []AccountKey
+	err := p.driver.SelectContext(ctx, &keys, p.query(listQuery))
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+const adoptLegacyQuery = "UPDATE %s SET directory = ?, email = ? WHERE directory = '' AND email = ''"
+
+// AdoptLegacy rekeys the row from before the schema held multiple accounts,
+// whose key columns were migrated empty.
+func (p SqlSource) AdoptLegacy(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	exists, err := p.Exists(ctx, key)
+	if err != nil || exists {
+		return false, err
+	}
+
+	result, err := p.driver.ExecContext(
+		ctx, p.query(adoptLegacyQuery), key.Directory, key.Email,
+	)
+	if err != nil {
+		return false, err
+	}
+	adopted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return adopted > 0, nil
+}
+
+// query fills in the state table and rebinds a query for the dialect.
+func (p SqlSource) query(query string) string {
+	return p.driver.Rebind(fmt.Sprintf(query, p.dialect.stateTable))
+}
@@ -83,7 +83,14 @@ func (key *Jwk) Scan(src interface{}) error {
 	if src == nil {
 		return errors.New("jwk was nil")
 	}
-	if v, ok := src.([]byte); ok {
+	var v []byte
+	switch src := src.(type) {
+	case []byte:
+		v = src
+	case string:
+		v = []byte(src)
+	}
+	if v != nil {
 		parsedKey, err := jwk.ParseKey(v)
 		if err != nil {
 			return err
@@ -178,8 +178,14 @@ func TestJwk_Scan(t *testing.T) {
 			false,
 		},
 		{
-			"Wrong type", fields{},
+			"Works with string", fields{},
 			args{"{\"k\":\"dGVzdA\",\"kty\":\"oct\"}"},
+			testJwk,
+			false,
+		},
+		{
+			"Wrong type", fields{},
+			args{1337},
 			nil,
 			true,
 		},
@@ -0,0 +1,259 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/figglewatts/certforgot/pkg/vault"
+)
+
+// VaultKvSource stores each account as a secret in a vault KV version 2
+// engine, under accounts/ in the source's path, and the history as a secret
+// called history next to them.
+type VaultKvSource struct {
+	client vault.KvClient
+	path   string
+}
+
+const (
+	DefaultVaultPath = "certforgot"
+
+	// the history is kept in one secret, so it's capped to keep it small
+	maxKvHistory = 500
+)
+
+func NewVaultKvSource(client vault.KvClient, path string) (VaultKvSource, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		path = DefaultVaultPath
+	}
+	return VaultKvSource{client, path}, nil
+}
+
+// Update versions the account by its secret's version, and uses vault's
+// check-and-set so the secret is only written if it's still at that version.
+func (source VaultKvSource) Update(
+	ctx context.Context, key AccountKey, state State, expected Version,
+) (Version, error) {
+	cas := 0
+	if expected != NoVersion {
+		parsed, err := strconv.Atoi(string(expected))
+		if err != nil {
+			return NoVersion, &ConflictError{key, expected}
+		}
+		cas = parsed
+	}
+
+	privateKey, err := state.MarshaledPrivateKey()
+	if err != nil {
+		return NoVersion, fmt.Errorf("marshaling key: %v", err)
+	}
+	version, err := source.client.Put(
+		ctx, source.accountPath(key), map[string]string{
+			"directory":      key.Directory,
+			"email":          key.Email,
+			"userEmail":      state.UserEmail.String(),
+			"userPrivateKey": privateKey,
+		}, cas,
+	)
+	if errors.Is(err, vault.ErrVersionMismatch) {
+		return NoVersion, &ConflictError{key, expected}
+	} else if err != nil {
+		return NoVersion, err
+	}
+	return Version(strconv.Itoa(version)), nil
+}
+
+func (source VaultKvSource) Get(
+	ctx context.Context, key AccountKey,
+) (State, Version, error) {
+	secret, err := source.client.Get(ctx, source.accountPath(key))
+	if err != nil {
+		return State{}, NoVersion, err
+	}
+	if secret == nil {
+		return State{}, NoVersion, ErrNoAccount
+	}
+
+	email, err := mail.ParseAddress(secret.Data["userEmail"])
+	if err != nil {
+		return State{}, NoVersion, fmt.Errorf("parsing account email: %v", err)
+	}
+	var privateKey Jwk
+	err = privateKey.UnmarshalText([]byte(secret.Data["userPrivateKey"]))
+	if err != nil {
+		return State{}, NoVersion, fmt.Errorf("parsing account key: %v", err)
+	}
+	version := Version(strconv.Itoa(secret.Version))
+	return State{Email{email}, privateKey}, version, nil
+}
+
+func (source VaultKvSource) Exists(
+	ctx context.Context, key AccountKey,
+) (bool, error) {
+	secret, err := source.client.Get(ctx, source.accountPath(key))
+	if err != nil {
+		return false, err
+	}
+	return secret != nil, nil
+}
+
+// List reads every account's secret, as the account's key can't be recovered
+// from the secret's name.
+func (source VaultKvSource) List(ctx context.Context) ([]AccountKey, error) {
+	names, err := source.client.List(ctx, source.path+"/accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []AccountKey
+	for _, name := range names {
+		secret, err := source.client.Get(ctx, source.path+"/accounts/"+name)
+		if err != nil {
+			return nil, err
+		}
+		// deleted accounts are still listed
+		if secret == nil {
+			continue
+		}
+		keys = append(
+			keys, AccountKey{
+				Directory: secret.Data["directory"],
+				Email:     secret.Data["email"],
+			},
+		)
+	}
+	return keys, nil
+}
+
+// RecordIssuance retries if the history changes while it's being appended
+// to.
+func (source VaultKvSource) RecordIssuance(
+	ctx context.Context, issuance Issuance,
+) error {
+	for attempt := 1; ; attempt++ {
+		history, version, err := source.history(ctx)
+		if err != nil {
+			return err
+		}
+		history = append(history, issuance)
+		if len(history) > maxKvHistory {
+			history = history[len(history)-maxKvHistory:]
+		}
+
+		marshaled, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("marshaling history: %v", err)
+		}
+		_, err = source.client.Put(
+			ctx, source.historyPath(),
+			map[string]string{"issuances": string(marshaled)}, version,
+		)
+		if !errors.Is(err, vault.ErrVersionMismatch) ||
+			attempt == maxDocumentWrites {
+			return err
+		}
+	}
+}
+
+func (source VaultKvSource) History(
+	ctx context.Context, query HistoryQuery,
+) ([]Issuance, error) {
+	history, _, err := source.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return query.filter(history), nil
+}
+
+// history returns the recorded issuances and the version of the secret
+// holding them, which is 0 if there isn't one yet.
+func (source VaultKvSource) history(
+	ctx context.Context,
+) ([]Issuance, int, error) {
+	secret, err := source.client.Get(ctx, source.historyPath())
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil {
+		return nil, 0, nil
+	}
+
+	var history []Issuance
+	err = json.Unmarshal([]byte(secret.Data["issuances"]), &history)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parsing history: %v", err)
+	}
+	return history, secret.Version, nil
+}
+
+func (source VaultKvSource) accountPath(key AccountKey) string {
+	return source.path + "/accounts/" + vaultSuffix(key)
+}
+
+func (source VaultKvSource) historyPath() string {
+	return source.path + "/history"
+}
+
+func (source VaultKvSource) lockPath() string {
+	return source.path + "/lock"
+}
+
+// TryLock takes the lease in a secret called lock next to the history,
+// written with check-and-set.
+func (source VaultKvSource) TryLock(
+	ctx context.Context, ttl time.Duration,
+) (Lock, error) {
+	return tryLease(ctx, vaultLeaseStore{source.client, source.lockPath()}, ttl)
+}
+
+type vaultLeaseStore struct {
+	client vault.KvClient
+	path   string
+}
+
+// readLease versions the lease by its secret's version.
+func (store vaultLeaseStore) readLease(
+	ctx context.Context,
+) (lease, interface{}, error) {
+	secret, err := store.client.Get(ctx, store.path)
+	if err != nil {
+		return lease{}, nil, err
+	}
+	if secret == nil {
+		return lease{}, nil, nil
+	}
+
+	l := lease{Owner: secret.Data["owner"]}
+	if l.Owner != "" {
+		l.ExpiresAt, err = time.Parse(time.RFC3339Nano, secret.Data["expiresAt"])
+		if err != nil {
+			return lease{}, nil, fmt.Errorf("parsing lease expiry: %v", err)
+		}
+	}
+	return l, secret.Version, nil
+}
+
+func (store vaultLeaseStore) writeLease(
+	ctx context.Context, l lease, version interface{},
+) (interface{}, error) {
+	// 0 creates the secret
+	cas, _ := version.(int)
+	data := map[string]string{"owner": l.Owner}
+	if l.Owner != "" {
+		data["expiresAt"] = l.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	written, err := store.client.Put(ctx, store.path, data, cas)
+	if errors.Is(err, vault.ErrVersionMismatch) {
+		return nil, errLeaseModified
+	} else if err != nil {
+		return nil, err
+	}
+	return written, nil
+}
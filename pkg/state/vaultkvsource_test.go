@@ -0,0 +1,167 @@
+package state
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/vault"
+	"github.com/figglewatts/certforgot/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultKvSource(t *testing.T) {
+	ctx := context.Background()
+	newSource := func(t *testing.T) (VaultKvSource, *vaulttest.Server) {
+		server := vaulttest.NewServer(t)
+		client, err := vault.NewKvClient(
+			ctx, server.URL, vaulttest.KvMount,
+			vault.Auth{Token: vaulttest.RootToken},
+		)
+		assert.Nil(t, err)
+		source, err := NewVaultKvSource(client, "")
+		assert.Nil(t, err)
+		return source, server
+	}
+
+	t.Run(
+		"NewVaultKvSource", func(t *testing.T) {
+			assert.Implements(t, (*Source)(nil), new(VaultKvSource))
+			assert.Implements(t, (*Locker)(nil), new(VaultKvSource))
+
+			source, err := NewVaultKvSource(nil, "/team/certforgot/")
+			assert.Nil(t, err)
+			assert.Equal(t, "team/certforgot", source.path)
+		},
+	)
+
+	t.Run(
+		"Update", func(t *testing.T) {
+			source, server := newSource(t)
+
+			version, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			assert.Equal(t, Version("1"), version)
+
+			secret := server.Secret(source.accountPath(testKey))
+			assert.Equal(t, testKey.Directory, secret["directory"])
+			assert.Equal(t, "<test@example.com>", secret["userEmail"])
+
+			changed := existingState(t)
+			changed.UserEmail.Name = "Firstname Lastname"
+			version, err = source.Update(ctx, testKey, changed, version)
+			assert.Nil(t, err)
+			assert.Equal(t, Version("2"), version)
+
+			result, current, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, changed, result)
+			assert.Equal(t, version, current)
+		},
+	)
+
+	t.Run(
+		"Update (conflict)", func(t *testing.T) {
+			source, _ := newSource(t)
+			_, err := source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, testKey, existingState(t), "1")
+			assert.Nil(t, err)
+
+			tests := []struct {
+				name     string
+				key      AccountKey
+				expected Version
+			}{
+				{"stale version", testKey, "1"},
+				{"already stored", testKey, NoVersion},
+				{"not stored", otherKey, "1"},
+				{"bad version", testKey, "bad"},
+			}
+			for _, tt := range tests {
+				_, err := source.Update(ctx, tt.key, existingState(t), tt.expected)
+				var conflict *ConflictError
+				assert.ErrorAs(t, err, &conflict, tt.name)
+			}
+		},
+	)
+
+	t.Run(
+		"Get", func(t *testing.T) {
+			source, _ := newSource(t)
+			_, _, err := source.Get(ctx, testKey)
+			assert.ErrorIs(t, err, ErrNoAccount)
+
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			result, version, err := source.Get(ctx, testKey)
+			assert.Nil(t, err)
+			assert.Equal(t, existingState(t), result)
+			assert.Equal(t, Version("1"), version)
+		},
+	)
+
+	t.Run(
+		"Exists", func(t *testing.T) {
+			source, _ := newSource(t)
+			exists, err := source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.False(t, exists)
+
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			exists, err = source.Exists(ctx, testKey)
+			assert.Nil(t, err)
+			assert.True(t, exists)
+		},
+	)
+
+	t.Run(
+		"List", func(t *testing.T) {
+			source, _ := newSource(t)
+			keys, err := source.List(ctx)
+			assert.Nil(t, err)
+			assert.Empty(t, keys)
+
+			other := existingState(t)
+			other.UserEmail.Address = &mail.Address{Address: "other@example.com"}
+			_, err = source.Update(ctx, testKey, existingState(t), NoVersion)
+			assert.Nil(t, err)
+			_, err = source.Update(ctx, otherKey, other, NoVersion)
+			assert.Nil(t, err)
+
+			keys, err = source.List(ctx)
+			assert.Nil(t, err)
+			assert.ElementsMatch(t, []AccountKey{testKey, otherKey}, keys)
+		},
+	)
+
+	t.Run(
+		"History", func(t *testing.T) {
+			source, _ := newSource(t)
+			testSourceHistory(t, source)
+		},
+	)
+
+	t.Run(
+		"RecordIssuance (capped)", func(t *testing.T) {
+			source, _ := newSource(t)
+			issuance := testIssuances()[0]
+			for i := 0; i < maxKvHistory+1; i++ {
+				assert.Nil(t, source.RecordIssuance(ctx, issuance))
+			}
+
+			history, err := source.History(ctx, HistoryQuery{})
+			assert.Nil(t, err)
+			assert.Len(t, history, maxKvHistory)
+		},
+	)
+
+	t.Run(
+		"TryLock", func(t *testing.T) {
+			source, server := newSource(t)
+			testLeaseLocker(t, source)
+			assert.Equal(t, "", server.Secret(source.lockPath())["owner"])
+		},
+	)
+}
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version identifies what's stored for an account, changing whenever it's
+// updated. What it's made from depends on the source.
+type Version string
+
+// NoVersion is the version of an account that isn't stored yet.
+const NoVersion Version = ""
+
+// ConflictError is returned by Update when the stored account is no longer at
+// the version it was expected to be.
+type ConflictError struct {
+	Key      AccountKey
+	Expected Version
+}
+
+func (e *ConflictError) Error() string {
+	if e.Expected == NoVersion {
+		return fmt.Sprintf("account %s was stored by something else", e.Key)
+	}
+	return fmt.Sprintf(
+		"account %s was changed by something else since version %s", e.Key,
+		e.Expected,
+	)
+}
+
+// hashVersion versions state by its content, for sources without versions
+// of their own.
+func hashVersion(state State) (Version, error) {
+	marshaled, err := yaml.Marshal(state)
+	if err != nil {
+		return NoVersion, err
+	}
+	digest := sha256.Sum256(marshaled)
+	return Version(hex.EncodeToString(digest[:16])), nil
+}
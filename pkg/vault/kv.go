@@ -0,0 +1,211 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ErrVersionMismatch is returned when writing a secret that isn't at the
+// version it was expected to be.
+var ErrVersionMismatch = errors.New("secret version mismatch")
+
+// KvClient reads and writes secrets in a KV version 2 secrets engine.
+type KvClient interface {
+	// Get returns nil if the secret doesn't exist, or its latest version was
+	// deleted.
+	Get(ctx context.Context, path string) (*Secret, error)
+	// Put writes a version of the secret if it's still at version cas, where
+	// 0 means the secret mustn't exist yet, returning the new version. It
+	// returns ErrVersionMismatch if the secret is at another version.
+	Put(
+		ctx context.Context, path string, data map[string]string, cas int,
+	) (int, error)
+	// List returns the names of the secrets under path.
+	List(ctx context.Context, path string) ([]string, error)
+}
+
+// Secret is the data of a secret at a version.
+type Secret struct {
+	Data    map[string]string
+	Version int
+}
+
+type kvClient struct {
+	client *api.Client
+	mount  string
+}
+
+// Auth is how to log in to vault. With an AppRole role ID, the role's secret
+// ID is exchanged for a token, otherwise Token is used.
+type Auth struct {
+	Token string
+
+	AppRoleMount    string
+	AppRoleRoleId   string
+	AppRoleSecretId string
+}
+
+const (
+	DefaultKvMount      = "secret"
+	DefaultAppRoleMount = "approle"
+)
+
+// NewKvClient creates a client for the KV engine mounted at mount, or
+// DefaultKvMount if empty, on the vault at address. The address defaults to
+// $VAULT_ADDR, and the token to $VAULT_TOKEN.
+func NewKvClient(
+	ctx context.Context, address string, mount string, auth Auth,
+) (KvClient, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, fmt.Errorf("configuring client: %v", config.Error)
+	}
+	if address != "" {
+		config.Address = address
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %v", err)
+	}
+
+	if auth.AppRoleRoleId != "" {
+		token, err := appRoleLogin(ctx, client, auth)
+		if err != nil {
+			return nil, err
+		}
+		client.SetToken(token)
+	} else if auth.Token != "" {
+		client.SetToken(auth.Token)
+	}
+	if mount == "" {
+		mount = DefaultKvMount
+	}
+	return kvClient{client, strings.Trim(mount, "/")}, nil
+}
+
+func appRoleLogin(
+	ctx context.Context, client *api.Client, auth Auth,
+) (string, error) {
+	mount := auth.AppRoleMount
+	if mount == "" {
+		mount = DefaultAppRoleMount
+	}
+
+	// logging in doesn't need a token, and an old one could be rejected
+	client.ClearToken()
+	secret, err := client.Logical().WriteWithContext(
+		ctx, "auth/"+strings.Trim(mount, "/")+"/login",
+		map[string]interface{}{
+			"role_id":   auth.AppRoleRoleId,
+			"secret_id": auth.AppRoleSecretId,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("logging in with approle: %v", err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", errors.New("logging in with approle: no token")
+	}
+	return secret.Auth.ClientToken, nil
+}
+
+func (client kvClient) Get(ctx context.Context, path string) (*Secret, error) {
+	secret, err := client.client.Logical().ReadWithContext(
+		ctx, client.mount+"/data/"+path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret: %v", err)
+	}
+	if secret == nil || secret.Data == nil || secret.Data["data"] == nil {
+		return nil, nil
+	}
+
+	var response struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	}
+	if err := remarshal(secret.Data, &response); err != nil {
+		return nil, fmt.Errorf("parsing secret: %v", err)
+	}
+	return &Secret{response.Data, response.Metadata.Version}, nil
+}
+
+func (client kvClient) Put(
+	ctx context.Context, path string, data map[string]string, cas int,
+) (int, error) {
+	secret, err := client.client.Logical().WriteWithContext(
+		ctx, client.mount+"/data/"+path, map[string]interface{}{
+			"data":    data,
+			"options": map[string]interface{}{"cas": cas},
+		},
+	)
+	if isCasMismatch(err) {
+		return 0, ErrVersionMismatch
+	} else if err != nil {
+		return 0, fmt.Errorf("writing secret: %v", err)
+	}
+	if secret == nil {
+		return 0, errors.New("writing secret: no version")
+	}
+
+	var response struct {
+		Version int `json:"version"`
+	}
+	if err := remarshal(secret.Data, &response); err != nil {
+		return 0, fmt.Errorf("parsing written secret: %v", err)
+	}
+	return response.Version, nil
+}
+
+func (client kvClient) List(ctx context.Context, path string) ([]string, error) {
+	secret, err := client.client.Logical().ListWithContext(
+		ctx, client.mount+"/metadata/"+path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing secrets: %v", err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	var response struct {
+		Keys []string `json:"keys"`
+	}
+	if err := remarshal(secret.Data, &response); err != nil {
+		return nil, fmt.Errorf("parsing secret list: %v", err)
+	}
+	return response.Keys, nil
+}
+
+// isCasMismatch reports whether err is vault rejecting a write for not being
+// at the check-and-set version.
+func isCasMismatch(err error) bool {
+	var respErr *api.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, message := range respErr.Errors {
+		if strings.Contains(message, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
+
+// remarshal decodes the loosely typed data in a vault response into v.
+func remarshal(data map[string]interface{}, v interface{}) error {
+	marshaled, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(marshaled, v)
+}
@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/figglewatts/certforgot/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKvClient(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		auth       Auth
+		wantLogins int
+		wantErr    bool
+		wantDenied bool
+	}{
+		{"token", Auth{Token: vaulttest.RootToken}, 0, false, false},
+		{
+			"approle", Auth{
+				Token:           "ignored",
+				AppRoleRoleId:   vaulttest.RoleId,
+				AppRoleSecretId: vaulttest.SecretId,
+			}, 1, false, false,
+		},
+		{
+			"approle (bad secret id)", Auth{
+				AppRoleRoleId:   vaulttest.RoleId,
+				AppRoleSecretId: "wrong",
+			}, 0, true, false,
+		},
+		{"bad token", Auth{Token: "wrong"}, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				server := vaulttest.NewServer(t)
+				client, err := NewKvClient(
+					ctx, server.URL, vaulttest.KvMount, tt.auth,
+				)
+				assert.Equal(t, tt.wantLogins, server.Logins())
+				if tt.wantErr {
+					assert.Error(t, err)
+					return
+				}
+				assert.Nil(t, err)
+
+				_, err = client.Get(ctx, "missing")
+				if tt.wantDenied {
+					assert.Error(t, err)
+				} else {
+					assert.Nil(t, err)
+				}
+			},
+		)
+	}
+}
+
+func TestKvClient(t *testing.T) {
+	ctx := context.Background()
+	server := vaulttest.NewServer(t)
+	client, err := NewKvClient(
+		ctx, server.URL, vaulttest.KvMount, Auth{Token: vaulttest.RootToken},
+	)
+	assert.Nil(t, err)
+
+	t.Run(
+		"Get (missing)", func(t *testing.T) {
+			secret, err := client.Get(ctx, "missing")
+			assert.Nil(t, err)
+			assert.Nil(t, secret)
+		},
+	)
+
+	t.Run(
+		"Put", func(t *testing.T) {
+			version, err := client.Put(
+				ctx, "put/secret", map[string]string{"a": "1"}, 0,
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, version)
+
+			version, err = client.Put(
+				ctx, "put/secret", map[string]string{"a": "2"}, 1,
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, version)
+
+			secret, err := client.Get(ctx, "put/secret")
+			assert.Nil(t, err)
+			assert.Equal(t, &Secret{map[string]string{"a": "2"}, 2}, secret)
+		},
+	)
+
+	t.Run(
+		"Put (version mismatch)", func(t *testing.T) {
+			server.SetSecret("mismatch", map[string]string{"a": "1"})
+
+			_, err := client.Put(ctx, "mismatch", map[string]string{}, 0)
+			assert.ErrorIs(t, err, ErrVersionMismatch)
+			_, err = client.Put(ctx, "mismatch", map[string]string{}, 2)
+			assert.ErrorIs(t, err, ErrVersionMismatch)
+			assert.Equal(t, map[string]string{"a": "1"}, server.Secret("mismatch"))
+		},
+	)
+
+	t.Run(
+		"List", func(t *testing.T) {
+			server.SetSecret("list/b", map[string]string{})
+			server.SetSecret("list/a", map[string]string{})
+			server.SetSecret("list/nested/c", map[string]string{})
+
+			names, err := client.List(ctx, "list")
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"a", "b", "nested/"}, names)
+
+			names, err = client.List(ctx, "empty")
+			assert.Nil(t, err)
+			assert.Empty(t, names)
+		},
+	)
+}
@@ -0,0 +1,238 @@
+// Package vaulttest provides an in-process fake vault for tests, with a KV
+// version 2 secrets engine and AppRole auth.
+package vaulttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	KvMount      = "secret"
+	AppRoleMount = "approle"
+
+	RootToken = "root-token"
+	RoleId    = "role-id"
+	SecretId  = "secret-id"
+
+	appRoleToken = "approle-token"
+)
+
+type version struct {
+	data    map[string]string
+	created time.Time
+}
+
+type Server struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	secrets map[string][]version
+	logins  int
+}
+
+func NewServer(t testing.TB) *Server {
+	server := &Server{secrets: map[string][]version{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/"+KvMount+"/data/", server.handleData)
+	mux.HandleFunc("/v1/"+KvMount+"/metadata/", server.handleMetadata)
+	mux.HandleFunc("/v1/auth/"+AppRoleMount+"/login", server.handleLogin)
+
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Secret returns the latest data of the secret at path, or nil if it doesn't
+// exist.
+func (server *Server) Secret(path string) map[string]string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	versions := server.secrets[path]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1].data
+}
+
+// SetSecret writes a version of the secret at path, as if written by another
+// client.
+func (server *Server) SetSecret(path string, data map[string]string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.secrets[path] = append(
+		server.secrets[path], version{data, time.Now()},
+	)
+}
+
+// Logins is how many times a client has logged in with AppRole.
+func (server *Server) Logins() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.logins
+}
+
+func (server *Server) handleData(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/"+KvMount+"/data/")
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	versions := server.secrets[path]
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(versions) == 0 {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		latest := versions[len(versions)-1]
+		writeJson(
+			w, http.StatusOK, map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     latest.data,
+					"metadata": metadata(latest, len(versions)),
+				},
+			},
+		)
+	case http.MethodPost, http.MethodPut:
+		var request struct {
+			Data    map[string]string `json:"data"`
+			Options struct {
+				Cas *int `json:"cas"`
+			} `json:"options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if request.Options.Cas != nil && *request.Options.Cas != len(versions) {
+			writeErrors(
+				w, http.StatusBadRequest,
+				"check-and-set parameter did not match the current version",
+			)
+			return
+		}
+
+		written := version{request.Data, time.Now()}
+		server.secrets[path] = append(versions, written)
+		writeJson(
+			w, http.StatusOK, map[string]interface{}{
+				"data": metadata(written, len(versions)+1),
+			},
+		)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (server *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	if r.Method != "LIST" && r.URL.Query().Get("list") != "true" {
+		writeErrors(w, http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/"+KvMount+"/metadata/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	// like vault, only the next part of the path is listed, with folders
+	// ending in a slash
+	seen := map[string]bool{}
+	var keys []string
+	for path := range server.secrets {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(path, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+	writeJson(
+		w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"keys": keys},
+		},
+	)
+}
+
+func (server *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RoleId   string `json:"role_id"`
+		SecretId string `json:"secret_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.RoleId != RoleId || request.SecretId != SecretId {
+		writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	server.lock.Lock()
+	server.logins++
+	server.lock.Unlock()
+	writeJson(
+		w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   appRoleToken,
+				"lease_duration": 3600,
+				"renewable":      true,
+			},
+		},
+	)
+}
+
+func authorized(r *http.Request) bool {
+	token := r.Header.Get("X-Vault-Token")
+	return token == RootToken || token == appRoleToken
+}
+
+func metadata(v version, number int) map[string]interface{} {
+	return map[string]interface{}{
+		"version":       number,
+		"created_time":  v.created.Format(time.RFC3339Nano),
+		"deletion_time": "",
+		"destroyed":     false,
+	}
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	if errors == nil {
+		errors = []string{}
+	}
+	writeJson(w, status, map[string]interface{}{"errors": errors})
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}